@@ -6,6 +6,7 @@ import (
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-chi/chi"
 	"golang.org/x/oauth2"
+	"html/template"
 	"net/http"
 	"strings"
 	"time"
@@ -31,6 +32,12 @@ type OAuth struct {
 	State    string
 }
 
+// ProfileLink is a link shown on the profile page of an account
+type ProfileLink struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+}
+
 type AccountMetadata struct {
 	Password              []byte             `json:"pw,omitempty"`
 	Key                   *SSHKey            `json:"key,omitempty"`
@@ -44,6 +51,7 @@ type AccountMetadata struct {
 	LikedIRI              string             `json:"liked,omitempty"`
 	FollowersIRI          string             `json:"followers,omitempty"`
 	FollowingIRI          string             `json:"following,omitempty"`
+	Links                 []ProfileLink      `json:"links,omitempty"`
 	OAuth                 OAuth              `json:-`
 	AuthorizationEndPoint string             `json:-`
 	TokenEndPoint         string             `json:-`
@@ -70,6 +78,15 @@ type Account struct {
 	Level     uint8                `json:"-"`
 	Parent    *Account             `json:"-"`
 	Children  AccountPtrCollection `json:"-"`
+	Roles     AccountRoles         `json:"-"`
+	Mutes     AccountMutes         `json:"-"`
+	Move      AccountMove          `json:"-"`
+	// Unread is the number of notifications the account didn't read yet
+	Unread int `json:"-"`
+	// Saved are the items the account saved for later
+	Saved SavedItems `json:"-"`
+	// Hidden are the items the account hid from its listings
+	Hidden Hashes `json:"-"`
 }
 
 var ValidActorTypes = pub.ActivityVocabularyTypes{
@@ -164,11 +181,50 @@ func (a *Account) HasIcon() bool {
 	return a.HasMetadata() && len(a.Metadata.Icon.URI) > 0
 }
 
+// DisplayName returns the name the account chose to be shown with, or its handle
+func (a *Account) DisplayName() string {
+	if a.HasMetadata() && len(a.Metadata.Name) > 0 {
+		return a.Metadata.Name
+	}
+	return a.Handle
+}
+
+// HasBio returns true if the account has a description of itself
+func (a *Account) HasBio() bool {
+	return a.HasMetadata() && len(a.Metadata.Blurb) > 0
+}
+
+// Bio returns the Markdown render of the account's description
+func (a *Account) Bio() template.HTML {
+	if !a.HasBio() {
+		return ""
+	}
+	return renderBio(a.Metadata.Blurb)
+}
+
+// renderBio renders the Markdown of an account's description, without the HTML it contains
+func renderBio(blurb []byte) template.HTML {
+	return Markdown(LocalHTMLPolicy.Sanitize(string(blurb)))
+}
+
+// ProfileLinks returns the links shown on the profile page of the account
+func (a *Account) ProfileLinks() []ProfileLink {
+	if !a.HasMetadata() {
+		return nil
+	}
+	return a.Metadata.Links
+}
+
 // Deleted
 func (a *Account) Deleted() bool {
 	return a != nil && (a.Flags&FlagsDeleted) == FlagsDeleted
 }
 
+// Delete add the deleted flag on an account
+func (a *Account) Delete() {
+	a.Flags |= FlagsDeleted
+}
+
 func (a Account) Type() RenderType {
 	return ActorType
 }
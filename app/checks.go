@@ -0,0 +1,429 @@
+package app
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+// CheckOutcome is the decision of a content check about an item or an account
+type CheckOutcome int
+
+const (
+	// CheckAllow lets the content be saved
+	CheckAllow CheckOutcome = iota
+	// CheckHold saves the content, but keeps it out of public view until a moderator approves it
+	CheckHold
+	// CheckReject refuses to save the content
+	CheckReject
+)
+
+func (o CheckOutcome) String() string {
+	switch o {
+	case CheckHold:
+		return "hold"
+	case CheckReject:
+		return "reject"
+	}
+	return "allow"
+}
+
+func parseCheckOutcome(s string) (CheckOutcome, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "allow", "":
+		return CheckAllow, nil
+	case "hold":
+		return CheckHold, nil
+	case "reject":
+		return CheckReject, nil
+	}
+	return CheckAllow, errors.Newf("invalid check outcome %q", s)
+}
+
+// CheckResult is the outcome of a content check, with the name of the check and the reason for it
+type CheckResult struct {
+	Outcome CheckOutcome
+	Check   string
+	Reason  string
+}
+
+// Allowed returns true if the content can be saved
+func (c CheckResult) Allowed() bool {
+	return c.Outcome != CheckReject
+}
+
+// Held returns true if the content needs to be approved by a moderator
+func (c CheckResult) Held() bool {
+	return c.Outcome == CheckHold
+}
+
+var checkAllowed = CheckResult{Outcome: CheckAllow}
+
+// ItemCheck verifies an item before it is saved
+type ItemCheck interface {
+	Name() string
+	CheckItem(ctx context.Context, it Item) CheckResult
+}
+
+// AccountCheck verifies an account before it is saved
+type AccountCheck interface {
+	Name() string
+	CheckAccount(ctx context.Context, a Account) CheckResult
+}
+
+// itemRecorder is an item check which needs to know about the items that were saved
+type itemRecorder interface {
+	ItemSaved(it Item)
+}
+
+// ContentChecks is the pipeline of checks which runs before items and accounts are saved.
+// The outcome of the pipeline is the most severe one of its checks.
+type ContentChecks struct {
+	items    []ItemCheck
+	accounts []AccountCheck
+}
+
+// Register adds the check to the pipeline, as an item check, an account check, or both
+func (c *ContentChecks) Register(check interface{}) {
+	if ic, ok := check.(ItemCheck); ok {
+		c.items = append(c.items, ic)
+	}
+	if ac, ok := check.(AccountCheck); ok {
+		c.accounts = append(c.accounts, ac)
+	}
+}
+
+// CheckItem runs the item checks, stopping at the first one that rejects the it item
+func (c *ContentChecks) CheckItem(ctx context.Context, it Item) CheckResult {
+	res := checkAllowed
+	if c == nil {
+		return res
+	}
+	for _, check := range c.items {
+		if r := check.CheckItem(ctx, it); r.Outcome > res.Outcome {
+			res = r
+			if res.Outcome == CheckReject {
+				break
+			}
+		}
+	}
+	return res
+}
+
+// ItemSaved lets the checks which keep track of the previous submissions know that the it item was saved
+func (c *ContentChecks) ItemSaved(it Item) {
+	if c == nil {
+		return
+	}
+	for _, check := range c.items {
+		if rec, ok := check.(itemRecorder); ok {
+			rec.ItemSaved(it)
+		}
+	}
+}
+
+// CheckAccount runs the account checks, stopping at the first one that rejects the a account
+func (c *ContentChecks) CheckAccount(ctx context.Context, a Account) CheckResult {
+	res := checkAllowed
+	if c == nil {
+		return res
+	}
+	for _, check := range c.accounts {
+		if r := check.CheckAccount(ctx, a); r.Outcome > res.Outcome {
+			res = r
+			if res.Outcome == CheckReject {
+				break
+			}
+		}
+	}
+	return res
+}
+
+const (
+	checkBannedWords     = "banned_words"
+	checkBlockedDomains  = "blocked_domains"
+	checkExcessiveLinks  = "excessive_links"
+	checkRepeatedContent = "repeated_content"
+	checkNewAccountLinks = "new_account_links"
+)
+
+// DefaultContentChecks are the outcomes used for the checks that are not present in the configuration
+const DefaultContentChecks = "banned_words=reject,blocked_domains=reject,excessive_links=hold," +
+	"repeated_content=hold,new_account_links=hold"
+
+// parseContentChecks parses a comma separated list of check=outcome values, eg: excessive_links=hold
+func parseContentChecks(s string) (map[string]CheckOutcome, error) {
+	outcomes := make(map[string]CheckOutcome)
+	for _, piece := range strings.Split(s, ",") {
+		if piece = strings.TrimSpace(piece); len(piece) == 0 {
+			continue
+		}
+		kv := strings.SplitN(piece, "=", 2)
+		if len(kv) != 2 {
+			return outcomes, errors.Newf("invalid content check %q", piece)
+		}
+		o, err := parseCheckOutcome(kv[1])
+		if err != nil {
+			return outcomes, err
+		}
+		outcomes[strings.ToLower(strings.TrimSpace(kv[0]))] = o
+	}
+	return outcomes, nil
+}
+
+// NewContentChecks builds the pipeline with the built-in checks enabled in the c configuration.
+// The checks with an invalid configuration are skipped and their errors returned.
+func NewContentChecks(c config.Configuration) (*ContentChecks, []error) {
+	errs := make([]error, 0)
+	outcomes, _ := parseContentChecks(DefaultContentChecks)
+	if custom, err := parseContentChecks(c.ContentChecks); err != nil {
+		errs = append(errs, err)
+	} else {
+		for k, o := range custom {
+			outcomes[k] = o
+		}
+	}
+
+	checks := new(ContentChecks)
+	if o := outcomes[checkBannedWords]; o != CheckAllow && (len(c.BannedWords) > 0 || len(c.BannedPatterns) > 0) {
+		check, err := newBannedWordsCheck(o, c.BannedWords, c.BannedPatterns)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		checks.Register(check)
+	}
+	if o := outcomes[checkBlockedDomains]; o != CheckAllow && len(c.BlockedDomains) > 0 {
+		checks.Register(newBlockedDomainsCheck(o, c.BlockedDomains))
+	}
+	if o := outcomes[checkExcessiveLinks]; o != CheckAllow && c.MaxLinks > 0 {
+		checks.Register(&excessiveLinksCheck{outcome: o, max: c.MaxLinks})
+	}
+	if o := outcomes[checkRepeatedContent]; o != CheckAllow && c.RepeatWindow > 0 {
+		checks.Register(newRepeatedContentCheck(o, c.RepeatWindow))
+	}
+	if o := outcomes[checkNewAccountLinks]; o != CheckAllow && c.NewAccountAge > 0 {
+		checks.Register(&newAccountLinksCheck{outcome: o, age: c.NewAccountAge})
+	}
+	return checks, errs
+}
+
+var linksRegexp = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"')\]]+`)
+
+// itemText returns the text of the it item that the checks look at
+func itemText(it Item) string {
+	return strings.Join([]string{it.Title, it.Data}, "\n")
+}
+
+// accountText returns the text of the a account that the checks look at
+func accountText(a Account) string {
+	text := []string{a.Handle}
+	if a.HasMetadata() {
+		text = append(text, a.Metadata.Name, string(a.Metadata.Blurb), a.Metadata.URL)
+		for _, l := range a.Metadata.Links {
+			text = append(text, l.Name, l.URL)
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+// itemLinks returns the URLs the it item links to: the submitted link and the ones in its content
+func itemLinks(it Item) []string {
+	links := make([]string, 0)
+	if it.IsLink() {
+		links = append(links, it.Data)
+	} else {
+		links = append(links, linksRegexp.FindAllString(it.Data, -1)...)
+	}
+	return links
+}
+
+// bannedWordsCheck matches the content against a list of banned words and regular expressions
+type bannedWordsCheck struct {
+	outcome  CheckOutcome
+	patterns []*regexp.Regexp
+}
+
+func newBannedWordsCheck(o CheckOutcome, words, patterns []string) (*bannedWordsCheck, error) {
+	c := &bannedWordsCheck{outcome: o}
+	for _, w := range words {
+		if w = strings.TrimSpace(w); len(w) > 0 {
+			c.patterns = append(c.patterns, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(w)+`\b`))
+		}
+	}
+	var err error
+	for _, p := range patterns {
+		re, e := regexp.Compile(p)
+		if e != nil {
+			err = errors.Annotatef(e, "invalid banned pattern %q", p)
+			continue
+		}
+		c.patterns = append(c.patterns, re)
+	}
+	return c, err
+}
+
+func (c *bannedWordsCheck) Name() string {
+	return checkBannedWords
+}
+
+func (c *bannedWordsCheck) match(s string) CheckResult {
+	for _, re := range c.patterns {
+		if m := re.FindString(s); len(m) > 0 {
+			return CheckResult{Outcome: c.outcome, Check: c.Name(), Reason: fmt.Sprintf("contains banned content %q", m)}
+		}
+	}
+	return checkAllowed
+}
+
+func (c *bannedWordsCheck) CheckItem(_ context.Context, it Item) CheckResult {
+	return c.match(itemText(it))
+}
+
+func (c *bannedWordsCheck) CheckAccount(_ context.Context, a Account) CheckResult {
+	return c.match(accountText(a))
+}
+
+// blockedDomainsCheck matches the links in the content against a list of blocked domains
+type blockedDomainsCheck struct {
+	outcome CheckOutcome
+	domains []string
+}
+
+func newBlockedDomainsCheck(o CheckOutcome, domains []string) *blockedDomainsCheck {
+	c := &blockedDomainsCheck{outcome: o}
+	for _, d := range domains {
+		if d = mutedDomain(d); len(d) > 0 {
+			c.domains = append(c.domains, d)
+		}
+	}
+	return c
+}
+
+func (c *blockedDomainsCheck) Name() string {
+	return checkBlockedDomains
+}
+
+func (c *blockedDomainsCheck) match(links []string) CheckResult {
+	for _, l := range links {
+		u, err := url.Parse(l)
+		if err != nil {
+			continue
+		}
+		if d := getDomain(u); domainInList(d, c.domains) {
+			return CheckResult{Outcome: c.outcome, Check: c.Name(), Reason: fmt.Sprintf("links to blocked domain %s", d)}
+		}
+	}
+	return checkAllowed
+}
+
+func (c *blockedDomainsCheck) CheckItem(_ context.Context, it Item) CheckResult {
+	return c.match(itemLinks(it))
+}
+
+func (c *blockedDomainsCheck) CheckAccount(_ context.Context, a Account) CheckResult {
+	return c.match(linksRegexp.FindAllString(accountText(a), -1))
+}
+
+// excessiveLinksCheck limits the number of links an item can contain
+type excessiveLinksCheck struct {
+	outcome CheckOutcome
+	max     int
+}
+
+func (c *excessiveLinksCheck) Name() string {
+	return checkExcessiveLinks
+}
+
+func (c *excessiveLinksCheck) CheckItem(_ context.Context, it Item) CheckResult {
+	if cnt := len(itemLinks(it)); cnt > c.max {
+		return CheckResult{Outcome: c.outcome, Check: c.Name(), Reason: fmt.Sprintf("contains %d links, more than %d", cnt, c.max)}
+	}
+	return checkAllowed
+}
+
+// repeatedContentCheck catches the accounts which submit the same content multiple times in a short period
+type repeatedContentCheck struct {
+	outcome CheckOutcome
+	window  time.Duration
+	m       sync.Mutex
+	seen    map[string]time.Time
+}
+
+func newRepeatedContentCheck(o CheckOutcome, window time.Duration) *repeatedContentCheck {
+	return &repeatedContentCheck{outcome: o, window: window, seen: make(map[string]time.Time)}
+}
+
+func (c *repeatedContentCheck) Name() string {
+	return checkRepeatedContent
+}
+
+func contentKey(it Item) string {
+	author := ""
+	if it.SubmittedBy != nil {
+		author = it.SubmittedBy.Hash.String()
+	}
+	text := strings.Join(strings.Fields(strings.ToLower(itemText(it))), " ")
+	return fmt.Sprintf("%s:%x", author, sha1.Sum([]byte(text)))
+}
+
+func (c *repeatedContentCheck) CheckItem(_ context.Context, it Item) CheckResult {
+	// NOTE(marius): we only look at new submissions, edits can't repeat the item they're updating
+	if it.Hash.IsValid() || len(strings.TrimSpace(it.Data)) == 0 {
+		return checkAllowed
+	}
+	now := time.Now().UTC()
+	key := contentKey(it)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	if last, ok := c.seen[key]; ok && now.Sub(last) <= c.window {
+		return CheckResult{Outcome: c.outcome, Check: c.Name(), Reason: "repeats content submitted recently"}
+	}
+	return checkAllowed
+}
+
+// ItemSaved records the content of the it item, so we can recognize it if it gets submitted again.
+// NOTE(marius): the items that the other checks reject never get here, so they don't count as repeats.
+func (c *repeatedContentCheck) ItemSaved(it Item) {
+	if it.Hash.IsValid() || len(strings.TrimSpace(it.Data)) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	for k, last := range c.seen {
+		if now.Sub(last) > c.window {
+			delete(c.seen, k)
+		}
+	}
+	c.seen[contentKey(it)] = now
+}
+
+// newAccountLinksCheck stops the new and the anonymous accounts from posting links
+type newAccountLinksCheck struct {
+	outcome CheckOutcome
+	age     time.Duration
+}
+
+func (c *newAccountLinksCheck) Name() string {
+	return checkNewAccountLinks
+}
+
+func (c *newAccountLinksCheck) CheckItem(_ context.Context, it Item) CheckResult {
+	if len(itemLinks(it)) == 0 {
+		return checkAllowed
+	}
+	if rateTierFor(it.SubmittedBy, c.age, time.Now().UTC()) == tierDefault {
+		return checkAllowed
+	}
+	return CheckResult{Outcome: c.outcome, Check: c.Name(), Reason: "contains links, which new accounts can't post yet"}
+}
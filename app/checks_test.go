@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContentChecks_CheckItem(t *testing.T) {
+	banned, err := newBannedWordsCheck(CheckReject, []string{"viagra"}, []string{`free\s+money`})
+	if err != nil {
+		t.Fatalf("newBannedWordsCheck() error: %s", err)
+	}
+	checks := new(ContentChecks)
+	checks.Register(banned)
+	checks.Register(newBlockedDomainsCheck(CheckReject, []string{"spam.example"}))
+	checks.Register(&excessiveLinksCheck{outcome: CheckHold, max: 2})
+	checks.Register(&newAccountLinksCheck{outcome: CheckHold, age: 24 * time.Hour})
+
+	old := &Account{Handle: "john", Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a"), CreatedAt: time.Now().Add(-30 * 24 * time.Hour)}
+	fresh := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1"), CreatedAt: time.Now()}
+
+	tests := []struct {
+		name  string
+		it    Item
+		want  CheckOutcome
+		check string
+	}{
+		{name: "clean", it: Item{SubmittedBy: old, Data: "just some text"}, want: CheckAllow},
+		{name: "banned word", it: Item{SubmittedBy: old, Data: "cheap VIAGRA here"}, want: CheckReject, check: checkBannedWords},
+		{name: "banned word inside other word", it: Item{SubmittedBy: old, Data: "noviagrahere"}, want: CheckAllow},
+		{name: "banned pattern", it: Item{SubmittedBy: old, Title: "free   money"}, want: CheckReject, check: checkBannedWords},
+		{name: "blocked domain", it: Item{SubmittedBy: old, MimeType: MimeTypeURL, Data: "https://www.spam.example/x"}, want: CheckReject, check: checkBlockedDomains},
+		{name: "blocked domain in content", it: Item{SubmittedBy: old, Data: "see https://a.spam.example/y"}, want: CheckReject, check: checkBlockedDomains},
+		{name: "few links", it: Item{SubmittedBy: old, Data: "https://a.example https://b.example"}, want: CheckAllow},
+		{name: "excessive links", it: Item{SubmittedBy: old, Data: "https://a.example https://b.example https://c.example"}, want: CheckHold, check: checkExcessiveLinks},
+		{name: "new account link", it: Item{SubmittedBy: fresh, MimeType: MimeTypeURL, Data: "https://a.example"}, want: CheckHold, check: checkNewAccountLinks},
+		{name: "new account text", it: Item{SubmittedBy: fresh, Data: "hello"}, want: CheckAllow},
+		{name: "reject wins over hold", it: Item{SubmittedBy: fresh, Data: "viagra https://a.example"}, want: CheckReject, check: checkBannedWords},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := checks.CheckItem(context.Background(), tt.it)
+			if res.Outcome != tt.want {
+				t.Errorf("CheckItem() = %s, want %s (%s: %s)", res.Outcome, tt.want, res.Check, res.Reason)
+			}
+			if res.Check != tt.check {
+				t.Errorf("CheckItem() check = %q, want %q", res.Check, tt.check)
+			}
+		})
+	}
+
+	acc := Account{Handle: "viagra-seller", Metadata: &AccountMetadata{}}
+	if res := checks.CheckAccount(context.Background(), acc); res.Outcome != CheckReject {
+		t.Errorf("CheckAccount() = %s, want %s", res.Outcome, CheckReject)
+	}
+}
+
+func TestRepeatedContentCheck(t *testing.T) {
+	c := newRepeatedContentCheck(CheckHold, time.Hour)
+	jane := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1")}
+	john := &Account{Handle: "john", Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a")}
+
+	ctx := context.Background()
+	if res := c.CheckItem(ctx, Item{SubmittedBy: jane, Data: "Buy now"}); res.Outcome != CheckAllow {
+		t.Errorf("first submission = %s, want %s", res.Outcome, CheckAllow)
+	}
+	if res := c.CheckItem(ctx, Item{SubmittedBy: jane, Data: "Buy now"}); res.Outcome != CheckAllow {
+		t.Errorf("submission which wasn't saved = %s, want %s", res.Outcome, CheckAllow)
+	}
+	c.ItemSaved(Item{SubmittedBy: jane, Data: "Buy now"})
+	if res := c.CheckItem(ctx, Item{SubmittedBy: john, Data: "Buy now"}); res.Outcome != CheckAllow {
+		t.Errorf("submission by other account = %s, want %s", res.Outcome, CheckAllow)
+	}
+	if res := c.CheckItem(ctx, Item{SubmittedBy: jane, Data: "  buy   NOW "}); res.Outcome != CheckHold {
+		t.Errorf("repeated submission = %s, want %s", res.Outcome, CheckHold)
+	}
+	edit := Item{SubmittedBy: jane, Hash: HashFromString("a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"), Data: "Buy now"}
+	if res := c.CheckItem(ctx, edit); res.Outcome != CheckAllow {
+		t.Errorf("edit = %s, want %s", res.Outcome, CheckAllow)
+	}
+}
+
+func TestParseContentChecks(t *testing.T) {
+	outcomes, err := parseContentChecks(DefaultContentChecks + ",excessive_links=allow, banned_words = HOLD")
+	if err != nil {
+		t.Fatalf("parseContentChecks() error: %s", err)
+	}
+	if o := outcomes[checkExcessiveLinks]; o != CheckAllow {
+		t.Errorf("excessive_links = %s, want %s", o, CheckAllow)
+	}
+	if o := outcomes[checkBannedWords]; o != CheckHold {
+		t.Errorf("banned_words = %s, want %s", o, CheckHold)
+	}
+	if _, err := parseContentChecks("banned_words=delete"); err == nil {
+		t.Errorf("parseContentChecks() expected error for invalid outcome")
+	}
+}
@@ -15,6 +15,9 @@ type FlagBits uint8
 const (
 	FlagsDeleted = FlagBits(1 << iota)
 	FlagsPrivate
+	FlagsLocked
+	FlagsHeld
+	FlagsPinned
 
 	FlagsNone = FlagBits(0)
 )
@@ -82,6 +85,7 @@ const (
 	AppreciationType
 	ActorType
 	ModerationType
+	HeldType
 )
 
 type Renderable interface {
@@ -102,6 +106,8 @@ type HasContent interface {
 type Item struct {
 	Hash        Hash              `json:"hash"`
 	Title       string            `json:"-"`
+	Summary     string            `json:"-"`
+	Sensitive   bool              `json:"-"`
 	MimeType    string            `json:"-"`
 	Data        string            `json:"-"`
 	Score       int               `json:"-"`
@@ -136,6 +136,18 @@ func FromActor(a *Account, p *pub.Actor) error {
 			Public: pub,
 		}
 	}
+	if len(name) > 0 && !name.Equals(pName) {
+		a.Metadata.Name = name.String()
+	}
+	if len(p.Source.Content) > 0 && p.Source.MediaType == MimeTypeMarkdown {
+		a.Metadata.Blurb = []byte(LocalHTMLPolicy.Sanitize(p.Source.Content.First().Value.String()))
+	} else if len(p.Summary) > 0 {
+		a.Metadata.Blurb = []byte(LocalHTMLPolicy.Sanitize(p.Summary.First().Value.String()))
+	}
+	if p.Attachment != nil {
+		a.Metadata.Links = profileLinksFromAttachments(p.Attachment)
+		a.Move.AlsoKnownAs = aliasesFromAttachments(p.Attachment)
+	}
 	if p.Endpoints != nil {
 		if p.Endpoints.OauthAuthorizationEndpoint != nil {
 			a.Metadata.AuthorizationEndPoint = p.Endpoints.OauthAuthorizationEndpoint.GetLink().String()
@@ -317,6 +329,33 @@ func BlueMondayPolicy() *bluemonday.Policy {
 	return p
 }
 
+// sensitiveIRI is the JSON-LD identifier of the "sensitive" property Mastodon and friends use to mark
+// the objects having their content behind a warning.
+const sensitiveIRI = pub.IRI("https://www.w3.org/ns/activitystreams#sensitive")
+
+// sensitiveTag returns the extension node we add to the tags of sensitive objects.
+func sensitiveTag() pub.Item {
+	// NOTE(marius): the vocabulary we use doesn't have a "sensitive" property, and FedBOX drops the properties
+	//   it doesn't know about, so we carry it as a tag referencing the property's IRI, which survives the round trip.
+	return &pub.Object{
+		URL:  sensitiveIRI,
+		Name: pub.NaturalLanguageValues{{Ref: pub.NilLangRef, Value: pub.Content("sensitive")}},
+	}
+}
+
+// isSensitiveTag returns true if t is the extension node that marks an object as sensitive
+func isSensitiveTag(t pub.Item) bool {
+	if t == nil || t.IsLink() {
+		return false
+	}
+	sensitive := false
+	pub.OnObject(t, func(o *pub.Object) error {
+		sensitive = o.URL != nil && o.URL.GetLink() == sensitiveIRI
+		return nil
+	})
+	return sensitive
+}
+
 func FromArticle(i *Item, a *pub.Object) error {
 	title := a.Name.First().Value
 
@@ -325,7 +364,7 @@ func FromArticle(i *Item, a *pub.Object) error {
 		i.Title = title.String()
 	}
 	i.MimeType = MimeTypeHTML
-	if len(a.Content) == 0 && a.URL != nil && len(a.URL.GetLink()) > 0 {
+	if len(a.Content) == 0 && a.Attachment == nil && a.URL != nil && len(a.URL.GetLink()) > 0 {
 		i.Data = string(a.URL.GetLink())
 		i.MimeType = MimeTypeURL
 	} else {
@@ -358,6 +397,15 @@ func FromArticle(i *Item, a *pub.Object) error {
 			return iconMetadataFromObject(&i.Metadata.Icon, o)
 		})
 	}
+	if a.Attachment != nil {
+		i.Metadata.Attachments = mediaFromAttachments(a.Attachment)
+	}
+	if i.IsLink() && a.Preview != nil {
+		pub.OnObject(a.Preview, func(o *pub.Object) error {
+			i.Metadata.Preview = new(LinkMetadata)
+			return linkPreviewFromObject(i.Metadata.Preview, o)
+		})
+	}
 	if a.Context != nil {
 		op := Item{}
 		op.FromActivityPub(a.Context)
@@ -385,9 +433,15 @@ func FromArticle(i *Item, a *pub.Object) error {
 			}
 		}
 	}
-	if len(i.Title) == 0 && a.InReplyTo == nil {
-		if a.Summary != nil && len(a.Summary) > 0 {
-			i.Title = bluemonday.StrictPolicy().Sanitize(a.Summary.First().Value.String())
+	if a.Summary != nil && len(a.Summary) > 0 {
+		summary := bluemonday.StrictPolicy().Sanitize(a.Summary.First().Value.String())
+		if len(i.Title) == 0 && a.InReplyTo == nil && len(a.Content) == 0 {
+			i.Title = summary
+		} else {
+			// NOTE(marius): when the object has content, the summary is a content warning,
+			//   which is how Mastodon and other microblogging services use it
+			i.Summary = summary
+			i.Sensitive = true
 		}
 	}
 	// TODO(marius): here we seem to have a bug, when Source.Content is nil when it shouldn't
@@ -401,8 +455,16 @@ func FromArticle(i *Item, a *pub.Object) error {
 		i.Metadata.Tags = make(TagCollection, 0)
 		i.Metadata.Mentions = make(TagCollection, 0)
 
+		apTags := make(pub.ItemCollection, 0, len(a.Tag))
+		for _, t := range a.Tag {
+			if isSensitiveTag(t) {
+				i.Sensitive = true
+				continue
+			}
+			apTags = append(apTags, t)
+		}
 		tags := TagCollection{}
-		tags.FromActivityPub(a.Tag)
+		tags.FromActivityPub(apTags)
 		for _, t := range tags {
 			if t.Type == TagTag {
 				i.Metadata.Tags = append(i.Metadata.Tags, t)
@@ -11,6 +11,9 @@ type Cursor struct {
 	before Hash
 	items  RenderableList
 	total  uint
+	// hidden is the number of items from the accounts the viewer blocked or ignored
+	hidden     int
+	showHidden bool
 }
 
 var emptyCursor = Cursor{}
@@ -0,0 +1,239 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// loadAccountItems loads the submissions and comments of the a account that were not already deleted
+func (r *repository) loadAccountItems(ctx context.Context, a Account) (ItemCollection, error) {
+	md := *a.Metadata
+	md.outbox = nil
+	a.Metadata = &md
+	if err := r.loadAccountsOutbox(ctx, &a); err != nil {
+		return nil, err
+	}
+	items := make(ItemCollection, 0)
+	for _, it := range a.Metadata.outbox {
+		if it.GetType() != pub.CreateType {
+			continue
+		}
+		i := Item{}
+		if err := i.FromActivityPub(it); err != nil || !i.IsValid() || i.Deleted() {
+			continue
+		}
+		items = append(items, i)
+	}
+	return items, nil
+}
+
+// tombstoneItems marks the items of the a account as deleted
+func tombstoneItems(a Account, items ItemCollection) ItemCollection {
+	result := make(ItemCollection, 0, len(items))
+	for _, it := range items {
+		it.SubmittedBy = &a
+		it.Delete()
+		result = append(result, it)
+	}
+	return result
+}
+
+// DeleteAccount tombstones the a account and removes the data we keep for it in the local storage.
+// The submissions and comments of the account are deleted when removeContent is true, otherwise they are kept
+// and shown as belonging to the deleted account.
+func (r *repository) DeleteAccount(ctx context.Context, a Account, removeContent bool) error {
+	if !a.IsValid() || !a.HasMetadata() || len(a.Metadata.ID) == 0 {
+		return errors.NotValidf("invalid account %s", a.Handle)
+	}
+	ltx := log.Ctx{"handle": a.Handle}
+	if removeContent {
+		items, err := r.loadAccountItems(ctx, a)
+		if err != nil {
+			return errors.Annotatef(err, "unable to load account's content")
+		}
+		for _, it := range tombstoneItems(a, items) {
+			if _, err := r.SaveItem(ctx, it); err != nil {
+				r.errFn(ltx, log.Ctx{"err": err.Error(), "item": it.Hash})("unable to delete item")
+			}
+		}
+		r.infoFn(ltx, log.Ctx{"count": len(items)})("deleted account's content")
+	}
+
+	// NOTE(marius): the actors are created by the application, so it's the one that can delete them
+	by := a
+	r.WithAccount(r.app)
+	defer r.WithAccount(&by)
+
+	a.Delete()
+	if _, err := r.SaveAccount(ctx, a); err != nil {
+		return errors.Annotatef(err, "unable to delete account")
+	}
+	r.removeAccountData(a)
+	return nil
+}
+
+// removeAccountData removes the data we keep for the a account in the local storage
+func (r *repository) removeAccountData(a Account) {
+	ltx := log.Ctx{"handle": a.Handle}
+	id := accountRoleID(a)
+	roles := make(AccountRoles, 0)
+	if err := r.store.Update(rolesCollection, &roles, func() error {
+		kept := make(AccountRoles, 0, len(roles))
+		for _, role := range roles {
+			if role.Account != id {
+				kept = append(kept, role)
+			}
+		}
+		roles = kept
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's roles")
+	}
+	mutes := make(accountsMutes)
+	if err := r.store.Update(mutesCollection, &mutes, func() error {
+		delete(mutes, id)
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's mutes")
+	}
+	emails := make(accountEmails)
+	if err := r.store.Update(accountEmailsCollection, &emails, func() error {
+		delete(emails, id)
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's email")
+	}
+	invitations := make(Invitations, 0)
+	if err := r.store.Update(invitationsCollection, &invitations, func() error {
+		for k, inv := range invitations {
+			if inv.IsAccepted() && inv.AcceptedBy == a.Handle {
+				invitations[k].Email = ""
+			}
+		}
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's invitation email")
+	}
+	confirmations := make(emailConfirmations)
+	if err := r.store.Update(emailConfirmationsCollection, &confirmations, func() error {
+		for k, c := range confirmations {
+			if c.Account == id {
+				delete(confirmations, k)
+			}
+		}
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's email confirmations")
+	}
+	tfa := make(twoFactors)
+	if err := r.store.Update(twoFactorCollection, &tfa, func() error {
+		delete(tfa, id)
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's two-factor authentication")
+	}
+	identities := make(accountIdentities)
+	if err := r.store.Update(identitiesCollection, &identities, func() error {
+		for k, v := range identities {
+			if v == id {
+				delete(identities, k)
+			}
+		}
+		return nil
+	}); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's linked logins")
+	}
+	if err := r.RemoveNotifications(a); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's notifications")
+	}
+	if err := r.RemoveSavedItems(a); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's saved items")
+	}
+	if err := r.RemoveHiddenItems(a); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to remove account's hidden items")
+	}
+	if err := r.RevokeAccountAccessTokens(a); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to revoke account's access tokens")
+	}
+	if err := r.RevokeSessions(a, time.Now()); err != nil {
+		r.errFn(ltx, log.Ctx{"err": err.Error()})("unable to revoke account's sessions")
+	}
+}
+
+// contentRemovalFromRequest returns true if the account deletion form asks for the account's submissions and
+// comments to be deleted, and false if it asks for them to be kept without their author
+func contentRemovalFromRequest(r *http.Request) (bool, error) {
+	switch r.PostFormValue("content") {
+	case "delete":
+		return true, nil
+	case "anonymize":
+		return false, nil
+	}
+	return false, errors.BadRequestf("choose what happens to your submissions and comments")
+}
+
+// HandleDeleteAccountForm serves /~{handle}/settings/delete request
+func (h *handler) HandleDeleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &deleteAccountModel{Title: "Delete account", User: acc, TwoFactor: h.storage.TwoFactorEnabled(*acc)}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleDeleteAccount serves /~{handle}/settings/delete POST request
+func (h *handler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings/delete", PermaLink(acc))
+
+	if !strings.EqualFold(strings.TrimSpace(r.PostFormValue("handle")), acc.Handle) {
+		h.v.addFlashMessage(Error, w, r, "Unable to delete account: the handle doesn't match")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	config := GetOauth2Config("fedbox", h.conf.BaseURL)
+	tok, err := config.PasswordCredentialsToken(context.TODO(), acc.Handle, r.PostFormValue("pw"))
+	if err != nil || tok == nil {
+		h.v.addFlashMessage(Error, w, r, "Unable to delete account: the password is not valid")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if h.storage.TwoFactorEnabled(*acc) {
+		if err = h.storage.VerifyTwoFactor(*acc, r.PostFormValue("code")); err != nil {
+			h.v.addFlashMessage(Error, w, r, "Unable to delete account: the authentication code is not valid")
+			h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+			return
+		}
+	}
+	removeContent, err := contentRemovalFromRequest(r)
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to delete account: %s", err))
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+
+	if err = h.storage.DeleteAccount(context.TODO(), *acc, removeContent); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to delete account")
+		h.v.addFlashMessage(Error, w, r, "Unable to delete account")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	h.infoFn(log.Ctx{"handle": acc.Handle, "removeContent": removeContent})("account deleted")
+
+	h.v.removeAccountFromSession(w, r)
+	h.v.addFlashMessage(Success, w, r, "Your account was deleted")
+	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+}
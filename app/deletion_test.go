@@ -0,0 +1,140 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentRemovalFromRequest(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+		wantErr bool
+	}{
+		{content: "delete", want: true},
+		{content: "anonymize", want: false},
+		{content: "", wantErr: true},
+		{content: "keep", wantErr: true},
+	}
+	for _, tt := range tests {
+		form := url.Values{"content": {tt.content}}
+		r := httptest.NewRequest("POST", "/~jane/settings/delete", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := contentRemovalFromRequest(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("contentRemovalFromRequest(%q) error = %v, wantErr %t", tt.content, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("contentRemovalFromRequest(%q) = %t, want %t", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestTombstoneItems(t *testing.T) {
+	jane := Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1")}
+	items := ItemCollection{
+		{Hash: HashFromString("a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"), Data: "first"},
+		{Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a"), Data: "second"},
+	}
+	deleted := tombstoneItems(jane, items)
+	if len(deleted) != len(items) {
+		t.Fatalf("tombstoneItems() returned %d items, want %d", len(deleted), len(items))
+	}
+	for _, it := range deleted {
+		if !it.Deleted() {
+			t.Errorf("item %s is not deleted", it.Hash)
+		}
+		if it.SubmittedBy == nil || it.SubmittedBy.Hash != jane.Hash {
+			t.Errorf("item %s is not submitted by %s", it.Hash, jane.Handle)
+		}
+	}
+	for _, it := range items {
+		if it.Deleted() {
+			t.Errorf("original item %s was modified", it.Hash)
+		}
+	}
+}
+
+func testRepository(t *testing.T) (*repository, func()) {
+	dir, err := ioutil.TempDir("", "littr-store")
+	if err != nil {
+		t.Fatalf("unable to create storage directory: %s", err)
+	}
+	r := &repository{store: newLocalStore(dir), infoFn: defaultCtxLogFn, errFn: defaultCtxLogFn}
+	return r, func() { os.RemoveAll(dir) }
+}
+
+func TestRepository_removeAccountData(t *testing.T) {
+	r, cleanup := testRepository(t)
+	defer cleanup()
+
+	jane := Account{Handle: "jane", Metadata: &AccountMetadata{ID: "https://example.com/actors/jane"}}
+	john := Account{Handle: "john", Metadata: &AccountMetadata{ID: "https://example.com/actors/john"}}
+
+	emails := accountEmails{
+		accountRoleID(jane): {Address: "jane@example.com", Verified: true},
+		accountRoleID(john): {Address: "john@example.com", Verified: true},
+	}
+	mutes := accountsMutes{
+		accountRoleID(jane): {Tags: []string{"spam"}},
+		accountRoleID(john): {Tags: []string{"news"}},
+	}
+	roles := AccountRoles{
+		{Role: RoleTagModerator, Scope: "go", Account: accountRoleID(jane)},
+		{Role: RoleTagModerator, Scope: "go", Account: accountRoleID(john)},
+	}
+	invitations := Invitations{
+		{Hash: "1", Email: "jane@example.com", AcceptedAt: time.Now(), AcceptedBy: "jane"},
+		{Hash: "2", Email: "john@example.com", AcceptedAt: time.Now(), AcceptedBy: "john"},
+	}
+	for name, v := range map[string]interface{}{
+		accountEmailsCollection: emails,
+		mutesCollection:         mutes,
+		rolesCollection:         roles,
+		invitationsCollection:   invitations,
+	} {
+		if err := r.store.Save(name, v); err != nil {
+			t.Fatalf("unable to save %s: %s", name, err)
+		}
+	}
+
+	r.removeAccountData(jane)
+
+	emails = make(accountEmails)
+	r.store.Load(accountEmailsCollection, &emails)
+	if _, ok := emails[accountRoleID(jane)]; ok {
+		t.Errorf("the email of the deleted account was kept")
+	}
+	if _, ok := emails[accountRoleID(john)]; !ok {
+		t.Errorf("the email of the other account was removed")
+	}
+	mutes = make(accountsMutes)
+	r.store.Load(mutesCollection, &mutes)
+	if _, ok := mutes[accountRoleID(jane)]; ok || len(mutes) != 1 {
+		t.Errorf("mutes = %v, want only the ones of %s", mutes, john.Handle)
+	}
+	roles = make(AccountRoles, 0)
+	r.store.Load(rolesCollection, &roles)
+	if len(roles) != 1 || roles[0].Account != accountRoleID(john) {
+		t.Errorf("roles = %v, want only the ones of %s", roles, john.Handle)
+	}
+	invitations = make(Invitations, 0)
+	r.store.Load(invitationsCollection, &invitations)
+	for _, inv := range invitations {
+		if inv.AcceptedBy == jane.Handle && len(inv.Email) > 0 {
+			t.Errorf("the invitation email of the deleted account was kept")
+		}
+		if inv.AcceptedBy == john.Handle && len(inv.Email) == 0 {
+			t.Errorf("the invitation email of the other account was removed")
+		}
+	}
+	if r.sessionsRevokedAt(jane).IsZero() {
+		t.Errorf("the sessions of the deleted account were not revoked")
+	}
+}
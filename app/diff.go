@@ -0,0 +1,100 @@
+package app
+
+import "strings"
+
+// DiffOp is the type of change a line went through between two revisions
+type DiffOp int8
+
+const (
+	DiffEqual  DiffOp = 0
+	DiffInsert DiffOp = 1
+	DiffDelete DiffOp = -1
+)
+
+// DiffLine is a line of text from a revision together with its change type
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+func (d DiffLine) Inserted() bool {
+	return d.Op == DiffInsert
+}
+
+func (d DiffLine) Deleted() bool {
+	return d.Op == DiffDelete
+}
+
+func splitLines(s string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(s, "\n")
+}
+
+// maxDiffSize is the maximum size of the table of common subsequences we compute for a diff,
+// larger texts get shown as entirely replaced
+const maxDiffSize = 1000 * 1000
+
+// diffLines computes a line based diff between the old and cur texts,
+// using the longest common subsequence of their lines.
+func diffLines(old, cur string) []DiffLine {
+	a := splitLines(old)
+	b := splitLines(cur)
+
+	if (len(a)+1)*(len(b)+1) > maxDiffSize {
+		return replaceLines(a, b)
+	}
+	// lcs[i][j] holds the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	result := make([]DiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, DiffLine{Op: DiffEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, DiffLine{Op: DiffDelete, Text: a[i]})
+			i++
+		default:
+			result = append(result, DiffLine{Op: DiffInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		result = append(result, DiffLine{Op: DiffDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		result = append(result, DiffLine{Op: DiffInsert, Text: b[j]})
+	}
+	return result
+}
+
+func replaceLines(a, b []string) []DiffLine {
+	result := make([]DiffLine, 0, len(a)+len(b))
+	for _, l := range a {
+		result = append(result, DiffLine{Op: DiffDelete, Text: l})
+	}
+	for _, l := range b {
+		result = append(result, DiffLine{Op: DiffInsert, Text: l})
+	}
+	return result
+}
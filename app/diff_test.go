@@ -0,0 +1,75 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_diffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		cur  string
+		want []DiffLine
+	}{
+		{
+			name: "empty",
+			old:  "",
+			cur:  "",
+			want: []DiffLine{},
+		},
+		{
+			name: "added",
+			old:  "",
+			cur:  "test",
+			want: []DiffLine{{Op: DiffInsert, Text: "test"}},
+		},
+		{
+			name: "removed",
+			old:  "test",
+			cur:  "",
+			want: []DiffLine{{Op: DiffDelete, Text: "test"}},
+		},
+		{
+			name: "unchanged",
+			old:  "line 1\nline 2",
+			cur:  "line 1\r\nline 2",
+			want: []DiffLine{{Op: DiffEqual, Text: "line 1"}, {Op: DiffEqual, Text: "line 2"}},
+		},
+		{
+			name: "changed-middle-line",
+			old:  "line 1\nline 2\nline 3",
+			cur:  "line 1\nline two\nline 3\nline 4",
+			want: []DiffLine{
+				{Op: DiffEqual, Text: "line 1"},
+				{Op: DiffDelete, Text: "line 2"},
+				{Op: DiffInsert, Text: "line two"},
+				{Op: DiffEqual, Text: "line 3"},
+				{Op: DiffInsert, Text: "line 4"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.old, tt.cur); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_diffLines_tooLarge(t *testing.T) {
+	old := strings.Repeat("line\n", 1500) + "old"
+	cur := strings.Repeat("line\n", 1500) + "new"
+
+	got := diffLines(old, cur)
+	if len(got) != 2*1501 {
+		t.Fatalf("diffLines() returned %d lines, want %d", len(got), 2*1501)
+	}
+	for i, l := range got {
+		if l.Op == DiffEqual {
+			t.Fatalf("diffLines() line %d is unchanged, large texts should be shown as replaced", i)
+		}
+	}
+}
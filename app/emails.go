@@ -0,0 +1,281 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/assets"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/mariusor/go-littr/internal/mail"
+)
+
+const emailConfirmationsCollection = "email-confirmations"
+
+// EmailConfirmationTTL is the time an email confirmation link can be used for
+const EmailConfirmationTTL = 24 * time.Hour
+
+// emailConfirmationMailTemplate is the template for the body of the email confirmation emails
+const emailConfirmationMailTemplate = "templates/mail/email-confirmation.txt"
+
+// accountEmail is the email address of a local account, and if the account proved it can receive messages at it
+type accountEmail struct {
+	Address  string `json:"address"`
+	Verified bool   `json:"verified,omitempty"`
+}
+
+// UnmarshalJSON loads the addresses saved as plain strings by the previous versions as not verified
+func (e *accountEmail) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*e = accountEmail{Address: s}
+		return nil
+	}
+	type plain accountEmail
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// accountsFor returns the IDs of the accounts which use the email address
+func (e accountEmails) accountsFor(email string) []string {
+	ids := make([]string, 0)
+	for id, ae := range e {
+		if len(ae.Address) > 0 && strings.EqualFold(ae.Address, email) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// accountFor returns the ID of the account which uses the email address.
+// NOTE(marius): the addresses saved before we checked their uniqueness can belong to more accounts,
+// in which case we don't pick one of them.
+func (e accountEmails) accountFor(email string) (string, accountEmail, error) {
+	ids := e.accountsFor(email)
+	switch len(ids) {
+	case 0:
+		return "", accountEmail{}, errors.NotFoundf("no account with email %s", email)
+	case 1:
+		return ids[0], e[ids[0]], nil
+	default:
+		return "", accountEmail{}, errors.NotValidf("more accounts use the email %s", email)
+	}
+}
+
+// emailInUse returns true if the email address belongs to an account other than a, or to the invitation
+// another account was created from
+func (r *repository) emailInUse(emails accountEmails, a Account, email string) (bool, error) {
+	for _, id := range emails.accountsFor(email) {
+		if id != accountRoleID(a) {
+			return true, nil
+		}
+	}
+	all := make(Invitations, 0)
+	if err := r.store.Load(invitationsCollection, &all); err != nil {
+		return false, err
+	}
+	for _, inv := range all {
+		if inv.IsAccepted() && inv.AcceptedBy != a.Handle && strings.EqualFold(inv.Email, email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// emailConfirmation is a pending change of the email address of an account, which waits for the account
+// to follow the link sent to the new address
+type emailConfirmation struct {
+	Account   string    `json:"account"`
+	Handle    string    `json:"handle"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (c emailConfirmation) valid(now time.Time) bool {
+	return len(c.Account) > 0 && now.Before(c.ExpiresAt)
+}
+
+// emailConfirmations are the pending email confirmations, keyed by the hash of their token.
+type emailConfirmations map[string]emailConfirmation
+
+// SaveEmailConfirmation creates the confirmation of the email address for the a account, valid for the ttl duration,
+// and returns its token. The pending confirmations of the account are invalidated, so only the link sent last can be used.
+func (r *repository) SaveEmailConfirmation(ctx context.Context, a Account, email string, ttl time.Duration) (string, emailConfirmation, error) {
+	ec := emailConfirmation{}
+	if !a.IsValid() || !a.HasMetadata() || len(a.Metadata.ID) == 0 {
+		return "", ec, errors.NotValidf("invalid account %s", a.Handle)
+	}
+	emails := make(accountEmails)
+	if err := r.store.Load(accountEmailsCollection, &emails); err != nil {
+		return "", ec, err
+	}
+	if used, err := r.emailInUse(emails, a, email); err != nil || used {
+		if err == nil {
+			err = errors.BadRequestf("the email address is used by another account")
+		}
+		return "", ec, err
+	}
+	token, err := newPasswordResetToken()
+	if err != nil {
+		return "", ec, err
+	}
+	now := time.Now().UTC()
+	ec = emailConfirmation{
+		Account:   a.Metadata.ID,
+		Handle:    a.Handle,
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	confirmations := make(emailConfirmations)
+	err = r.store.Update(emailConfirmationsCollection, &confirmations, func() error {
+		for k, c := range confirmations {
+			if !c.valid(now) || c.Account == ec.Account {
+				delete(confirmations, k)
+			}
+		}
+		confirmations[passwordResetKey(token)] = ec
+		return nil
+	})
+	return token, ec, err
+}
+
+// ConfirmEmail saves the email address of the confirmation with the token as the verified address of its account
+func (r *repository) ConfirmEmail(ctx context.Context, token string) (emailConfirmation, error) {
+	var ec emailConfirmation
+	confirmations := make(emailConfirmations)
+	err := r.store.Update(emailConfirmationsCollection, &confirmations, func() error {
+		var ok bool
+		key := passwordResetKey(token)
+		if ec, ok = confirmations[key]; !ok || !ec.valid(time.Now()) {
+			return errors.NotFoundf("invalid or expired email confirmation")
+		}
+		delete(confirmations, key)
+		return nil
+	})
+	if err != nil {
+		return ec, err
+	}
+	a, err := r.LoadAccount(ctx, pub.IRI(ec.Account))
+	if err != nil {
+		return ec, err
+	}
+	return ec, r.SaveAccountEmail(ctx, *a, ec.Email, true)
+}
+
+// emailConfirmationMail renders the email with the token confirmation link for the new address of the a account
+func emailConfirmationMail(c appConfig, a Account, token string, ec emailConfirmation) (mail.Message, error) {
+	m := mail.Message{
+		To:      ec.Email,
+		Subject: fmt.Sprintf("Confirm your email address on %s", c.Name),
+	}
+	raw, err := assets.Template(emailConfirmationMailTemplate)
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to load email confirmation template")
+	}
+	t, err := template.New("email-confirmation").Parse(string(raw))
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to parse email confirmation template")
+	}
+	// @todo(marius): :link_generation:
+	data := struct {
+		Handle    string
+		Name      string
+		BaseURL   string
+		URL       string
+		ExpiresAt time.Time
+	}{
+		Handle:    a.Handle,
+		Name:      c.Name,
+		BaseURL:   c.BaseURL,
+		URL:       fmt.Sprintf("%s/confirm-email/%s", c.BaseURL, token),
+		ExpiresAt: ec.ExpiresAt,
+	}
+	body := bytes.Buffer{}
+	if err = t.Execute(&body, data); err != nil {
+		return m, errors.Annotatef(err, "unable to render email confirmation")
+	}
+	m.Body = body.String()
+	return m, nil
+}
+
+// HandleChangeEmail serves /~{handle}/settings/email POST request
+func (h *handler) HandleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	ctx := context.TODO()
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+
+	config := GetOauth2Config("fedbox", h.conf.BaseURL)
+	tok, err := config.PasswordCredentialsToken(ctx, acc.Handle, r.PostFormValue("pw"))
+	if err != nil || tok == nil {
+		h.v.addFlashMessage(Error, w, r, "Unable to change email: the password is not valid")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if h.storage.TwoFactorEnabled(*acc) {
+		if err = h.storage.VerifyTwoFactor(*acc, r.PostFormValue("code")); err != nil {
+			h.v.addFlashMessage(Error, w, r, "Unable to change email: the authentication code is not valid")
+			h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+			return
+		}
+	}
+	email, err := emailFromRequest(r)
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, err.Error())
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if len(email) == 0 {
+		if err = h.storage.SaveAccountEmail(ctx, *acc, "", false); err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to remove account email")
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to remove email: %s", err))
+		} else {
+			h.v.addFlashMessage(Success, w, r, "Email address removed")
+		}
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+
+	// NOTE(marius): the address is saved only after the account follows the link we send to it
+	token, ec, err := h.storage.SaveEmailConfirmation(ctx, *acc, email, EmailConfirmationTTL)
+	var m mail.Message
+	if err == nil {
+		m, err = emailConfirmationMail(h.conf, *acc, token, ec)
+	}
+	if err == nil {
+		err = h.mail.Send(m)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to send email confirmation")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to change email: %s", err))
+	} else {
+		h.v.addFlashMessage(Info, w, r, fmt.Sprintf("We sent a confirmation link to %s, your email changes once you follow it", email))
+	}
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// HandleConfirmEmail serves /confirm-email/{token} request
+func (h *handler) HandleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	ec, err := h.storage.ConfirmEmail(context.TODO(), chi.URLParam(r, "token"))
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to confirm email")
+		h.v.addFlashMessage(Error, w, r, "The email confirmation link is invalid or it has expired")
+		h.v.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	h.infoFn(log.Ctx{"handle": ec.Handle})("email confirmed")
+	h.v.addFlashMessage(Success, w, r, "Your email address was confirmed")
+	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+}
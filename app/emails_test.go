@@ -0,0 +1,38 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAccountEmails_UnmarshalJSON(t *testing.T) {
+	emails := make(accountEmails)
+	raw := `{"https://example.com/actors/1":"jane@example.com","https://example.com/actors/2":{"address":"john@example.com","verified":true}}`
+	if err := json.Unmarshal([]byte(raw), &emails); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if e := emails["https://example.com/actors/1"]; e.Address != "jane@example.com" || e.Verified {
+		t.Errorf("legacy address = %+v, want jane@example.com, not verified", e)
+	}
+	if e := emails["https://example.com/actors/2"]; e.Address != "john@example.com" || !e.Verified {
+		t.Errorf("address = %+v, want john@example.com, verified", e)
+	}
+}
+
+func TestAccountEmails_accountFor(t *testing.T) {
+	emails := accountEmails{
+		"1": {Address: "jane@example.com", Verified: true},
+		"2": {Address: "John@example.com"},
+		"3": {Address: "john@EXAMPLE.com"},
+		"4": {},
+	}
+	if id, _, err := emails.accountFor("JANE@example.com"); err != nil || id != "1" {
+		t.Errorf("accountFor(jane) = %q, %v, want 1", id, err)
+	}
+	if _, _, err := emails.accountFor("john@example.com"); err == nil {
+		t.Errorf("accountFor(john) should fail for an address used by more accounts")
+	}
+	if _, _, err := emails.accountFor("nobody@example.com"); err == nil {
+		t.Errorf("accountFor(nobody) should fail for an unknown address")
+	}
+}
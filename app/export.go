@@ -0,0 +1,253 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	j "github.com/go-ap/jsonld"
+	"github.com/mariusor/go-littr/internal/assets"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// exportIndexTemplate is the template for the human readable index of the personal data archives
+const exportIndexTemplate = "templates/export/index.tmpl"
+
+// accountExport is the personal data of an account: its profile, the activities it published, and the data we keep
+// for it in the instance's local storage
+type accountExport struct {
+	Account     Account
+	Name        string
+	BaseURL     string
+	GeneratedAt time.Time
+	Email       string
+	Mutes       AccountMutes
+	Roles       AccountRoles
+	Submissions ItemCollection
+	Comments    ItemCollection
+	Votes       VoteCollection
+	Following   AccountCollection
+	Followers   AccountCollection
+	Blocked     AccountCollection
+	Saved       SavedItems
+	Hidden      HiddenItems
+
+	activities map[string]pub.ItemCollection
+}
+
+// exportCollections are the files of the archive with ActivityStreams collections, in the order they are written
+var exportCollections = []string{"outbox", "submissions", "comments", "votes", "follows", "blocks"}
+
+// loadExportActivities sorts the activities of the account's outbox in the collections of the archive
+func (e *accountExport) loadExportActivities(outbox pub.ItemCollection) {
+	e.activities = make(map[string]pub.ItemCollection)
+	for _, it := range outbox {
+		e.activities["outbox"] = append(e.activities["outbox"], it)
+		typ := it.GetType()
+		switch {
+		case typ == pub.CreateType:
+			i := Item{}
+			if err := i.FromActivityPub(it); err != nil || !i.IsValid() {
+				continue
+			}
+			if i.IsTop() {
+				e.Submissions = append(e.Submissions, i)
+				e.activities["submissions"] = append(e.activities["submissions"], it)
+			} else {
+				e.Comments = append(e.Comments, i)
+				e.activities["comments"] = append(e.activities["comments"], it)
+			}
+		case ValidAppreciationTypes.Contains(typ):
+			v := Vote{}
+			if err := v.FromActivityPub(it); err == nil {
+				e.Votes = append(e.Votes, v)
+			}
+			e.activities["votes"] = append(e.activities["votes"], it)
+		case typ == pub.FollowType:
+			e.activities["follows"] = append(e.activities["follows"], it)
+		case typ == pub.BlockType || typ == pub.IgnoreType:
+			e.activities["blocks"] = append(e.activities["blocks"], it)
+		}
+	}
+}
+
+// LoadAccountExport loads everything the a account has on the instance
+func (r *repository) LoadAccountExport(ctx context.Context, a Account) (*accountExport, error) {
+	if !a.IsValid() || !a.HasMetadata() {
+		return nil, errors.NotValidf("invalid account %s", a.Handle)
+	}
+	// NOTE(marius): we reload the collections of the account, as the ones loaded for the session might be incomplete
+	md := *a.Metadata
+	md.outbox = nil
+	a.Metadata = &md
+	a.Votes = nil
+	a.Blocked = nil
+	a.Ignored = nil
+	a.Followers = nil
+	a.Following = nil
+	if err := r.loadAccountsOutbox(ctx, &a); err != nil {
+		return nil, errors.Annotatef(err, "unable to load account's outbox")
+	}
+	if err := r.loadAccountsFollowers(ctx, &a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's followers")
+	}
+	if err := r.loadAccountsFollowing(ctx, &a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's following")
+	}
+
+	e := &accountExport{
+		Account:     a,
+		BaseURL:     Instance.BaseURL,
+		GeneratedAt: time.Now().UTC(),
+		Following:   a.Following,
+		Followers:   a.Followers,
+		Blocked:     a.Blocked,
+	}
+	if Instance.Conf != nil {
+		e.Name = Instance.Conf.Name
+	}
+	e.loadExportActivities(a.Metadata.outbox)
+
+	var err error
+	if e.Email, err = r.LoadAccountEmail(ctx, a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's email")
+	}
+	if e.Mutes, err = r.LoadAccountMutes(ctx, a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's mutes")
+	}
+	if e.Roles, err = r.LoadAccountRoles(ctx, a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's roles")
+	}
+	if e.Saved, err = r.LoadSavedItems(a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's saved items")
+	}
+	if e.Hidden, err = r.LoadHiddenItems(a); err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load account's hidden items")
+	}
+	return e, nil
+}
+
+func marshalActivityStreams(it pub.Item) ([]byte, error) {
+	return j.WithContext(j.IRI(pub.ActivityBaseURI)).Marshal(it)
+}
+
+// exportCollection wraps the items of an archive file in an ordered collection
+func exportCollection(name string, items pub.ItemCollection) *pub.OrderedCollection {
+	col := pub.OrderedCollectionNew(pub.ID(name))
+	col.OrderedItems = items
+	col.TotalItems = uint(len(items))
+	return col
+}
+
+func exportActors(name string, accounts AccountCollection) *pub.OrderedCollection {
+	items := make(pub.ItemCollection, 0, len(accounts))
+	for _, a := range accounts {
+		if a.HasMetadata() && len(a.Metadata.ID) > 0 {
+			items = append(items, pub.IRI(a.Metadata.ID))
+		}
+	}
+	return exportCollection(name, items)
+}
+
+// write saves the export as a zip archive with the ActivityStreams JSON files and a human readable index
+func (e *accountExport) write(w io.Writer, actor pub.Item) error {
+	z := zip.NewWriter(w)
+	add := func(name string, dat []byte) error {
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: e.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(dat)
+		return err
+	}
+	addAS := func(name string, it pub.Item) error {
+		dat, err := marshalActivityStreams(it)
+		if err != nil {
+			return errors.Annotatef(err, "unable to encode %s", name)
+		}
+		return add(name, dat)
+	}
+
+	if err := addAS("actor.json", actor); err != nil {
+		return err
+	}
+	for _, name := range exportCollections {
+		if err := addAS(name+".json", exportCollection(name, e.activities[name])); err != nil {
+			return err
+		}
+	}
+	if err := addAS("following.json", exportActors("following", e.Following)); err != nil {
+		return err
+	}
+	if err := addAS("followers.json", exportActors("followers", e.Followers)); err != nil {
+		return err
+	}
+
+	// NOTE(marius): the data from the local storage has no ActivityStreams representation
+	local := struct {
+		Email  string       `json:"email,omitempty"`
+		Mutes  AccountMutes `json:"mutes"`
+		Roles  AccountRoles `json:"roles,omitempty"`
+		Saved  SavedItems   `json:"saved,omitempty"`
+		Hidden HiddenItems  `json:"hidden,omitempty"`
+	}{Email: e.Email, Mutes: e.Mutes, Roles: e.Roles, Saved: e.Saved, Hidden: e.Hidden}
+	dat, err := json.MarshalIndent(local, "", "  ")
+	if err != nil {
+		return errors.Annotatef(err, "unable to encode local data")
+	}
+	if err = add("local.json", dat); err != nil {
+		return err
+	}
+
+	raw, err := assets.Template(exportIndexTemplate)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load export index template")
+	}
+	t, err := template.New("index").Parse(string(raw))
+	if err != nil {
+		return errors.Annotatef(err, "unable to parse export index template")
+	}
+	index := bytes.Buffer{}
+	if err = t.Execute(&index, e); err != nil {
+		return errors.Annotatef(err, "unable to render export index")
+	}
+	if err = add("index.html", index.Bytes()); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// HandleExport serves /~{handle}/settings/export request
+func (h *handler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	e, err := h.storage.LoadAccountExport(context.TODO(), *acc)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to load personal data")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	buf := bytes.Buffer{}
+	if err = e.write(&buf, h.storage.loadAPPerson(e.Account)); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to write personal data archive")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.infoFn(log.Ctx{"handle": acc.Handle})("personal data exported")
+	fileName := fmt.Sprintf("%s-%s.zip", acc.Handle, e.GeneratedAt.Format(dateParamFmt))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
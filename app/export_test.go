@@ -0,0 +1,87 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestAccountExport_loadExportActivities(t *testing.T) {
+	outbox := pub.ItemCollection{
+		&pub.Activity{Type: pub.LikeType, Object: pub.IRI("https://example.com/objects/1")},
+		&pub.Activity{Type: pub.DislikeType, Object: pub.IRI("https://example.com/objects/2")},
+		&pub.Activity{Type: pub.FollowType, Object: pub.IRI("https://example.com/actors/john")},
+		&pub.Activity{Type: pub.BlockType, Object: pub.IRI("https://example.com/actors/jim")},
+		&pub.Activity{Type: pub.IgnoreType, Object: pub.IRI("https://example.com/actors/joe")},
+		&pub.Activity{Type: pub.UpdateType, Object: pub.IRI("https://example.com/actors/jane")},
+	}
+	e := accountExport{}
+	e.loadExportActivities(outbox)
+
+	want := map[string]int{"outbox": 6, "votes": 2, "follows": 1, "blocks": 2, "submissions": 0, "comments": 0}
+	for name, cnt := range want {
+		if got := len(e.activities[name]); got != cnt {
+			t.Errorf("%s has %d activities, want %d", name, got, cnt)
+		}
+	}
+}
+
+func TestAccountExport_write(t *testing.T) {
+	// NOTE(marius): the templates are loaded relative to the root of the repository
+	cwd, _ := os.Getwd()
+	if err := os.Chdir(".."); err != nil {
+		t.Fatalf("unable to change directory: %s", err)
+	}
+	defer os.Chdir(cwd)
+
+	e := accountExport{
+		Account:     Account{Handle: "jane", Metadata: &AccountMetadata{ID: "https://example.com/actors/jane"}},
+		Name:        "example",
+		BaseURL:     "https://example.com",
+		GeneratedAt: time.Now().UTC(),
+		Email:       "jane@example.com",
+		Mutes:       AccountMutes{Tags: []string{"spam"}},
+	}
+	e.loadExportActivities(pub.ItemCollection{
+		&pub.Activity{Type: pub.FollowType, Object: pub.IRI("https://example.com/actors/john")},
+	})
+	buf := bytes.Buffer{}
+	if err := e.write(&buf, pub.PersonNew("https://example.com/actors/jane")); err != nil {
+		t.Fatalf("write() error: %s", err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unable to read the archive: %s", err)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("unable to open %s: %s", f.Name, err)
+		}
+		dat, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(dat)
+	}
+	for _, name := range append(exportCollections, "actor", "following", "followers", "local") {
+		if _, ok := files[name+".json"]; !ok {
+			t.Errorf("the archive is missing %s.json", name)
+		}
+	}
+	if !strings.Contains(files["follows.json"], "https://example.com/actors/john") {
+		t.Errorf("follows.json doesn't contain the followed actor: %s", files["follows.json"])
+	}
+	if !strings.Contains(files["local.json"], "jane@example.com") || !strings.Contains(files["local.json"], "spam") {
+		t.Errorf("local.json doesn't contain the local data: %s", files["local.json"])
+	}
+	if !strings.Contains(files["index.html"], "Personal data of jane") {
+		t.Errorf("the archive is missing the index page")
+	}
+}
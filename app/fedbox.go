@@ -70,6 +70,17 @@ func (f *fedbox) SignFn(signer client.RequestSignFn) {
 	f.client.SignFn(signer)
 }
 
+// withSignFn returns a copy of the client, with its own HTTP client that signs the requests with signer
+func (f *fedbox) withSignFn(signer client.RequestSignFn) *fedbox {
+	c := *f
+	c.client = client.New(
+		client.SetErrorLogger(optionLogFn(f.errFn)),
+		client.SetInfoLogger(optionLogFn(f.infoFn)),
+	)
+	c.client.SignFn(signer)
+	return &c
+}
+
 func SetUA(s string) OptionFn {
 	return func(f *fedbox) error {
 		client.UserAgent = s
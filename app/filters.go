@@ -357,6 +357,36 @@ func ModerationListing(next http.Handler) http.Handler {
 	})
 }
 
+// ReportsFiltersMw sets the filters for loading the reports received by the instance
+func ReportsFiltersMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := FiltersFromRequest(r)
+		f.Type = ActivityTypesFilter(pub.FlagType)
+		m := ContextListingModel(r.Context())
+		m.Title = "Open reports"
+		ctx := context.WithValue(r.Context(), FilterCtxtKey, []*Filters{f})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// OpenReportsMw removes from the current cursor the moderation groups that have been resolved.
+// It needs to run after ModerationListing.
+func OpenReportsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := ContextCursor(r.Context())
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for k, it := range c.items {
+			if mg, ok := it.(*ModerationGroup); ok && mg.IsResolved() {
+				delete(c.items, k)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func LoadInvitedMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		hash := chi.URLParam(r, "hash")
@@ -370,6 +400,10 @@ func LoadInvitedMw(next http.Handler) http.Handler {
 			return
 		}
 		ctx := context.TODO()
+		if err := s.validInvitation(ctx, hash); err != nil {
+			ctxtErr(next, w, r, err)
+			return
+		}
 		a, err := s.LoadAccount(ctx, actors.IRI(s.fedbox.Service()).AddPath(hash))
 		if err != nil {
 			ctxtErr(next, w, r, err)
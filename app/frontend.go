@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/gorilla/csrf"
 	"github.com/mariusor/go-littr/internal/config"
 	"github.com/mariusor/go-littr/internal/log"
+	"github.com/mariusor/go-littr/internal/mail"
 	"golang.org/x/oauth2"
 )
 
@@ -28,6 +30,9 @@ type handler struct {
 	conf    appConfig
 	v       *view
 	storage *repository
+	media   MediaStore
+	mail    mail.Sender
+	limiter *rateLimiter
 	logger  log.Logger
 	infoFn  CtxLogFn
 	errFn   CtxLogFn
@@ -136,6 +141,40 @@ func Init(c appConfig) (*handler, error) {
 			h.errFn(log.Ctx{"conf": config})("Failed to load OAuth2 ClientID")
 		}
 	}
+	if h.conf.UploadsEnabled {
+		// NOTE(marius): we assign the store only on success, a nil *fsMediaStore would make h.media a non nil interface
+		media, err := FSMediaStore(filepath.Join(h.conf.StoragePath, "media"))
+		if err != nil {
+			h.conf.UploadsEnabled = false
+			h.errFn(log.Ctx{"err": err})("Failed to initialize media storage")
+		} else {
+			h.media = media
+		}
+	}
+	mc := mail.Config{
+		Sink:     h.conf.MailSink,
+		Addr:     h.conf.SMTPAddr,
+		User:     h.conf.SMTPUser,
+		Password: h.conf.SMTPPassword,
+		From:     h.conf.MailFrom,
+		Path:     h.conf.MailPath,
+	}
+	if h.mail, err = mail.New(mc, mail.LogFn(h.infoFn(log.Ctx{"sink": mail.SinkLog}))); err != nil {
+		h.errFn(log.Ctx{"err": err, "sink": mc.Sink})("Failed to initialize mail delivery, invitations and password resets can not be sent")
+		h.mail = mail.Disabled(err)
+	}
+	limits, _ := parseRateLimits(DefaultRateLimits)
+	if custom, err := parseRateLimits(h.conf.RateLimits); err != nil {
+		h.errFn(log.Ctx{"err": err, "limits": h.conf.RateLimits})("Failed to parse rate limits, using the defaults")
+	} else {
+		for k, l := range custom {
+			limits[k] = l
+		}
+	}
+	h.limiter = newRateLimiter(limits)
+	if h.limiter.trusted, err = parseTrustedProxies(h.conf.TrustedProxies); err != nil {
+		h.errFn(log.Ctx{"err": err, "proxies": h.conf.TrustedProxies})("Failed to parse trusted proxies")
+	}
 	h.v, err = ViewInit(h.conf, h.infoFn, h.errFn)
 	if err != nil {
 		h.errFn(log.Ctx{"err": err})("Error initializing view")
@@ -154,6 +193,10 @@ func loggedAccount(r *http.Request) *Account {
 func (h *handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	provider := chi.URLParam(r, "provider")
+	if p, ok := h.conf.AuthProvider(provider); ok {
+		h.handleProviderCallback(w, r, p)
+		return
+	}
 	providerErr := q["error"]
 	if providerErr != nil {
 		errDescriptions := q["error_description"]
@@ -196,48 +239,15 @@ func (h *handler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	if err := h.v.saveAccountToSession(w, r, account); err != nil {
 		h.errFn()("Unable to save account to session")
 	}
+	if err := h.v.setSessionLoginTime(w, r, time.Now()); err != nil {
+		h.errFn()("Unable to save login time to session")
+	}
 	h.v.Redirect(w, r, "/", http.StatusFound)
 }
 
 func GetOauth2Config(provider string, localBaseURL string) oauth2.Config {
 	var config oauth2.Config
 	switch strings.ToLower(provider) {
-	case "github":
-		config = oauth2.Config{
-			ClientID:     os.Getenv("GITHUB_KEY"),
-			ClientSecret: os.Getenv("GITHUB_SECRET"),
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://github.com/login/oauth/authorize",
-				TokenURL: "https://github.com/login/oauth/access_token",
-			},
-		}
-	case "gitlab":
-		config = oauth2.Config{
-			ClientID:     os.Getenv("GITLAB_KEY"),
-			ClientSecret: os.Getenv("GITLAB_SECRET"),
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://gitlab.com/login/oauth/authorize",
-				TokenURL: "https://gitlab.com/login/oauth/access_token",
-			},
-		}
-	case "facebook":
-		config = oauth2.Config{
-			ClientID:     os.Getenv("FACEBOOK_KEY"),
-			ClientSecret: os.Getenv("FACEBOOK_SECRET"),
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://graph.facebook.com/oauth/authorize",
-				TokenURL: "https://graph.facebook.com/oauth/access_token",
-			},
-		}
-	case "google":
-		config = oauth2.Config{
-			ClientID:     os.Getenv("GOOGLE_KEY"),
-			ClientSecret: os.Getenv("GOOGLE_SECRET"),
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://accounts.google.com/o/oauth2/auth", // access_type=offline
-				TokenURL: "https://accounts.google.com/o/oauth2/token",
-			},
-		}
 	case "fedbox":
 		fallthrough
 	default:
@@ -280,6 +290,46 @@ func (v *view) saveAccountToSession(w http.ResponseWriter, r *http.Request, a Ac
 	return nil
 }
 
+// removeAccountFromSession logs out the current session, keeping it for the flash messages
+func (v *view) removeAccountFromSession(w http.ResponseWriter, r *http.Request) {
+	if !v.s.enabled || w == nil || r == nil {
+		return
+	}
+	if s, err := v.s.get(w, r); err == nil && s != nil {
+		delete(s.Values, SessionUserKey)
+		delete(s.Values, SessionLoginKey)
+	}
+}
+
+// setSessionLoginTime marks the current session as started at the t time
+func (v *view) setSessionLoginTime(w http.ResponseWriter, r *http.Request, t time.Time) error {
+	if !v.s.enabled || w == nil || r == nil {
+		return nil
+	}
+	s, err := v.s.get(w, r)
+	if err != nil {
+		return err
+	}
+	s.Values[SessionLoginKey] = t.Unix()
+	return nil
+}
+
+// sessionLoginTime returns the time the current session was started at,
+// or the zero time for the sessions started before we were keeping track of it
+func (v *view) sessionLoginTime(w http.ResponseWriter, r *http.Request) time.Time {
+	if !v.s.enabled || w == nil || r == nil {
+		return time.Time{}
+	}
+	s, err := v.s.get(w, r)
+	if err != nil {
+		return time.Time{}
+	}
+	if at, ok := s.Values[SessionLoginKey].(int64); ok {
+		return time.Unix(at, 0)
+	}
+	return time.Time{}
+}
+
 func (v *view) loadCurrentAccountFromSession(w http.ResponseWriter, r *http.Request) Account {
 	if !v.s.enabled || w == nil || r == nil {
 		return defaultAccount
@@ -379,9 +429,32 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 		h.storage.WithAccount(nil)
 		acc := AnonymousAccount
 		clearCookie := true
-		if h.v != nil {
+		token := bearerToken(r)
+		if h.v != nil && len(token) > 0 {
+			// NOTE(marius): the requests authenticated with access tokens don't use the session
+			a, t, err := h.loadTokenAccount(r, token)
+			if err != nil {
+				h.infoFn(log.Ctx{"err": err.Error()})("invalid access token")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				h.v.HandleErrors(w, r, errors.Unauthorizedf("invalid access token"))
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), AccessTokenCtxtKey, &t))
+			if !h.requireScope(w, r, requestScope(r)) {
+				return
+			}
+			acc = a
+			clearCookie = false
+			r = csrf.UnsafeSkipCheck(r)
+		} else if h.v != nil {
 			acc = h.v.loadCurrentAccountFromSession(w, r)
 			clearCookie = false
+			if acc.IsLogged() && h.sessionRevoked(w, r, acc) {
+				h.infoFn(log.Ctx{"handle": acc.Handle})("session started before the password change")
+				h.v.removeAccountFromSession(w, r)
+				h.v.addFlashMessage(Info, w, r, "Your password was changed, please log in again")
+				acc = AnonymousAccount
+			}
 		}
 		var ltx log.Ctx
 		if acc.IsLogged() {
@@ -407,6 +480,29 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 			}
 
 			h.storage.WithAccount(&acc)
+			if roles, err := h.storage.LoadAccountRoles(ctx, acc); err != nil {
+				h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's roles")
+			} else {
+				acc.Roles = roles
+			}
+			if mutes, err := h.storage.LoadAccountMutes(ctx, acc); err != nil {
+				h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's muted tags and domains")
+			} else {
+				acc.Mutes = mutes
+			}
+			if count, err := h.storage.UnreadNotificationsCount(acc); err != nil {
+				h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's notifications")
+			} else {
+				acc.Unread = count
+			}
+			if saved, err := h.storage.LoadSavedItems(acc); err != nil {
+				h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's saved items")
+			} else {
+				acc.Saved = saved
+			}
+			if err := h.storage.loadAccountHiddenItems(&acc); err != nil {
+				h.errFn(ltx, log.Ctx{"err": err.Error()})("Unable to load account's hidden items")
+			}
 			if len(acc.Followers) == 0 {
 				// TODO(marius): this needs to be moved to where we're handling all Inbox activities, not on page load
 				if err := h.storage.loadAccountsFollowers(ctx, &acc); err != nil {
@@ -431,11 +527,16 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 				}
 				h.infoFn(ltx, log.Ctx{"updated": acc.Metadata.OutboxUpdated.Format(time.StampMilli)})("Loaded account's outbox")
 				acc.Metadata.OutboxUpdated = time.Now()
+				// TODO(marius): this needs to be moved to where we're handling all Inbox activities, not on page load
+				h.storage.HandleIncomingMoves(acc)
+				h.storage.HandleIncomingNotifications(acc)
 			}
 		}
 		r = r.WithContext(context.WithValue(r.Context(), LoggedAccountCtxtKey, &acc))
 		if clearCookie {
 			h.v.s.clear(w, r)
+		} else if len(token) > 0 {
+			saveTokenAccount(token, acc)
 		} else if acc.IsLogged() && h.v != nil {
 			if err := h.v.saveAccountToSession(w, r, acc); err != nil {
 				h.errFn(ltx, log.Ctx{"err": err.Error()})("unable to save account to session")
@@ -446,6 +547,18 @@ func (h *handler) LoadSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// LimitRequestBodySize caps the size of request bodies to the maximum allowed upload size
+func (h handler) LimitRequestBodySize(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && h.conf.MaxUploadSize > 0 {
+			// NOTE(marius): we allow some extra room for the rest of the form fields
+			r.Body = http.MaxBytesReader(w, r.Body, h.conf.MaxUploadSize+1<<20)
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
 func (h handler) NeedsSessions(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		if !h.v.s.enabled {
@@ -460,7 +573,7 @@ func (h handler) NeedsSessions(next http.Handler) http.Handler {
 // HandleAbout serves /about request
 // It's something Mastodon compatible servers should show
 func (h *handler) HandleAbout(w http.ResponseWriter, r *http.Request) {
-	m := &aboutModel{Title: "About"}
+	m := &aboutModel{Title: "About", Bookmarklet: bookmarklet(h.conf.BaseURL)}
 
 	repo := h.storage
 	info, err := repo.LoadInfo()
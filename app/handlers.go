@@ -8,9 +8,9 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/gorilla/csrf"
 	"github.com/mariusor/go-littr/internal/log"
-	"github.com/mariusor/qstring"
 	"github.com/openshift/osin"
 	"golang.org/x/oauth2"
+	"html/template"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -46,31 +46,91 @@ func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
 		return
 	}
-	if c!= nil && len(c.items) > 0 && n.Parent.IsValid() {
-		if parent := getItemFromList(n.Parent.Hash, c.items); parent.IsValid() {
-			n.Parent = parent
-			if n.Parent.SubmittedBy.IsValid() {
-				if len(n.Metadata.To) == 0 {
-					n.Metadata.To = make([]Account, 0)
-				}
-				n.Metadata.To = append(n.Metadata.To, *n.Parent.SubmittedBy)
-			}
-			if n.Parent.Private() {
-				n.MakePrivate()
-				saveVote = false
+	upload, err := h.loadMediaFromRequest(r)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to load uploaded media")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	if len(n.Data) == 0 && !n.HasAttachments() && upload == nil {
+		h.v.HandleErrors(w, r, errors.BadRequestf("unable to submit empty item"))
+		return
+	}
+	if n.Parent.IsValid() {
+		var parent *Item
+		if c != nil && len(c.items) > 0 {
+			parent = getItemFromList(n.Parent.Hash, c.items)
+		}
+		if !parent.IsValid() {
+			p, err := h.storage.LoadItem(ctx, objects.IRI(h.storage.fedbox.Service()).AddPath(n.Parent.Hash.String()))
+			if err != nil {
+				h.v.HandleErrors(w, r, errors.NewNotFound(err, "parent item"))
+				return
 			}
-			if n.Parent.OP.IsValid() {
-				n.OP = n.Parent.OP
+			parent = &p
+		}
+		locked, err := h.storage.ThreadIsLocked(ctx, *parent)
+		if err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "hash": parent.Hash})("unable to load thread lock")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if locked {
+			h.v.HandleErrors(w, r, errors.Forbiddenf("the thread is locked and doesn't accept new replies"))
+			return
+		}
+		n.Parent = parent
+		if n.Parent.SubmittedBy.IsValid() {
+			if len(n.Metadata.To) == 0 {
+				n.Metadata.To = make([]Account, 0)
 			}
+			n.Metadata.To = append(n.Metadata.To, *n.Parent.SubmittedBy)
+		}
+		if n.Parent.Private() {
+			n.MakePrivate()
+			saveVote = false
+		}
+		if n.Parent.OP.IsValid() {
+			n.OP = n.Parent.OP
+		}
+	}
+
+	var media *MediaMetadata
+	if upload != nil {
+		// NOTE(marius): we store the uploaded file only after the item passed the checks above,
+		// so rejected submissions don't leave files behind
+		if media, err = h.storeUpload(*upload); err != nil {
+			h.errFn(log.Ctx{"err": err.Error()})("unable to save uploaded media")
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		n.Metadata.Attachments = append(n.Metadata.Attachments, *media)
+		if n.SubmittedAt.IsZero() {
+			n.SubmittedAt = time.Now().UTC()
+			n.UpdatedAt = n.SubmittedAt
 		}
 	}
 
 	repo := h.storage
 	if n, err = repo.SaveItem(ctx, n); err != nil {
+		if media != nil {
+			if err := deleteMedia(h.media, *media); err != nil {
+				h.errFn(log.Ctx{"err": err.Error()})("unable to remove uploaded media")
+			}
+		}
 		h.errFn(log.Ctx{"err": err.Error()})("unable to save item")
 		h.v.HandleErrors(w, r, err)
 		return
 	}
+	if n.IsLink() && len(n.Title) == 0 {
+		// NOTE(marius): we don't want to keep the user waiting for a third party server,
+		// so we load the link's metadata in the background and update the item afterwards
+		go func(repo *repository, it Item) {
+			if _, err := repo.LoadLinkMetadata(context.Background(), it); err != nil {
+				h.errFn(log.Ctx{"err": err.Error(), "hash": it.Hash})("unable to update item with link metadata")
+			}
+		}(repo.forAccount(acc), n)
+	}
 
 	if saveVote {
 		v := Vote{
@@ -87,10 +147,42 @@ func (h *handler) HandleSubmit(w http.ResponseWriter, r *http.Request) {
 			})("unable to save vote for item")
 		}
 	}
+	if n.IsHeld() {
+		h.v.addFlashMessage(Info, w, r, "Your submission is waiting for the approval of a moderator, until then only you can see it")
+	}
 	acc.Metadata.OutboxUpdated = time.Time{}
 	h.v.Redirect(w, r, ItemPermaLink(&n), http.StatusSeeOther)
 }
 
+// HandleItemHistory serves /~{handle}/{hash}/history and /{year}/{month}/{day}/{hash}/history requests
+func (h *handler) HandleItemHistory(w http.ResponseWriter, r *http.Request) {
+	var it *Item
+	hash := HashFromString(chi.URLParam(r, "hash"))
+	if c := ContextCursor(r.Context()); c != nil {
+		it = getItemFromList(hash, c.items)
+	}
+	if !it.IsValid() || it.Deleted() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("item not found"))
+		return
+	}
+	revisions, err := h.storage.LoadItemRevisions(context.TODO(), *it)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": it.Hash})("unable to load item revisions")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	title := it.Title
+	if len(title) == 0 {
+		title = it.Hash.String()
+	}
+	m := &historyModel{
+		Title:     fmt.Sprintf("History of %s", title),
+		Content:   it,
+		Revisions: itemRevisionDiffs(revisions),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
 // HandleDelete serves /{year}/{month}/{day}/{hash}/rm POST request
 // HandleDelete serves /~{handle}/rm GET request
 func (h *handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
@@ -110,15 +202,48 @@ func (h *handler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	if !strings.Contains(backUrl, url) && strings.Contains(backUrl, Instance.BaseURL) {
 		url = fmt.Sprintf("%s#li-%s", backUrl, p.Hash)
 	}
-	p.Delete()
-	if p, err = repo.SaveItem(ctx, p); err != nil {
-		h.v.addFlashMessage(Error, w, r, "unable to delete item as current user")
+	if !isItemAuthor(acc, &p) && acc.CanModerate(&p) {
+		// NOTE(marius): moderators remove other people's items with a Delete activity of their own
+		if err = repo.ModerateDeleteItem(ctx, *acc, p, nil); err != nil {
+			h.v.addFlashMessage(Error, w, r, "unable to delete item as moderator")
+		}
+	} else {
+		p.Delete()
+		if p, err = repo.SaveItem(ctx, p); err != nil {
+			h.v.addFlashMessage(Error, w, r, "unable to delete item as current user")
+		}
 	}
 
 	acc.Metadata.OutboxUpdated = time.Time{}
 	h.v.Redirect(w, r, url, http.StatusFound)
 }
 
+// HandleLock serves /{year}/{month}/{day}/{hash}/lock and /{year}/{month}/{day}/{hash}/unlock requests
+func (h *handler) HandleLock(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	repo := h.storage
+	ctx := context.TODO()
+	p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.errFn()("Error: %s", err)
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
+		return
+	}
+	op := path.Base(r.URL.Path)
+	if op == "lock" {
+		err = repo.LockItem(ctx, *acc, p)
+	} else {
+		err = repo.UnlockItem(ctx, p)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": p.Hash})("unable to %s item", op)
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s item: %s", op, err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Item %sed", op))
+	}
+	h.v.Redirect(w, r, ItemPermaLink(&p), http.StatusFound)
+}
+
 // HandleVoting serves /{year}/{month}/{day}/{hash}/{direction} request
 // HandleVoting serves /~{handle}/{direction} request
 func (h *handler) HandleVoting(w http.ResponseWriter, r *http.Request) {
@@ -354,6 +479,9 @@ func (h *handler) ReportItem(w http.ResponseWriter, r *http.Request) {
 
 const SessionUserKey = "__current_acct"
 
+// SessionLoginKey holds the unix time of the login, used to expire the sessions started before a password change
+const SessionLoginKey = "__login_at"
+
 // ShowLogin handles POST /login requests
 func (h *handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	pw := r.PostFormValue("pw")
@@ -402,18 +530,34 @@ func (h *handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	acct.Metadata.OAuth.Provider = "fedbox"
 	acct.Metadata.OAuth.Token = tok
-	s, err := h.v.s.get(w, r)
-	if err != nil {
+	if err = h.loginAccount(w, r, acct); err != nil {
 		handleErr("Login failed: unable to save session", log.Ctx{
 			"handle": handle,
 			"client": config.ClientID,
 			"state":  state,
 			"error":  fmt.Sprintf("%s", err),
 		})
-		return
+	}
+}
+
+// loginAccount saves the acct account to the session and redirects to the front page.
+// If the account has two-factor authentication enabled, it's logged in only after it enters its second factor.
+func (h *handler) loginAccount(w http.ResponseWriter, r *http.Request, acct Account) error {
+	s, err := h.v.s.get(w, r)
+	if err != nil {
+		return err
+	}
+	if h.storage.TwoFactorEnabled(acct) {
+		delete(s.Values, SessionUserKey)
+		s.Values[SessionPendingUserKey] = acct
+		s.Values[SessionPendingAtKey] = time.Now().Unix()
+		h.v.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return nil
 	}
 	s.Values[SessionUserKey] = acct
+	s.Values[SessionLoginKey] = time.Now().Unix()
 	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+	return nil
 }
 
 // HandleLogout serves /logout requests
@@ -445,8 +589,24 @@ func (h *handler) ValidateLoggedIn(eh ErrorHandler) Handler {
 	}
 }
 
-func (h *handler) ValidateItemAuthor(op string) Handler {
-	return func (next http.Handler) http.Handler {
+func isItemAuthor(acc *Account, it *Item) bool {
+	return it.SubmittedBy != nil && it.SubmittedBy.Hash == acc.Hash
+}
+
+func canModerateItem(acc *Account, it *Item) bool {
+	return acc.CanModerate(it)
+}
+
+// authorScope is the scope of the access tokens for changing the account's own items, and for moderating the others
+func authorScope(acc *Account, it *Item) string {
+	if isItemAuthor(acc, it) {
+		return ScopeWrite
+	}
+	return ScopeModerate
+}
+
+func (h *handler) validateItem(op string, scope string, allowFns ...func(*Account, *Item) bool) Handler {
+	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.TODO()
 			acc := loggedAccount(r)
@@ -460,19 +620,123 @@ func (h *handler) ValidateItemAuthor(op string) Handler {
 					ctxtErr(next, w, r, errors.NewNotFound(err, "item"))
 					return
 				}
-				if m.SubmittedBy.Hash != acc.Hash {
+				allowed := false
+				for _, allowFn := range allowFns {
+					if allowed = allowFn(acc, &m); allowed {
+						break
+					}
+				}
+				if !allowed {
 					url.Path = path.Dir(url.Path)
 					h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s item as current user", op))
 					h.v.Redirect(w, r, url.RequestURI(), http.StatusTemporaryRedirect)
 					return
 				}
-				next.ServeHTTP(w, r)
+				sc := scope
+				if len(sc) == 0 {
+					sc = authorScope(acc, &m)
+				}
+				if !h.requireScope(w, r, sc) {
+					return
+				}
+				if !isItemAuthor(acc, &m) && !h.requireTwoFactor(w, r) {
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ValidateItemAuthor allows access only to the author of the current item
+func (h *handler) ValidateItemAuthor(op string) Handler {
+	return h.validateItem(op, ScopeWrite, isItemAuthor)
+}
+
+// ValidateItemModerator allows access only to the accounts that can moderate the current item
+func (h *handler) ValidateItemModerator(op string) Handler {
+	return h.validateItem(op, ScopeModerate, canModerateItem)
+}
+
+// ValidateItemAuthorOrModerator allows access to the author of the current item and to the accounts that can moderate it.
+// The access tokens of the moderators need the moderate scope for the items of other accounts.
+func (h *handler) ValidateItemAuthorOrModerator(op string) Handler {
+	return h.validateItem(op, "", isItemAuthor, canModerateItem)
+}
+
+// ValidateModerator allows access only to accounts that have a moderation role
+func (h *handler) ValidateModerator(eh ErrorHandler) Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !h.conf.ModerationEnabled || !loggedAccount(r).HasModerationRole() {
+				e := errors.Forbiddenf("Please login as a moderator to perform this action")
+				h.errFn()("Error: %s", e)
+				eh(w, r, e)
+				return
+			}
+			if !h.requireScope(w, r, ScopeModerate) || !h.requireTwoFactor(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ValidateAdmin allows access only to the instance administrators
+func (h *handler) ValidateAdmin(eh ErrorHandler) Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !loggedAccount(r).IsAdmin() {
+				e := errors.Forbiddenf("Please login as an administrator to perform this action")
+				h.errFn()("Error: %s", e)
+				eh(w, r, e)
+				return
+			}
+			if !h.requireScope(w, r, ScopeModerate) || !h.requireTwoFactor(w, r) {
+				return
 			}
+			next.ServeHTTP(w, r)
 		}
 		return http.HandlerFunc(fn)
 	}
 }
 
+// HandleResolveReport serves /moderation/reports/{hash} POST request
+func (h *handler) HandleResolveReport(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ctx := context.TODO()
+
+	reason, err := ContentFromRequest(r, *acc)
+	if err != nil {
+		h.errFn(log.Ctx{"before": err})("Error: wrong http method")
+		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
+		return
+	}
+	repo := h.storage
+	report, err := repo.LoadModerationOp(ctx, activities.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.errFn(log.Ctx{"err": err})("invalid report to resolve")
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "report"))
+		return
+	}
+	if !acc.CanModerate(report.Object) {
+		h.v.addFlashMessage(Error, w, r, "Unable to resolve report as current user")
+		h.v.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+		return
+	}
+	res := ReportResolution(r.PostFormValue("action"))
+	if err = repo.ResolveReport(ctx, *acc, report, res, &reason); err != nil {
+		h.errFn(log.Ctx{"err": err, "action": res})("unable to resolve report")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s report: %s", res, err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Report %s", pastTenseVerb(template.HTML(res))))
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+}
+
 // HandleItemRedirect serves /i/{hash} request
 func (h *handler) HandleItemRedirect(w http.ResponseWriter, r *http.Request) {
 	repo := h.storage
@@ -518,39 +782,77 @@ func getPassCode(h *handler, acc *Account, invitee *Account, r *http.Request) (s
 	return d.Code, nil
 }
 
-// HandleSendInvite handles POST /invite requests
-func (h *handler) HandleSendInvite(w http.ResponseWriter, r *http.Request) {
-	acc := loggedAccount(r)
-	email := r.PostFormValue("email")
-	if len(email) == 0 {
-		h.v.HandleErrors(w, r, errors.BadRequestf("invalid email"))
-		return
+// accountAuthorizationCode requests from FedBOX an authorization code for the a account.
+// NOTE(marius): the authorization code is requested on behalf of the application.
+func (h *handler) accountAuthorizationCode(r *http.Request, a Account) (string, error) {
+	if !a.HasMetadata() || len(a.Metadata.ID) == 0 {
+		return "", errors.NotValidf("invalid account %s", a.Handle)
 	}
+	h.storage.WithAccount(h.storage.app)
+	defer h.storage.WithAccount(loggedAccount(r))
 
-	invitee, err := h.storage.SaveAccount(context.TODO(), Account{ CreatedBy: acc })
+	// TODO(marius): Start oauth2 authorize session
+	config := GetOauth2Config("fedbox", h.conf.BaseURL)
+	config.Scopes = []string{scopeAnonymousUserCreate}
+	param := oauth2.SetAuthURLParam("actor", a.Metadata.ID)
+	sessUrl := config.AuthCodeURL(csrf.Token(r), param)
 
+	res, err := h.storage.fedbox.client.Get(sessUrl)
 	if err != nil {
-		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save account"))
-		return
+		return "", err
 	}
-	if !invitee.IsValid() || !invitee.HasMetadata() || invitee.Metadata.ID == "" {
-		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "invalid account saved"))
-		return
+
+	var body []byte
+	if body, err = ioutil.ReadAll(res.Body); err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		if incoming, e := errors.UnmarshalJSON(body); e == nil && len(incoming) > 0 {
+			return "", incoming[0]
+		}
+		return "", errors.WrapWithStatus(res.StatusCode, errors.Newf(""), "invalid response")
+	}
+	d := osin.AuthorizeData{}
+	if err := json.Unmarshal(body, &d); err != nil {
+		return "", err
 	}
+	if d.Code == "" {
+		return "", errors.NotValidf("unable to get authorization code for account %s", a.Handle)
+	}
+	return d.Code, nil
+}
 
-	// @todo(marius): :link_generation:
-	u := fmt.Sprintf("%s/register/%s", h.conf.BaseURL, invitee.Hash)
-	bodyFmt := "Hello %s,\nThis is an invitation to join %s: %s.\nTo accept this invitation and create an account, visit the URL below: %s\n/%s"
-	mailContent := struct {
-		Subject string `qstring:subject`
-		Body    string `qstring:body`
-	}{
-		Subject: fmt.Sprintf("You are invited to join %s", h.conf.HostName),
-		Body:    fmt.Sprintf(bodyFmt, email, h.conf.HostName, h.conf.BaseURL, u, acc.Handle),
+// setAccountPassword sets the password of the a account through FedBOX's /oauth/pw flow.
+func (h *handler) setAccountPassword(r *http.Request, a Account, pw, pwConfirm string) error {
+	code, err := h.accountAuthorizationCode(r, a)
+	if err != nil {
+		return err
 	}
-	q, _ := qstring.Marshal(&mailContent)
-	h.v.Redirect(w, r, fmt.Sprintf("mailto:%s?%s", email, q), http.StatusSeeOther)
-	return
+
+	pwChURL := fmt.Sprintf("%s/oauth/pw", h.storage.BaseURL())
+	u, _ := url.Parse(pwChURL)
+	q := u.Query()
+	q.Set("s", code)
+	u.RawQuery = q.Encode()
+	form := url.Values{}
+	form.Add("pw", pw)
+	form.Add("pw-confirm", pwConfirm)
+
+	pwChRes, err := http.Post(u.String(), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(pwChRes.Body)
+	if err != nil {
+		return err
+	}
+	if pwChRes.StatusCode != http.StatusOK {
+		if err = h.storage.handlerErrorResponse(body); err == nil {
+			err = errors.WrapWithStatus(pwChRes.StatusCode, errors.Newf(""), "unable to set password")
+		}
+		return err
+	}
+	return nil
 }
 
 // HandleRegister handles POST /register requests
@@ -562,6 +864,14 @@ func (h *handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := context.TODO()
 
+	invite := r.PostFormValue("hash")
+	if len(invite) > 0 {
+		if err = h.storage.validInvitation(ctx, invite); err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+	}
+
 	f := &Filters{Name: CompStrs{EqualsString(a.Handle)}}
 	maybeExists, err := h.storage.account(ctx, f)
 	if err != nil && !errors.IsNotFound(err) {
@@ -587,69 +897,15 @@ func (h *handler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO(marius): Start oauth2 authorize session
-	config := GetOauth2Config("fedbox", h.conf.BaseURL)
-	config.Scopes = []string{scopeAnonymousUserCreate}
-	param := oauth2.SetAuthURLParam("actor", a.Metadata.ID)
-	sessUrl := config.AuthCodeURL(csrf.Token(r), param)
-
-	res, err := h.storage.fedbox.client.Get(sessUrl)
-	if err != nil {
-		h.v.HandleErrors(w, r, err)
-		return
-	}
-
-	var body []byte
-	if body, err = ioutil.ReadAll(res.Body); err != nil {
+	if err = h.setAccountPassword(r, a, r.PostFormValue("pw"), r.PostFormValue("pw-confirm")); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to set account password")
 		h.v.HandleErrors(w, r, err)
 		return
 	}
-	if res.StatusCode != http.StatusOK {
-		incoming, e := errors.UnmarshalJSON(body)
-		var errs []error
-		if e == nil {
-			errs = make([]error, len(incoming))
-			for i := range incoming {
-				errs[i] = incoming[i]
-			}
-		} else {
-			errs = []error{errors.WrapWithStatus(res.StatusCode, errors.Newf(""), "invalid response")}
+	if len(invite) > 0 {
+		if err = h.storage.AcceptInvitation(ctx, invite, a.Handle); err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "hash": invite})("unable to mark invitation as accepted")
 		}
-		h.v.HandleErrors(w, r, errs...)
-		return
-	}
-	d := osin.AuthorizeData{}
-	if err := json.Unmarshal(body, &d); err != nil {
-		h.v.HandleErrors(w, r, err)
-		return
-	}
-	if d.Code == "" {
-		h.v.HandleErrors(w, r, errors.NotValidf("unable to get session token for setting the user's password"))
-		return
-	}
-
-	// pos
-	pwChURL := fmt.Sprintf("%s/oauth/pw", h.storage.BaseURL())
-	u, _ := url.Parse(pwChURL)
-	q := u.Query()
-	q.Set("s", d.Code)
-	u.RawQuery = q.Encode()
-	form := url.Values{}
-	pw := r.PostFormValue("pw")
-	pwConfirm := r.PostFormValue("pw-confirm")
-
-	form.Add("pw", pw)
-	form.Add("pw-confirm", pwConfirm)
-
-	pwChRes, err := http.Post(u.String(), "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
-	if body, err = ioutil.ReadAll(pwChRes.Body); err != nil {
-		h.errFn()("Error: %s", err)
-		h.v.HandleErrors(w, r, err)
-		return
-	}
-	if pwChRes.StatusCode != http.StatusOK {
-		h.v.HandleErrors(w, r, h.storage.handlerErrorResponse(body))
-		return
 	}
 	h.v.Redirect(w, r, "/", http.StatusSeeOther)
 	return
@@ -0,0 +1,323 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const heldCollection = "held"
+
+const (
+	heldItem    = "item"
+	heldAccount = "account"
+)
+
+// heldEntry records which content check held an item or an account, and why
+type heldEntry struct {
+	Type   string    `json:"type"`
+	IRI    string    `json:"iri"`
+	Check  string    `json:"check"`
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// heldEntries are the items and accounts waiting for the approval of a moderator, keyed by their hash.
+// The ActivityPub vocabulary doesn't have a way to represent them, so we keep them in the instance's local storage.
+type heldEntries map[string]heldEntry
+
+// HeldContent is an item or an account that is shown in the moderation queue until a moderator approves it
+type HeldContent struct {
+	Hash   Hash
+	Object Renderable
+	Check  string
+	Reason string
+	HeldAt time.Time
+}
+
+func (h HeldContent) ID() Hash {
+	return h.Hash
+}
+
+// AP returns the underlying actvitypub item
+func (h *HeldContent) AP() pub.Item {
+	return h.Object.AP()
+}
+
+// IsValid returns true if the held object was loaded
+func (h *HeldContent) IsValid() bool {
+	return h != nil && h.Object != nil && h.Object.IsValid()
+}
+
+// Type
+func (h *HeldContent) Type() RenderType {
+	return HeldType
+}
+
+// Date
+func (h HeldContent) Date() time.Time {
+	return h.HeldAt
+}
+
+// IsItem returns true if the held object is an item
+func (h HeldContent) IsItem() bool {
+	return h.Object != nil && h.Object.Type() == CommentType
+}
+
+// canSeeHeld returns true if the viewer is the author of the held it item, or a moderator
+func canSeeHeld(viewer *Account, it *Item) bool {
+	if !viewer.IsLogged() {
+		return false
+	}
+	if it.SubmittedBy.IsValid() && it.SubmittedBy.Hash == viewer.Hash {
+		return true
+	}
+	return viewer.HasModerationRole()
+}
+
+func (r *repository) holdContent(typ string, hash Hash, iri pub.IRI, res CheckResult) error {
+	if !hash.IsValid() {
+		return errors.NotValidf("invalid %s hash", typ)
+	}
+	held := make(heldEntries)
+	return r.store.Update(heldCollection, &held, func() error {
+		held[hash.String()] = heldEntry{
+			Type:   typ,
+			IRI:    iri.String(),
+			Check:  res.Check,
+			Reason: res.Reason,
+			At:     time.Now().UTC(),
+		}
+		return nil
+	})
+}
+
+// heldCheck returns the outcome of the check which held the hash item or account, if it still waits for the approval of a moderator
+func (r *repository) heldCheck(hash Hash) (CheckResult, bool) {
+	held := make(heldEntries)
+	if err := r.store.Load(heldCollection, &held); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load held items")
+		return checkAllowed, false
+	}
+	e, ok := held[hash.String()]
+	if !ok {
+		return checkAllowed, false
+	}
+	return CheckResult{Outcome: CheckHold, Check: e.Check, Reason: e.Reason}, true
+}
+
+// loadItemsHeld sets the held flag on the items that wait for the approval of a moderator,
+// or which were submitted by accounts that wait for it
+func (r *repository) loadItemsHeld(items ...Item) ItemCollection {
+	if len(items) == 0 {
+		return items
+	}
+	held := make(heldEntries)
+	if err := r.store.Load(heldCollection, &held); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load held items")
+		return items
+	}
+	if len(held) == 0 {
+		return items
+	}
+	for k := range items {
+		it := &items[k]
+		if _, ok := held[it.Hash.String()]; ok {
+			it.Hold()
+		}
+		if it.SubmittedBy.IsValid() {
+			if _, ok := held[it.SubmittedBy.Hash.String()]; ok {
+				it.Hold()
+			}
+		}
+	}
+	return items
+}
+
+func (r *repository) loadHeldEntry(ctx context.Context, hash string, e heldEntry) (HeldContent, error) {
+	hc := HeldContent{Hash: HashFromString(hash), Check: e.Check, Reason: e.Reason, HeldAt: e.At}
+	switch e.Type {
+	case heldItem:
+		it, err := r.LoadItem(ctx, pub.IRI(e.IRI))
+		if err != nil {
+			return hc, err
+		}
+		hc.Object = &it
+	case heldAccount:
+		a, err := r.LoadAccount(ctx, pub.IRI(e.IRI))
+		if err != nil {
+			return hc, err
+		}
+		hc.Object = a
+	}
+	if !hc.IsValid() {
+		return hc, errors.NotFoundf("held %s %s", e.Type, hash)
+	}
+	return hc, nil
+}
+
+// LoadHeldContent loads the items and accounts that wait for the approval of a moderator
+func (r *repository) LoadHeldContent(ctx context.Context) ([]HeldContent, error) {
+	held := make(heldEntries)
+	if err := r.store.Load(heldCollection, &held); err != nil {
+		return nil, err
+	}
+	result := make([]HeldContent, 0)
+	for hash, e := range held {
+		hc, err := r.loadHeldEntry(ctx, hash, e)
+		if err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "iri": e.IRI})("unable to load held content")
+			continue
+		}
+		result = append(result, hc)
+	}
+	return result, nil
+}
+
+// LoadHeld loads the hash item or account that waits for the approval of a moderator
+func (r *repository) LoadHeld(ctx context.Context, hash Hash) (HeldContent, error) {
+	held := make(heldEntries)
+	if err := r.store.Load(heldCollection, &held); err != nil {
+		return HeldContent{}, err
+	}
+	e, ok := held[hash.String()]
+	if !ok {
+		return HeldContent{}, errors.NotFoundf("held content %s", hash)
+	}
+	return r.loadHeldEntry(ctx, hash.String(), e)
+}
+
+// ApproveHeld publishes the held content, which makes it visible to everyone
+func (r *repository) ApproveHeld(ctx context.Context, mod Account, hc HeldContent) error {
+	if err := r.releaseHeld(hc); err != nil {
+		return err
+	}
+	// NOTE(marius): the held content gets published on behalf of the application, as the moderator
+	//   can't act for the accounts which created it
+	r.WithAccount(r.app)
+	defer r.WithAccount(&mod)
+
+	var err error
+	typ := heldAccount
+	switch ob := hc.Object.(type) {
+	case *Item:
+		typ = heldItem
+		_, err = r.saveItem(ctx, *ob, checkAllowed, true)
+	case *Account:
+		_, err = r.saveAccount(ctx, *ob, checkAllowed)
+	default:
+		err = errors.BadRequestf("invalid held content %s", hc.Hash)
+	}
+	if err != nil {
+		// NOTE(marius): the content didn't get published, so we put it back in the moderation queue
+		res := CheckResult{Outcome: CheckHold, Check: hc.Check, Reason: hc.Reason}
+		if e := r.holdContent(typ, hc.Hash, hc.AP().GetLink(), res); e != nil {
+			r.errFn(log.Ctx{"err": e.Error(), "hash": hc.Hash})("unable to hold content for moderation")
+		}
+		return err
+	}
+	if it, ok := hc.Object.(*Item); ok {
+		r.Notify(it.SubmittedBy, moderationNotification("approved", *it))
+	}
+	return nil
+}
+
+// releaseHeld removes the held content from the moderation queue
+func (r *repository) releaseHeld(hc HeldContent) error {
+	held := make(heldEntries)
+	return r.store.Update(heldCollection, &held, func() error {
+		if _, ok := held[hc.Hash.String()]; !ok {
+			return errors.NotFoundf("%s is not held", hc.Hash)
+		}
+		delete(held, hc.Hash.String())
+		return nil
+	})
+}
+
+// RejectHeld removes the held item, or blocks the held account, on behalf of the mod moderator
+func (r *repository) RejectHeld(ctx context.Context, mod Account, hc HeldContent, reason *Item) error {
+	var err error
+	switch ob := hc.Object.(type) {
+	case *Item:
+		err = r.ModerateDeleteItem(ctx, mod, *ob, reason)
+	case *Account:
+		err = r.BlockAccount(ctx, mod, *ob, reason)
+	default:
+		err = errors.BadRequestf("invalid held content %s", hc.Hash)
+	}
+	if err != nil {
+		return err
+	}
+	return r.releaseHeld(hc)
+}
+
+// HeldContentMw adds to the moderation queue the items and accounts held by the content checks
+func HeldContentMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := ContextCursor(r.Context())
+		repo := ContextRepository(r.Context())
+		if c == nil || repo == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		held, err := repo.LoadHeldContent(context.TODO())
+		if err != nil {
+			repo.errFn(log.Ctx{"err": err.Error()})("unable to load held content")
+		}
+		for k := range held {
+			if _, ok := c.items[held[k].Hash]; ok {
+				continue
+			}
+			c.items.Append(&held[k])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleHeldContent serves /moderation/held/{hash} POST request
+func (h *handler) HandleHeldContent(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ctx := context.TODO()
+
+	reason, err := ContentFromRequest(r, *acc)
+	if err != nil {
+		h.errFn(log.Ctx{"before": err})("Error: wrong http method")
+		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
+		return
+	}
+	hc, err := h.storage.LoadHeld(ctx, HashFromString(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "held content"))
+		return
+	}
+	if !acc.CanModerate(hc.Object) {
+		h.v.addFlashMessage(Error, w, r, "Unable to moderate held content as current user")
+		h.v.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+		return
+	}
+	action := r.PostFormValue("action")
+	msg := ""
+	switch action {
+	case "approve":
+		err = h.storage.ApproveHeld(ctx, *acc, hc)
+		msg = "Held content approved"
+	case "reject":
+		err = h.storage.RejectHeld(ctx, *acc, hc, &reason)
+		msg = "Held content rejected"
+	default:
+		err = errors.BadRequestf("invalid action %q", action)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err, "action": action, "hash": hc.Hash})("unable to moderate held content")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s held content: %s", action, err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, msg)
+	}
+	h.v.Redirect(w, r, "/moderation/reports", http.StatusSeeOther)
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"testing"
+
+	pub "github.com/go-ap/activitypub"
+)
+
+func TestRepository_heldCheck(t *testing.T) {
+	r, cleanup := testRepository(t)
+	defer cleanup()
+
+	held := HashFromString("a5b1b7e5-8c8a-4b2a-9d0e-4e1a2b3c4d5e")
+	other := HashFromString("0f1e2d3c-4b5a-6978-8a9b-acbdcedfe0f1")
+	res := CheckResult{Outcome: CheckHold, Check: "links", Reason: "contains too many links"}
+	if err := r.holdContent(heldItem, held, pub.IRI("https://example.com/objects/"+held.String()), res); err != nil {
+		t.Fatalf("holdContent() error = %s", err)
+	}
+
+	got, ok := r.heldCheck(held)
+	if !ok {
+		t.Fatalf("heldCheck(%s) didn't find the held item", held)
+	}
+	if !got.Held() || got.Check != res.Check || got.Reason != res.Reason {
+		t.Errorf("heldCheck(%s) = %v, want %v", held, got, res)
+	}
+	if got, ok := r.heldCheck(other); ok || got.Held() {
+		t.Errorf("heldCheck(%s) = %v, %t, the item is not held", other, got, ok)
+	}
+
+	if err := r.releaseHeld(HeldContent{Hash: held}); err != nil {
+		t.Fatalf("releaseHeld() error = %s", err)
+	}
+	if _, ok := r.heldCheck(held); ok {
+		t.Errorf("heldCheck(%s) found the item after it was approved", held)
+	}
+}
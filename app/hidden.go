@@ -0,0 +1,270 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// showHiddenParam is the query parameter that makes the content of blocked and ignored accounts visible
+const showHiddenParam = "hidden"
+
+const hiddenItemsCollection = "hidden-items"
+
+// MaxHiddenItems is the number of items an account can hide, the ones hidden first are shown again
+const MaxHiddenItems = 5000
+
+// HiddenItem is an item that an account hid from its listings
+type HiddenItem struct {
+	Hash     string    `json:"hash"`
+	IRI      string    `json:"iri"`
+	HiddenAt time.Time `json:"hiddenAt"`
+}
+
+// HiddenItems are the items an account hid, the most recently hidden first
+type HiddenItems []HiddenItem
+
+// accountsHiddenItems are the hidden items of the accounts, keyed by the account's ID
+type accountsHiddenItems map[string]HiddenItems
+
+func (h HiddenItems) hashes() []string {
+	hashes := make([]string, len(h))
+	for i, it := range h {
+		hashes[i] = it.Hash
+	}
+	return hashes
+}
+
+// addHiddenItem adds it on top of the list of hidden items, keeping at most MaxHiddenItems of them.
+// Hiding an item again keeps it in place.
+func addHiddenItem(list HiddenItems, it HiddenItem) HiddenItems {
+	if stringIndex(list.hashes(), it.Hash) >= 0 {
+		return list
+	}
+	list = append(HiddenItems{it}, list...)
+	if len(list) > MaxHiddenItems {
+		list = list[:MaxHiddenItems]
+	}
+	return list
+}
+
+// ContextShowHidden returns true if the viewer asked to see the content of the accounts they blocked or ignored
+func ContextShowHidden(ctx context.Context) bool {
+	show, _ := ctx.Value(ShowHiddenCtxtKey).(bool)
+	return show
+}
+
+// viewerContext returns the context used for loading the collections shown to the current viewer.
+// It carries the logged account, so we can hide the content of the accounts it blocked or ignored.
+func viewerContext(r *http.Request) context.Context {
+	ctx := context.WithValue(context.TODO(), LoggedAccountCtxtKey, loggedAccount(r))
+	show, _ := strconv.ParseBool(r.URL.Query().Get(showHiddenParam))
+	return context.WithValue(ctx, ShowHiddenCtxtKey, show)
+}
+
+// hidesAccount returns true if the viewer blocked or ignored the a account
+func hidesAccount(viewer, a *Account) bool {
+	if viewer == nil || !viewer.IsLogged() || a == nil || !a.Hash.IsValid() {
+		return false
+	}
+	if viewer.Hash == a.Hash {
+		return false
+	}
+	return viewer.Blocked.Contains(*a) || viewer.Ignored.Contains(*a)
+}
+
+// hidesItem returns true if the viewer hid the it item from its listings
+func hidesItem(viewer *Account, it *Item) bool {
+	if viewer == nil || !viewer.IsLogged() || it == nil {
+		return false
+	}
+	return viewer.Hidden.Contains(it.Hash)
+}
+
+// hideItems removes the items submitted by the accounts the viewer blocked or ignored.
+// It returns the remaining items and the number of items that were hidden.
+// When show is true the items are kept, but they are still counted.
+// The items held for moderation are removed for everyone except their authors and the moderators, and are not counted.
+// The items the viewer hid are removed, and are not counted, they can be found on the viewer's hidden items page.
+func hideItems(viewer *Account, items ItemCollection, show bool) (ItemCollection, int) {
+	result := make(ItemCollection, 0)
+	hidden := 0
+	for _, it := range items {
+		if it.IsHeld() && !canSeeHeld(viewer, &it) {
+			continue
+		}
+		if hidesItem(viewer, &it) {
+			continue
+		}
+		if hidesAccount(viewer, it.SubmittedBy) {
+			hidden++
+			if !show {
+				continue
+			}
+		}
+		result = append(result, it)
+	}
+	return result, hidden
+}
+
+// hideVotes removes the votes of the accounts the viewer blocked or ignored
+func hideVotes(viewer *Account, votes VoteCollection) VoteCollection {
+	if viewer == nil || (len(viewer.Blocked) == 0 && len(viewer.Ignored) == 0) {
+		return votes
+	}
+	result := make(VoteCollection, 0)
+	for _, v := range votes {
+		if hidesAccount(viewer, v.SubmittedBy) {
+			continue
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// LoadHiddenItems loads the items hidden by the a account, the most recently hidden first
+func (r *repository) LoadHiddenItems(a Account) (HiddenItems, error) {
+	v, err := r.store.LoadCached(hiddenItemsCollection, func() interface{} {
+		return &accountsHiddenItems{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	hidden := (*v.(*accountsHiddenItems))[accountRoleID(a)]
+	if hidden == nil {
+		return nil, nil
+	}
+	return append(make(HiddenItems, 0, len(hidden)), hidden...), nil
+}
+
+// loadAccountHiddenItems sets the hashes of the items the acc account hid
+func (r *repository) loadAccountHiddenItems(acc *Account) error {
+	list, err := r.LoadHiddenItems(*acc)
+	if err != nil {
+		return err
+	}
+	acc.Hidden = make(Hashes, 0, len(list))
+	for _, it := range list {
+		acc.Hidden = append(acc.Hidden, HashFromString(it.Hash))
+	}
+	return nil
+}
+
+// HideItem hides the it item from the listings of the a account.
+// NOTE(marius): only the top level items can be hidden, so we don't break the threads they belong to.
+func (r *repository) HideItem(a Account, it Item) error {
+	if !it.IsValid() || it.pub == nil {
+		return errors.NotFoundf("invalid item")
+	}
+	if !it.IsTop() {
+		return errors.BadRequestf("only top level items can be hidden")
+	}
+	h := HiddenItem{Hash: it.Hash.String(), IRI: it.pub.GetLink().String(), HiddenAt: time.Now().UTC()}
+	all := make(accountsHiddenItems)
+	return r.store.Update(hiddenItemsCollection, &all, func() error {
+		id := accountRoleID(a)
+		all[id] = addHiddenItem(all[id], h)
+		return nil
+	})
+}
+
+// UnhideItem shows the item with the h hash in the listings of the a account again
+func (r *repository) UnhideItem(a Account, h Hash) error {
+	all := make(accountsHiddenItems)
+	return r.store.Update(hiddenItemsCollection, &all, func() error {
+		id := accountRoleID(a)
+		i := stringIndex(all[id].hashes(), h.String())
+		if i < 0 {
+			return errors.NotFoundf("item is not hidden")
+		}
+		all[id] = append(all[id][:i], all[id][i+1:]...)
+		return nil
+	})
+}
+
+// RemoveHiddenItems removes the items hidden by the a account
+func (r *repository) RemoveHiddenItems(a Account) error {
+	all := make(accountsHiddenItems)
+	return r.store.Update(hiddenItemsCollection, &all, func() error {
+		delete(all, accountRoleID(a))
+		return nil
+	})
+}
+
+// HandleHiddenItems serves /~{handle}/hidden request
+func (h *handler) HandleHiddenItems(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	list, err := h.storage.LoadHiddenItems(*acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	f := FiltersFromRequest(r)
+	if f == nil {
+		f = &Filters{MaxItems: MaxContentItems}
+	}
+	start, end, next, prev := pageBounds(list.hashes(), HashFromString(f.Next), HashFromString(f.Prev), f.MaxItems)
+	list = list[start:end]
+
+	iris := make([]string, len(list))
+	for i, it := range list {
+		iris[i] = it.IRI
+	}
+	items, err := h.storage.loadItemsByIRI(viewerContext(r), iris...)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to load hidden items")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &hiddenItemsModel{Title: "Hidden items", User: acc, Items: make(ItemPtrCollection, 0), after: next, before: prev}
+	for _, hi := range list {
+		if it, ok := items[hi.Hash]; ok {
+			m.Items = append(m.Items, &it)
+		}
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleHideItem serves /~{handle}/{hash}/hide and /~{handle}/{hash}/unhide requests
+func (h *handler) HandleHideItem(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	repo := h.storage
+	ctx := context.TODO()
+	p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.errFn()("Error: %s", err)
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
+		return
+	}
+	url := ItemPermaLink(&p)
+	if backUrl := r.Header.Get("Referer"); !strings.Contains(backUrl, url) && strings.Contains(backUrl, Instance.BaseURL) {
+		url = backUrl
+	}
+	op := path.Base(r.URL.Path)
+	if op == "hide" {
+		err = repo.HideItem(*acc, p)
+	} else {
+		err = repo.UnhideItem(*acc, p.Hash)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": p.Hash})("unable to %s item", op)
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s item: %s", op, err))
+	} else if op == "hide" {
+		h.v.addFlashMessage(Success, w, r, "Item hidden, you can show it again from the hidden items page of your account")
+	} else {
+		h.v.addFlashMessage(Success, w, r, "Item is shown again")
+	}
+	h.v.Redirect(w, r, url, http.StatusFound)
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHideItems(t *testing.T) {
+	jane := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1"), CreatedAt: time.Now()}
+	john := &Account{Handle: "john", Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a"), CreatedAt: time.Now()}
+	jim := &Account{Handle: "jim", Hash: HashFromString("a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"), CreatedAt: time.Now()}
+	viewer := &Account{Handle: "viewer", CreatedAt: time.Now(), Blocked: AccountCollection{*john}, Ignored: AccountCollection{*jim}}
+
+	items := ItemCollection{{SubmittedBy: jane}, {SubmittedBy: john}, {SubmittedBy: jim}, {SubmittedBy: jane}}
+
+	tests := []struct {
+		name       string
+		viewer     *Account
+		show       bool
+		wantItems  int
+		wantHidden int
+	}{
+		{name: "anonymous", viewer: nil, wantItems: 4, wantHidden: 0},
+		{name: "no blocks", viewer: jane, wantItems: 4, wantHidden: 0},
+		{name: "hidden", viewer: viewer, wantItems: 2, wantHidden: 2},
+		{name: "shown", viewer: viewer, show: true, wantItems: 4, wantHidden: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, hidden := hideItems(tt.viewer, items, tt.show)
+			if len(got) != tt.wantItems {
+				t.Errorf("hideItems() returned %d items, want %d", len(got), tt.wantItems)
+			}
+			if hidden != tt.wantHidden {
+				t.Errorf("hideItems() hidden = %d, want %d", hidden, tt.wantHidden)
+			}
+		})
+	}
+
+	votes := VoteCollection{{SubmittedBy: jane}, {SubmittedBy: john}, {SubmittedBy: jim}}
+	if got := hideVotes(viewer, votes); len(got) != 1 {
+		t.Errorf("hideVotes() returned %d votes, want %d", len(got), 1)
+	}
+}
+
+func TestHideItems_hiddenItems(t *testing.T) {
+	jane := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1"), CreatedAt: time.Now()}
+	read := HashFromString("0f8e2b6a-3c1d-4e5f-9a7b-6c5d4e3f2a1b")
+	items := ItemCollection{{SubmittedBy: jane}, {SubmittedBy: jane}, {Hash: read, SubmittedBy: jane}}
+	hider := &Account{Handle: "hider", CreatedAt: time.Now(), Hidden: Hashes{read}}
+
+	for _, show := range []bool{false, true} {
+		got, hidden := hideItems(hider, items, show)
+		if len(got) != 2 {
+			t.Errorf("hideItems(show: %t) returned %d items, want %d", show, len(got), 2)
+		}
+		if hidden != 0 {
+			t.Errorf("hideItems(show: %t) hidden = %d, want the hidden items not to be counted", show, hidden)
+		}
+	}
+}
+
+func TestAddHiddenItem(t *testing.T) {
+	list := make(HiddenItems, 0)
+	for i := 0; i < MaxHiddenItems+2; i++ {
+		list = addHiddenItem(list, HiddenItem{Hash: fmt.Sprintf("%d", i)})
+	}
+	if len(list) != MaxHiddenItems {
+		t.Fatalf("addHiddenItem() kept %d items, want %d", len(list), MaxHiddenItems)
+	}
+	if newest := fmt.Sprintf("%d", MaxHiddenItems+1); list[0].Hash != newest {
+		t.Errorf("addHiddenItem() first item = %s, want %s", list[0].Hash, newest)
+	}
+	if list[len(list)-1].Hash != "2" {
+		t.Errorf("addHiddenItem() last item = %s, want the oldest ones to be removed", list[len(list)-1].Hash)
+	}
+	if got := addHiddenItem(list, HiddenItem{Hash: "5"}); len(got) != len(list) || got[0].Hash != list[0].Hash {
+		t.Errorf("addHiddenItem() of an already hidden item changed the list")
+	}
+}
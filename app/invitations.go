@@ -0,0 +1,378 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"text/template"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/google/uuid"
+	"github.com/mariusor/go-littr/internal/assets"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/mariusor/go-littr/internal/mail"
+)
+
+const invitationsCollection = "invitations"
+
+// pendingInvitationPrefix marks the hashes of the invitations for which the invitee account is not created yet
+const pendingInvitationPrefix = "pending-"
+
+// inviteMailTemplate is the template for the body of the invitation emails
+const inviteMailTemplate = "templates/mail/invite.txt"
+
+// Invitation is an invite sent by an account to an email address.
+// The invitee account is created in FedBOX when the invitation is sent, and it's identified by its Hash.
+type Invitation struct {
+	Hash       string    `json:"hash"`
+	Email      string    `json:"email"`
+	Inviter    string    `json:"inviter"`
+	Handle     string    `json:"handle,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitempty"`
+	AcceptedAt time.Time `json:"acceptedAt,omitempty"`
+	AcceptedBy string    `json:"acceptedBy,omitempty"`
+	RevokedAt  time.Time `json:"revokedAt,omitempty"`
+}
+
+// Invitations are the invites sent on the instance.
+// We keep them in the instance's local storage, as the ActivityPub vocabulary doesn't have a way to represent them.
+type Invitations []Invitation
+
+// IsAccepted returns true if an account was registered using the invitation
+func (i Invitation) IsAccepted() bool {
+	return !i.AcceptedAt.IsZero()
+}
+
+// IsRevoked returns true if the inviter revoked the invitation before it was used
+func (i Invitation) IsRevoked() bool {
+	return !i.RevokedAt.IsZero()
+}
+
+// IsExpired returns true if the invitation was not used before its expiry time
+func (i Invitation) IsExpired() bool {
+	return !i.IsAccepted() && !i.ExpiresAt.IsZero() && time.Now().After(i.ExpiresAt)
+}
+
+// IsPending returns true if the invitation can still be used to register an account
+func (i Invitation) IsPending() bool {
+	return !i.IsAccepted() && !i.IsRevoked() && !i.IsExpired()
+}
+
+// Status returns a short description of the state of the invitation
+func (i Invitation) Status() string {
+	switch {
+	case i.IsAccepted():
+		return "accepted"
+	case i.IsRevoked():
+		return "revoked"
+	case i.IsExpired():
+		return "expired"
+	}
+	return "pending"
+}
+
+// ByInviter returns the invitations sent by the a account
+func (ii Invitations) ByInviter(a Account) Invitations {
+	result := make(Invitations, 0)
+	id := accountRoleID(a)
+	for _, i := range ii {
+		if i.Inviter == id {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// Used returns the number of invitations that count towards the quota of the inviter.
+// The revoked and expired invitations give back their slot.
+func (ii Invitations) Used() int {
+	cnt := 0
+	for _, i := range ii {
+		if i.IsAccepted() || i.IsPending() {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (ii Invitations) find(hash string) int {
+	for k, i := range ii {
+		if i.Hash == hash {
+			return k
+		}
+	}
+	return -1
+}
+
+// LoadInvitations loads the invitations sent by the a account, newest first
+func (r *repository) LoadInvitations(ctx context.Context, a Account) (Invitations, error) {
+	all := make(Invitations, 0)
+	if err := r.store.Load(invitationsCollection, &all); err != nil {
+		return nil, err
+	}
+	sent := all.ByInviter(a)
+	for i, j := 0, len(sent)-1; i < j; i, j = i+1, j-1 {
+		sent[i], sent[j] = sent[j], sent[i]
+	}
+	return sent, nil
+}
+
+// LoadInvitation loads the invitation for the invitee account with the hash
+func (r *repository) LoadInvitation(ctx context.Context, hash string) (Invitation, error) {
+	all := make(Invitations, 0)
+	if err := r.store.Load(invitationsCollection, &all); err != nil {
+		return Invitation{}, err
+	}
+	k := all.find(hash)
+	if k < 0 {
+		return Invitation{}, errors.NotFoundf("invitation not found")
+	}
+	return all[k], nil
+}
+
+// SaveInvitation saves the inv invitation if its inviter didn't exceed the quota of invitations.
+// A quota of 0 means the inviter can send any number of invitations.
+func (r *repository) SaveInvitation(ctx context.Context, inv Invitation, quota int) error {
+	all := make(Invitations, 0)
+	return r.store.Update(invitationsCollection, &all, func() error {
+		if quota > 0 {
+			sent := make(Invitations, 0)
+			for _, i := range all {
+				if i.Inviter == inv.Inviter {
+					sent = append(sent, i)
+				}
+			}
+			if sent.Used() >= quota {
+				return errors.Forbiddenf("you have used all your %d invitations", quota)
+			}
+		}
+		all = append(all, inv)
+		return nil
+	})
+}
+
+// setInvitationHash replaces the placeholder hash of an invitation with the hash of the invitee account
+func (r *repository) setInvitationHash(ctx context.Context, old, hash string) error {
+	all := make(Invitations, 0)
+	return r.store.Update(invitationsCollection, &all, func() error {
+		k := all.find(old)
+		if k < 0 {
+			return errors.NotFoundf("invitation not found")
+		}
+		all[k].Hash = hash
+		return nil
+	})
+}
+
+// removeInvitation removes the invitation with the hash, it's used when we fail to create its invitee account
+func (r *repository) removeInvitation(ctx context.Context, hash string) error {
+	all := make(Invitations, 0)
+	return r.store.Update(invitationsCollection, &all, func() error {
+		k := all.find(hash)
+		if k < 0 {
+			return nil
+		}
+		all = append(all[:k], all[k+1:]...)
+		return nil
+	})
+}
+
+// RevokeInvitation stops the pending invitation with the hash from being used
+func (r *repository) RevokeInvitation(ctx context.Context, by Account, hash string) error {
+	all := make(Invitations, 0)
+	return r.store.Update(invitationsCollection, &all, func() error {
+		k := all.find(hash)
+		if k < 0 || all[k].Inviter != accountRoleID(by) {
+			return errors.NotFoundf("invitation not found")
+		}
+		if !all[k].IsPending() {
+			return errors.BadRequestf("the invitation is %s", all[k].Status())
+		}
+		// TODO(marius): we should also remove the invitee account that was created in FedBOX
+		all[k].RevokedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// AcceptInvitation marks the invitation with the hash as used by the handle account
+func (r *repository) AcceptInvitation(ctx context.Context, hash, handle string) error {
+	all := make(Invitations, 0)
+	return r.store.Update(invitationsCollection, &all, func() error {
+		k := all.find(hash)
+		if k < 0 {
+			// NOTE(marius): the invitations sent before we started keeping track of them
+			return nil
+		}
+		all[k].AcceptedAt = time.Now().UTC()
+		all[k].AcceptedBy = handle
+		return nil
+	})
+}
+
+// validInvitation returns an error if the invitee account with the hash comes from an invitation that can't be used anymore
+func (r *repository) validInvitation(ctx context.Context, hash string) error {
+	inv, err := r.LoadInvitation(ctx, hash)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// NOTE(marius): the invitations sent before we started keeping track of them
+			return nil
+		}
+		return err
+	}
+	if !inv.IsPending() {
+		return errors.Forbiddenf("the invitation is %s", inv.Status())
+	}
+	return nil
+}
+
+// inviteMail renders the invitation email for the inv invitation
+func inviteMail(c appConfig, inviter Account, inv Invitation) (mail.Message, error) {
+	m := mail.Message{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You are invited to join %s", c.Name),
+	}
+	raw, err := assets.Template(inviteMailTemplate)
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to load invitation template")
+	}
+	t, err := template.New("invite").Parse(string(raw))
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to parse invitation template")
+	}
+	// @todo(marius): :link_generation:
+	data := struct {
+		Email     string
+		Inviter   string
+		Name      string
+		BaseURL   string
+		URL       string
+		ExpiresAt time.Time
+	}{
+		Email:     inv.Email,
+		Inviter:   inviter.Handle,
+		Name:      c.Name,
+		BaseURL:   c.BaseURL,
+		URL:       fmt.Sprintf("%s/register/%s", c.BaseURL, inv.Hash),
+		ExpiresAt: inv.ExpiresAt,
+	}
+	body := bytes.Buffer{}
+	if err = t.Execute(&body, data); err != nil {
+		return m, errors.Annotatef(err, "unable to render invitation")
+	}
+	m.Body = body.String()
+	return m, nil
+}
+
+// HandleInvitations serves /invites request
+func (h *handler) HandleInvitations(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	invites, err := h.storage.LoadInvitations(context.TODO(), *acc)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to load invitations")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &invitationsModel{
+		Title:       "Invitations",
+		Invitations: invites,
+		Quota:       h.conf.InviteQuota,
+		Used:        invites.Used(),
+		Suspended:   h.storage.InvitesSuspended(*acc),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleRevokeInvite serves /invites/revoke POST request
+func (h *handler) HandleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	hash := r.PostFormValue("hash")
+	if err := h.storage.RevokeInvitation(context.TODO(), *acc, hash); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": hash})("unable to revoke invitation")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to revoke invitation: %s", err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, "Invitation revoked")
+	}
+	h.v.Redirect(w, r, "/invites", http.StatusSeeOther)
+}
+
+// HandleSendInvite handles POST /invite requests
+func (h *handler) HandleSendInvite(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ctx := context.TODO()
+
+	addr, err := netmail.ParseAddress(r.PostFormValue("email"))
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, "Invalid email address")
+		h.v.Redirect(w, r, "/invites", http.StatusSeeOther)
+		return
+	}
+	if h.storage.InvitesSuspended(*acc) {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("your invite rights have been suspended by a moderator"))
+		return
+	}
+	if h.conf.InviteQuota > 0 {
+		sent, err := h.storage.LoadInvitations(ctx, *acc)
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		if sent.Used() >= h.conf.InviteQuota {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("You have used all your %d invitations", h.conf.InviteQuota))
+			h.v.Redirect(w, r, "/invites", http.StatusSeeOther)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	inv := Invitation{
+		// NOTE(marius): we save the invitation before creating the invitee account, so the quota is enforced
+		//   before anything gets created in FedBOX. The placeholder hash is replaced with the invitee's afterwards.
+		Hash:      pendingInvitationPrefix + uuid.New().String(),
+		Email:     addr.Address,
+		Inviter:   accountRoleID(*acc),
+		Handle:    acc.Handle,
+		CreatedAt: now,
+	}
+	if h.conf.InviteExpiry > 0 {
+		inv.ExpiresAt = now.Add(h.conf.InviteExpiry)
+	}
+	if err = h.storage.SaveInvitation(ctx, inv, h.conf.InviteQuota); err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to save invitation")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save invitation: %s", err))
+		h.v.Redirect(w, r, "/invites", http.StatusSeeOther)
+		return
+	}
+
+	invitee, err := h.storage.SaveAccount(ctx, Account{CreatedBy: acc})
+	if err == nil && (!invitee.IsValid() || !invitee.HasMetadata() || invitee.Metadata.ID == "") {
+		err = errors.Newf("invalid account saved")
+	}
+	if err == nil {
+		err = h.storage.setInvitationHash(ctx, inv.Hash, invitee.Hash.String())
+	}
+	if err != nil {
+		if rerr := h.storage.removeInvitation(ctx, inv.Hash); rerr != nil {
+			h.errFn(log.Ctx{"err": rerr.Error(), "hash": inv.Hash})("unable to remove invitation")
+		}
+		h.v.HandleErrors(w, r, errors.NewBadRequest(err, "unable to save account"))
+		return
+	}
+	inv.Hash = invitee.Hash.String()
+
+	m, err := inviteMail(h.conf, *acc, inv)
+	if err == nil {
+		err = h.mail.Send(m)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "email": inv.Email})("unable to send invitation")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("The invitation for %s was saved, but we were unable to send it", inv.Email))
+	} else {
+		h.infoFn(log.Ctx{"email": inv.Email, "by": acc.Handle})("invitation sent")
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Invitation sent to %s", inv.Email))
+	}
+	h.v.Redirect(w, r, "/invites", http.StatusSeeOther)
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInvitation_Status(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name string
+		inv  Invitation
+		want string
+	}{
+		{name: "pending", inv: Invitation{CreatedAt: now}, want: "pending"},
+		{name: "pending-not-expired", inv: Invitation{CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, want: "pending"},
+		{name: "expired", inv: Invitation{CreatedAt: now, ExpiresAt: now.Add(-time.Hour)}, want: "expired"},
+		{name: "revoked", inv: Invitation{CreatedAt: now, RevokedAt: now}, want: "revoked"},
+		{name: "accepted", inv: Invitation{CreatedAt: now, AcceptedAt: now}, want: "accepted"},
+		{name: "accepted-after-expiry", inv: Invitation{CreatedAt: now, ExpiresAt: now.Add(-time.Hour), AcceptedAt: now}, want: "accepted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.inv.Status(); got != tt.want {
+				t.Errorf("Status() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvitations_Used(t *testing.T) {
+	now := time.Now().UTC()
+	ii := Invitations{
+		{Hash: "pending", CreatedAt: now},
+		{Hash: "accepted", CreatedAt: now, AcceptedAt: now},
+		{Hash: "revoked", CreatedAt: now, RevokedAt: now},
+		{Hash: "expired", CreatedAt: now, ExpiresAt: now.Add(-time.Hour)},
+	}
+	if got := ii.Used(); got != 2 {
+		t.Errorf("Used() = %d, want %d", got, 2)
+	}
+	if got := ii.find("revoked"); got != 2 {
+		t.Errorf("find() = %d, want %d", got, 2)
+	}
+	if got := ii.find("missing"); got != -1 {
+		t.Errorf("find() = %d, want %d", got, -1)
+	}
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// maxInviteTreeDepth is the maximum number of invite levels we load below an account
+const maxInviteTreeDepth = 16
+
+const inviteSuspensionsCollection = "invite-suspensions"
+
+// inviteSuspension records which moderator suspended the invite rights of an account, and when
+type inviteSuspension struct {
+	By       string    `json:"by"`
+	At       time.Time `json:"at"`
+	Activity string    `json:"activity,omitempty"`
+}
+
+// inviteSuspensions are the accounts that are not allowed to invite other people, keyed by their IRI.
+// The moderation activity is saved to FedBOX, but we keep the state in the instance's local storage for quick lookups.
+type inviteSuspensions map[string]inviteSuspension
+
+// LoadInvitees loads all the accounts that were invited by a, or by one of its invitees
+func (r *repository) LoadInvitees(ctx context.Context, a Account) (AccountCollection, error) {
+	result := make(AccountCollection, 0)
+	parents := AccountCollection{a}
+	for lvl := 0; lvl < maxInviteTreeDepth && len(parents) > 0; lvl++ {
+		f := new(Filters)
+		f.Type = ActivityTypesFilter(ValidActorTypes...)
+		for _, p := range parents {
+			f.AttrTo = append(f.AttrTo, LikeString(p.Hash.String()))
+		}
+		accounts, err := r.accounts(ctx, f)
+		if err != nil {
+			return result, err
+		}
+		parents = make(AccountCollection, 0)
+		for _, acc := range accounts {
+			// NOTE(marius): the accounts created by the instance are attributed to themselves,
+			// so we need to guard against walking the same accounts over and over
+			if acc.Hash == a.Hash || result.Contains(acc) {
+				continue
+			}
+			result = append(result, acc)
+			parents = append(parents, acc)
+		}
+	}
+	return result, nil
+}
+
+// InvitesSuspended returns true if the a account had its invite rights suspended by a moderator
+func (r *repository) InvitesSuspended(a Account) bool {
+	suspended := make(inviteSuspensions)
+	if err := r.store.Load(inviteSuspensionsCollection, &suspended); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load invite suspensions")
+		return false
+	}
+	_, ok := suspended[accountRoleID(a)]
+	return ok
+}
+
+// SuspendInvites stops the a account from inviting other people.
+// The suspension is recorded as a Reject activity of the mod moderator on the account.
+func (r *repository) SuspendInvites(ctx context.Context, mod, a Account, reason *Item) error {
+	act, err := r.moderationActivityOnAccount(ctx, mod, a, reason)
+	if err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	act.Type = pub.RejectType
+	id := accountRoleID(a)
+	suspended := make(inviteSuspensions)
+	// NOTE(marius): we record the suspension before federating it, so concurrent requests can't suspend twice,
+	//   but we don't hold the storage lock while waiting for FedBOX
+	err = r.store.Update(inviteSuspensionsCollection, &suspended, func() error {
+		if _, ok := suspended[id]; ok {
+			return errors.BadRequestf("%s already has the invites suspended", a.Handle)
+		}
+		suspended[id] = inviteSuspension{By: mod.Handle, At: time.Now().UTC()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	iri, _, err := r.fedbox.ToOutbox(ctx, act)
+	suspended = make(inviteSuspensions)
+	if err != nil {
+		r.errFn()(err.Error())
+		if uerr := r.store.Update(inviteSuspensionsCollection, &suspended, func() error {
+			delete(suspended, id)
+			return nil
+		}); uerr != nil {
+			r.errFn(log.Ctx{"err": uerr.Error()})("unable to remove invite suspension")
+		}
+		return err
+	}
+	return r.store.Update(inviteSuspensionsCollection, &suspended, func() error {
+		if s, ok := suspended[id]; ok {
+			s.Activity = iri.String()
+			suspended[id] = s
+		}
+		return nil
+	})
+}
+
+// RestoreInvites allows the a account to invite other people again, undoing the activity that suspended them
+func (r *repository) RestoreInvites(ctx context.Context, mod, a Account) error {
+	if !accountValidForC2S(&mod) {
+		return errors.Unauthorizedf("invalid account %s", mod.Handle)
+	}
+	id := accountRoleID(a)
+	var s inviteSuspension
+	suspended := make(inviteSuspensions)
+	err := r.store.Update(inviteSuspensionsCollection, &suspended, func() error {
+		var ok bool
+		if s, ok = suspended[id]; !ok {
+			return errors.NotFoundf("%s doesn't have the invites suspended", a.Handle)
+		}
+		delete(suspended, id)
+		return nil
+	})
+	if err != nil || len(s.Activity) == 0 {
+		return err
+	}
+	undo := new(pub.Activity)
+	undo.Type = pub.UndoType
+	undo.Actor = r.loadAPPerson(mod).GetLink()
+	undo.Object = pub.IRI(s.Activity)
+	undo.BCC = pub.ItemCollection{r.fedbox.Service().ID, pub.PublicNS}
+	if _, _, err = r.fedbox.ToOutbox(ctx, undo); err != nil {
+		r.errFn()(err.Error())
+		// NOTE(marius): the suspension is still in effect, so we put it back
+		suspended = make(inviteSuspensions)
+		if uerr := r.store.Update(inviteSuspensionsCollection, &suspended, func() error {
+			suspended[id] = s
+			return nil
+		}); uerr != nil {
+			r.errFn(log.Ctx{"err": uerr.Error()})("unable to restore invite suspension")
+		}
+		return err
+	}
+	return nil
+}
+
+// BlockAccountTree blocks the a account together with the invitees accounts.
+// The blocks of the invitees are marked as replies to the block of a, so they show as its followups in the moderation log.
+func (r *repository) BlockAccountTree(ctx context.Context, mod, a Account, invitees AccountCollection, reason *Item) error {
+	block, err := r.moderationActivityOnAccount(ctx, mod, a, reason)
+	if err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	block.Type = pub.BlockType
+	iri, _, err := r.fedbox.ToOutbox(ctx, block)
+	if err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	errs := make([]error, 0)
+	for _, inv := range invitees {
+		b, err := r.moderationActivityOnAccount(ctx, mod, inv, reason)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		b.Type = pub.BlockType
+		if len(iri) > 0 {
+			b.InReplyTo = iri
+		}
+		if _, _, err = r.fedbox.ToOutbox(ctx, b); err != nil {
+			r.errFn(log.Ctx{"account": inv.Handle})(err.Error())
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Annotatef(errs[0], "unable to block %d of the %d invitees", len(errs), len(invitees))
+	}
+	return nil
+}
+
+// inviteTree returns a copy of the root account with its invitees set as its descendants
+func inviteTree(root Account, invitees AccountCollection) *Account {
+	r := root
+	r.Parent = nil
+	r.Children = nil
+	r.Level = 0
+	all := make(AccountPtrCollection, 0)
+	all = append(all, &r)
+	for i := range invitees {
+		inv := invitees[i]
+		inv.Children = nil
+		all = append(all, &inv)
+	}
+	reparentAccounts(&all)
+	addLevelAccounts(all)
+	return &r
+}
+
+// HandleInvitees serves /~{handle}/invitees request
+func (h *handler) HandleInvitees(w http.ResponseWriter, r *http.Request) {
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	a := authors[0]
+	invitees, err := h.storage.LoadInvitees(context.TODO(), a)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load invitees")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &inviteesModel{
+		Title:            fmt.Sprintf("Accounts invited by %s", a.Handle),
+		User:             inviteTree(a, invitees),
+		Count:            len(invitees),
+		InvitesSuspended: h.storage.InvitesSuspended(a),
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleInviteesAction serves /~{handle}/invitees POST request
+func (h *handler) HandleInviteesAction(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ctx := context.TODO()
+
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	a := authors[0]
+	url := fmt.Sprintf("%s/invitees", AccountPermaLink(&a))
+	if !acc.CanModerate(&a) {
+		h.v.addFlashMessage(Error, w, r, "Unable to moderate account as current user")
+		h.v.Redirect(w, r, url, http.StatusSeeOther)
+		return
+	}
+	reason, err := ContentFromRequest(r, *acc)
+	if err != nil {
+		h.errFn(log.Ctx{"before": err})("Error: wrong http method")
+		h.v.HandleErrors(w, r, errors.NewMethodNotAllowed(err, ""))
+		return
+	}
+
+	action := r.PostFormValue("action")
+	msg := ""
+	switch action {
+	case "suspend":
+		err = h.storage.SuspendInvites(ctx, *acc, a, &reason)
+		msg = fmt.Sprintf("Suspended invites for %s", a.Handle)
+	case "restore":
+		err = h.storage.RestoreInvites(ctx, *acc, a)
+		msg = fmt.Sprintf("Restored invites for %s", a.Handle)
+	case "block":
+		var invitees AccountCollection
+		if invitees, err = h.storage.LoadInvitees(ctx, a); err == nil {
+			err = h.storage.BlockAccountTree(ctx, *acc, a, invitees, &reason)
+		}
+		msg = fmt.Sprintf("Blocked %s and %d invited %s", a.Handle, len(invitees), pluralize(float64(len(invitees)), "account"))
+	default:
+		err = errors.BadRequestf("invalid action %q", action)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle, "action": action})("unable to moderate invite tree")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s: %s", action, err))
+	} else {
+		h.infoFn(log.Ctx{"handle": a.Handle, "action": action, "by": acc.Handle})("invite tree moderated")
+		h.v.addFlashMessage(Success, w, r, msg)
+	}
+	acc.Metadata.OutboxUpdated = time.Time{}
+	h.v.Redirect(w, r, url, http.StatusSeeOther)
+}
@@ -24,6 +24,8 @@ type ItemMetadata struct {
 	SharesURI  string            `json:"shares,omitempty"`
 	AuthorURI  string            `json:"author,omitempty"`
 	Icon       ImageMetadata     `json:"icon,omitempty"`
+	Preview     *LinkMetadata     `json:"preview,omitempty"`
+	Attachments []MediaMetadata   `json:"attachments,omitempty"`
 }
 
 var ValidContentTypes = pub.ActivityVocabularyTypes{
@@ -84,6 +86,46 @@ func (i *Item) Delete() {
 	i.Flags |= FlagsDeleted
 }
 
+// IsLocked returns true if the item doesn't accept new replies
+func (i *Item) IsLocked() bool {
+	return i != nil && (i.Flags&FlagsLocked) == FlagsLocked
+}
+
+// Lock adds the locked flag on an item
+func (i *Item) Lock() {
+	i.Flags |= FlagsLocked
+}
+
+// Unlock removes the locked flag from an item
+func (i *Item) Unlock() {
+	i.Flags &^= FlagsLocked
+}
+
+// IsPinned returns true if the item is shown on top of its listing
+func (i *Item) IsPinned() bool {
+	return i != nil && (i.Flags&FlagsPinned) == FlagsPinned
+}
+
+// Pin adds the pinned flag on an item
+func (i *Item) Pin() {
+	i.Flags |= FlagsPinned
+}
+
+// Unpin removes the pinned flag from an item
+func (i *Item) Unpin() {
+	i.Flags &^= FlagsPinned
+}
+
+// IsHeld returns true if the item is waiting for the approval of a moderator
+func (i *Item) IsHeld() bool {
+	return i != nil && (i.Flags&FlagsHeld) == FlagsHeld
+}
+
+// Hold adds the held flag on an item
+func (i *Item) Hold() {
+	i.Flags |= FlagsHeld
+}
+
 func (i *Item) Private() bool {
 	return i != nil && (i.Flags&FlagsPrivate) == FlagsPrivate
 }
@@ -104,6 +146,46 @@ func (i *Item) IsLink() bool {
 	return i != nil && i.MimeType == MimeTypeURL
 }
 
+// HasPreview returns true if we loaded the metadata of the page the Item links to
+func (i Item) HasPreview() bool {
+	return i.IsLink() && i.Metadata != nil && i.Metadata.Preview.IsValid()
+}
+
+// Preview returns the metadata of the page the Item links to
+func (i Item) Preview() *LinkMetadata {
+	if i.Metadata == nil {
+		return nil
+	}
+	return i.Metadata.Preview
+}
+
+// HasAttachments returns true if the Item has media files attached
+func (i Item) HasAttachments() bool {
+	return i.Metadata != nil && len(i.Metadata.Attachments) > 0
+}
+
+// Attachments returns the media files attached to the Item
+func (i Item) Attachments() []MediaMetadata {
+	if i.Metadata == nil {
+		return nil
+	}
+	return i.Metadata.Attachments
+}
+
+// HasContentWarning returns true if the author put the content of the Item behind a warning or summary,
+// or if it was marked as sensitive by its origin server
+func (i Item) HasContentWarning() bool {
+	return i.Sensitive || len(i.Summary) > 0
+}
+
+// ContentWarning returns the text the content of the Item is hidden behind
+func (i Item) ContentWarning() string {
+	if len(i.Summary) > 0 {
+		return i.Summary
+	}
+	return "sensitive content"
+}
+
 func (i Item) IsSelf() bool {
 	mimeComponents := strings.Split(i.MimeType, "/")
 	return mimeComponents[0] == "text"
@@ -154,6 +236,10 @@ func updateItemFromRequest(r *http.Request, author Account, i *Item) error {
 	if tit := r.PostFormValue("title"); len(tit) > 0 {
 		i.Title = tit
 	}
+	if _, ok := r.PostForm["summary"]; ok {
+		i.Summary = strings.TrimSpace(r.PostFormValue("summary"))
+		i.Sensitive = len(i.Summary) > 0
+	}
 	if dat := r.PostFormValue("data"); len(dat) > 0 {
 		i.Data = dat
 	}
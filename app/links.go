@@ -0,0 +1,401 @@
+package app
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// linkFetchTimeout is the maximum time we allow for retrieving a submitted link
+	linkFetchTimeout = 5 * time.Second
+	// linkFetchMaxSize is the maximum number of bytes we read from a submitted link
+	linkFetchMaxSize = 512 * 1024
+	// linkFetchMaxRedirects is the maximum number of redirects we follow when retrieving a submitted link
+	linkFetchMaxRedirects = 5
+
+	maxPreviewTitleLen       = 256
+	maxPreviewDescriptionLen = 1024
+)
+
+// LinkMetadata holds the preview information we can extract from the page an item links to
+type LinkMetadata struct {
+	Title       string        `json:"title,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Canonical   string        `json:"canonical,omitempty"`
+	SiteName    string        `json:"siteName,omitempty"`
+	Thumbnail   ImageMetadata `json:"thumbnail,omitempty"`
+}
+
+// IsValid returns true if we have any useful information about the link
+func (l *LinkMetadata) IsValid() bool {
+	return l != nil && (len(l.Title) > 0 || len(l.Description) > 0 || len(l.Thumbnail.URI) > 0)
+}
+
+// reservedNetworks are the address ranges we don't allow outgoing requests to, as they belong
+// to the instance itself or to its internal network: loopback, RFC1918, carrier grade NAT,
+// link-local (which includes the cloud metadata endpoint at 169.254.169.254) and their IPv6 counterparts.
+var reservedNetworks = func() []*net.IPNet {
+	cidrs := []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	}
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, _ := net.ParseCIDR(c)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPublicIP returns true if ip is not part of the reserved networks
+func isPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDialAddress is used as the Control function of the outgoing requests dialer.
+// It gets called after the host name has been resolved, so it sees the actual address we're connecting to.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); !isPublicIP(ip) {
+		return errors.Forbiddenf("connections to %s are not allowed", host)
+	}
+	return nil
+}
+
+// checkOutgoingURL validates the scheme and, when it's an IP address, the host of u
+func checkOutgoingURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.BadRequestf("unsupported URL scheme %s", u.Scheme)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !isPublicIP(ip) {
+		return errors.Forbiddenf("requests to %s are not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// checkRedirect validates every URL we get redirected to, and caps the number of redirects we follow
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= linkFetchMaxRedirects {
+		return errors.Newf("stopped after %d redirects", linkFetchMaxRedirects)
+	}
+	return checkOutgoingURL(req.URL)
+}
+
+// outgoingDialer is the dialer for the requests to third party servers that users can point us to.
+// It refuses to connect to the instance's internal network.
+var outgoingDialer = &net.Dialer{
+	Timeout: linkFetchTimeout,
+	Control: checkDialAddress,
+}
+
+// newOutgoingClient returns a HTTP client for requests to third party servers, which uses the guarded dialer
+// and re-checks the redirects.
+// NOTE(marius): we don't use the proxy from the environment, as it would connect on our behalf and
+// bypass the address checks of the dialer.
+func newOutgoingClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           outgoingDialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: checkRedirect,
+	}
+}
+
+var linkClient = newOutgoingClient(linkFetchTimeout)
+
+var validLinkContentTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+}
+
+// fetchLinkMetadata retrieves the page at u and extracts its title, description, canonical URL,
+// site name and thumbnail from the <head> element.
+func fetchLinkMetadata(ctx context.Context, u string) (*LinkMetadata, error) {
+	base, err := url.Parse(u)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid URL %s", u)
+	}
+	if err = checkOutgoingURL(base); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, linkFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", client.UserAgent)
+
+	resp, err := linkClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Newf("unable to load %s: %s", u, resp.Status)
+	}
+	if resp.ContentLength > linkFetchMaxSize {
+		return nil, errors.Newf("unable to load %s: content too large %d", u, resp.ContentLength)
+	}
+	ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid content type for %s", u)
+	}
+	if !stringInSlice(validLinkContentTypes)(ct) {
+		return nil, errors.Newf("unable to extract metadata from %s: content type %s", u, ct)
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		// NOTE(marius): we need to resolve relative URLs against the URL we've been redirected to
+		base = resp.Request.URL
+	}
+	return parseLinkMetadata(io.LimitReader(resp.Body, linkFetchMaxSize), base)
+}
+
+// parseLinkMetadata extracts the link metadata from the head of a HTML document.
+// It prefers OpenGraph values over Twitter card ones and those over the plain HTML elements.
+func parseLinkMetadata(r io.Reader, base *url.URL) (*LinkMetadata, error) {
+	meta := make(map[string]string)
+	var title, canonical string
+
+	z := xhtml.NewTokenizer(r)
+	inTitle := false
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+		tok := z.Token()
+		if tt == xhtml.EndTagToken {
+			if tok.Data == "head" {
+				break
+			}
+			if tok.Data == "title" {
+				inTitle = false
+			}
+			continue
+		}
+		if tt == xhtml.TextToken {
+			if inTitle && len(title) == 0 {
+				title = strings.TrimSpace(tok.Data)
+			}
+			continue
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			continue
+		}
+		switch tok.Data {
+		case "body":
+			// NOTE(marius): everything we're interested in should be in the <head>
+			return buildLinkMetadata(meta, title, canonical, base), nil
+		case "title":
+			inTitle = tt == xhtml.StartTagToken
+		case "link":
+			if strings.ToLower(htmlAttr(tok, "rel")) == "canonical" && len(canonical) == 0 {
+				canonical = htmlAttr(tok, "href")
+			}
+		case "meta":
+			name := htmlAttr(tok, "property")
+			if len(name) == 0 {
+				name = htmlAttr(tok, "name")
+			}
+			name = strings.ToLower(name)
+			if _, ok := meta[name]; len(name) > 0 && !ok {
+				meta[name] = strings.TrimSpace(htmlAttr(tok, "content"))
+			}
+		}
+	}
+	return buildLinkMetadata(meta, title, canonical, base), nil
+}
+
+func htmlAttr(tok xhtml.Token, name string) string {
+	for _, a := range tok.Attr {
+		if strings.ToLower(a.Key) == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+func resolveLink(base *url.URL, ref string) string {
+	if len(ref) == 0 {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func buildLinkMetadata(meta map[string]string, title, canonical string, base *url.URL) *LinkMetadata {
+	l := LinkMetadata{
+		Title:       firstNonEmpty(meta["og:title"], meta["twitter:title"], title),
+		Description: firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]),
+		Canonical:   resolveLink(base, firstNonEmpty(canonical, meta["og:url"])),
+		SiteName:    firstNonEmpty(meta["og:site_name"], meta["application-name"]),
+	}
+	l.Title = truncate(l.Title, maxPreviewTitleLen)
+	l.Description = truncate(l.Description, maxPreviewDescriptionLen)
+	if img := resolveLink(base, firstNonEmpty(meta["og:image:secure_url"], meta["og:image"], meta["twitter:image"], meta["twitter:image:src"])); len(img) > 0 {
+		l.Thumbnail.URI = img
+		l.Thumbnail.MimeType = meta["og:image:type"]
+	}
+	return &l
+}
+
+// loadAPLinkPreview converts the link metadata to the preview object of a Page
+func loadAPLinkPreview(l *LinkMetadata) pub.Item {
+	if !l.IsValid() {
+		return nil
+	}
+	p := pub.ObjectNew(pub.PageType)
+	if len(l.Title) > 0 {
+		p.Name.Set(pub.NilLangRef, pub.Content(l.Title))
+	}
+	if len(l.Description) > 0 {
+		p.Summary.Set(pub.NilLangRef, pub.Content(l.Description))
+	}
+	if len(l.Canonical) > 0 {
+		p.URL = pub.IRI(l.Canonical)
+	}
+	if len(l.SiteName) > 0 {
+		site := pub.ObjectNew(pub.ServiceType)
+		site.Name.Set(pub.NilLangRef, pub.Content(l.SiteName))
+		p.Generator = site
+	}
+	if len(l.Thumbnail.URI) > 0 {
+		img := pub.ObjectNew(pub.ImageType)
+		img.URL = pub.IRI(l.Thumbnail.URI)
+		img.MediaType = pub.MimeType(l.Thumbnail.MimeType)
+		p.Image = img
+	}
+	return p
+}
+
+// linkPreviewFromObject loads the link metadata from the preview object of a Page
+func linkPreviewFromObject(l *LinkMetadata, o *pub.Object) error {
+	if l == nil || o == nil {
+		return nil
+	}
+	l.Title = o.Name.First().Value.String()
+	l.Description = o.Summary.First().Value.String()
+	if o.URL != nil {
+		l.Canonical = o.URL.GetLink().String()
+	}
+	if o.Generator != nil {
+		pub.OnObject(o.Generator, func(s *pub.Object) error {
+			l.SiteName = s.Name.First().Value.String()
+			return nil
+		})
+	}
+	if o.Image != nil {
+		pub.OnObject(o.Image, func(img *pub.Object) error {
+			if img.URL != nil {
+				l.Thumbnail.URI = img.URL.GetLink().String()
+			}
+			l.Thumbnail.MimeType = string(img.MediaType)
+			return nil
+		})
+	}
+	return nil
+}
+
+// LoadLinkMetadata fetches the metadata of the page a link item points to and updates the item with it.
+// It's meant to be run in the background after the item has been saved, so we reload the item
+// before saving it, to not overwrite the changes that happened while we were waiting for the third party server.
+func (r *repository) LoadLinkMetadata(ctx context.Context, it Item) (Item, error) {
+	if !it.IsLink() {
+		return it, errors.BadRequestf("item %s is not a link", it.Hash)
+	}
+	l, err := fetchLinkMetadata(ctx, it.Data)
+	if err != nil {
+		return it, errors.Annotatef(err, "unable to load link metadata")
+	}
+	if !l.IsValid() {
+		return it, nil
+	}
+	it, err = r.LoadItem(ctx, objects.IRI(r.fedbox.Service()).AddPath(it.Hash.String()))
+	if err != nil {
+		return it, errors.Annotatef(err, "unable to reload item")
+	}
+	if it.Deleted() || !it.IsLink() {
+		return it, nil
+	}
+	if it.Metadata == nil {
+		it.Metadata = new(ItemMetadata)
+	}
+	it.Metadata.Preview = l
+	if len(it.Title) == 0 {
+		it.Title = l.Title
+	}
+	return r.SaveItem(ctx, it)
+}
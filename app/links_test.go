@@ -0,0 +1,121 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseLinkMetadata(t *testing.T) {
+	base, _ := url.Parse("https://example.com/articles/test")
+	tests := []struct {
+		name string
+		doc  string
+		want *LinkMetadata
+	}{
+		{
+			name: "empty",
+			doc:  "",
+			want: &LinkMetadata{},
+		},
+		{
+			name: "plain-html",
+			doc: `<html><head><title> Example title </title>
+<meta name="description" content="Example description">
+<link rel="canonical" href="/articles/canonical">
+</head><body><title>not this</title></body></html>`,
+			want: &LinkMetadata{
+				Title:       "Example title",
+				Description: "Example description",
+				Canonical:   "https://example.com/articles/canonical",
+			},
+		},
+		{
+			name: "opengraph-over-twitter-over-html",
+			doc: `<html><head><title>HTML title</title>
+<meta name="twitter:title" content="Twitter title">
+<meta property="og:title" content="OG title">
+<meta name="twitter:description" content="Twitter description">
+<meta property="og:site_name" content="Example">
+<meta name="twitter:image" content="https://cdn.example.com/twitter.png">
+<meta property="og:image" content="/og.png">
+<meta property="og:image:type" content="image/png">
+</head></html>`,
+			want: &LinkMetadata{
+				Title:       "OG title",
+				Description: "Twitter description",
+				SiteName:    "Example",
+				Thumbnail: ImageMetadata{
+					URI:      "https://example.com/og.png",
+					MimeType: "image/png",
+				},
+			},
+		},
+		{
+			name: "ignore-non-http-links",
+			doc:  `<head><meta property="og:image" content="javascript:alert(1)"><link rel="canonical" href="data:text/plain,test"></head>`,
+			want: &LinkMetadata{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLinkMetadata(strings.NewReader(tt.doc), base)
+			if err != nil {
+				t.Errorf("parseLinkMetadata() error = %s", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLinkMetadata() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkDialAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{address: "93.184.216.34:443", wantErr: false},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", wantErr: false},
+		{address: "127.0.0.1:80", wantErr: true},
+		{address: "10.1.2.3:80", wantErr: true},
+		{address: "172.20.0.1:80", wantErr: true},
+		{address: "192.168.1.1:80", wantErr: true},
+		{address: "169.254.169.254:80", wantErr: true},
+		{address: "0.0.0.0:80", wantErr: true},
+		{address: "[::1]:80", wantErr: true},
+		{address: "[fe80::1]:80", wantErr: true},
+		{address: "[fd00::1]:80", wantErr: true},
+		{address: "[::ffff:127.0.0.1]:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if err := checkDialAddress("tcp", tt.address, nil); (err != nil) != tt.wantErr {
+				t.Errorf("checkDialAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkRedirect(t *testing.T) {
+	req := func(u string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, u, nil)
+		return r
+	}
+	if err := checkRedirect(req("https://example.com/b"), []*http.Request{req("https://example.com/a")}); err != nil {
+		t.Errorf("checkRedirect() error = %s, expected nil", err)
+	}
+	if err := checkRedirect(req("http://169.254.169.254/latest/meta-data"), []*http.Request{req("https://example.com/a")}); err == nil {
+		t.Errorf("checkRedirect() expected error for redirect to a link-local address")
+	}
+	if err := checkRedirect(req("file:///etc/passwd"), []*http.Request{req("https://example.com/a")}); err == nil {
+		t.Errorf("checkRedirect() expected error for redirect to a file URL")
+	}
+	via := make([]*http.Request, linkFetchMaxRedirects)
+	if err := checkRedirect(req("https://example.com/b"), via); err == nil {
+		t.Errorf("checkRedirect() expected error after %d redirects", linkFetchMaxRedirects)
+	}
+}
@@ -15,6 +15,8 @@ var (
 	AuthorCtxtKey        CtxtKey = "__author"
 	CursorCtxtKey        CtxtKey = "__cursor"
 	ContentCtxtKey       CtxtKey = "__content"
+	ShowHiddenCtxtKey    CtxtKey = "__show_hidden"
+	AccessTokenCtxtKey   CtxtKey = "__token"
 )
 
 type WebInfo struct {
@@ -70,6 +72,12 @@ func ContextAccount(ctx context.Context) *Account {
 	return a
 }
 
+func ContextAccessToken(ctx context.Context) *AccessToken {
+	var t *AccessToken
+	t, _ = ctx.Value(AccessTokenCtxtKey).(*AccessToken)
+	return t
+}
+
 func ContextAuthors(ctx context.Context) []Account {
 	var a []Account
 	a, _ = ctx.Value(AuthorCtxtKey).([]Account)
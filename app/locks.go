@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const locksCollection = "locks"
+
+// itemLock records which moderator locked a thread and when
+type itemLock struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
+}
+
+// itemLocks are the locked threads, keyed by the hash of their top item.
+// The ActivityPub vocabulary doesn't have a way to represent them, so we keep them in the instance's local storage.
+type itemLocks map[string]itemLock
+
+// loadItemsLocks sets the locked flag on the items that are locked, or which belong to a locked thread
+func (r *repository) loadItemsLocks(items ...Item) ItemCollection {
+	if len(items) == 0 {
+		return items
+	}
+	locks := make(itemLocks)
+	if err := r.store.Load(locksCollection, &locks); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load locked items")
+		return items
+	}
+	if len(locks) == 0 {
+		return items
+	}
+	for k := range items {
+		it := &items[k]
+		if _, ok := locks[it.Hash.String()]; ok {
+			it.Lock()
+		}
+		if it.OP.IsValid() {
+			if _, ok := locks[it.OP.Hash.String()]; ok {
+				it.Lock()
+			}
+		}
+	}
+	return items
+}
+
+// LockItem stops the thread started by the it item from receiving new replies
+func (r *repository) LockItem(ctx context.Context, by Account, it Item) error {
+	if !it.IsValid() {
+		return errors.NotFoundf("invalid item")
+	}
+	if !it.IsTop() {
+		return errors.BadRequestf("only top level items can be locked")
+	}
+	locks := make(itemLocks)
+	err := r.store.Update(locksCollection, &locks, func() error {
+		locks[it.Hash.String()] = itemLock{By: by.Handle, At: time.Now().UTC()}
+		return nil
+	})
+	if err == nil {
+		r.Notify(it.SubmittedBy, moderationNotification("locked", it))
+	}
+	return err
+}
+
+// UnlockItem allows the thread started by the it item to receive new replies again
+func (r *repository) UnlockItem(ctx context.Context, it Item) error {
+	locks := make(itemLocks)
+	err := r.store.Update(locksCollection, &locks, func() error {
+		if _, ok := locks[it.Hash.String()]; !ok {
+			return errors.NotFoundf("item is not locked")
+		}
+		delete(locks, it.Hash.String())
+		return nil
+	})
+	if err == nil {
+		r.Notify(it.SubmittedBy, moderationNotification("unlocked", it))
+	}
+	return err
+}
+
+// ThreadIsLocked returns true if the thread of the it item is locked, loading its top item from FedBOX
+// when we don't know it
+func (r *repository) ThreadIsLocked(ctx context.Context, it Item) (bool, error) {
+	locks := make(itemLocks)
+	if err := r.store.Load(locksCollection, &locks); err != nil {
+		return false, err
+	}
+	if len(locks) == 0 {
+		return false, nil
+	}
+	if _, ok := locks[it.Hash.String()]; ok {
+		return true, nil
+	}
+	if it.OP.IsValid() {
+		_, ok := locks[it.OP.Hash.String()]
+		return ok, nil
+	}
+	// NOTE(marius): for the items which don't know their top item, we walk up their parents
+	for cur := it; cur.Parent.IsValid(); {
+		if _, ok := locks[cur.Parent.Hash.String()]; ok {
+			return true, nil
+		}
+		parent, err := r.LoadItem(ctx, objects.IRI(r.fedbox.Service()).AddPath(cur.Parent.Hash.String()))
+		if err != nil {
+			return false, err
+		}
+		if parent.Hash == cur.Hash {
+			break
+		}
+		cur = parent
+	}
+	return false, nil
+}
@@ -0,0 +1,500 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxImagePixels guards against decompression bombs
+	maxImagePixels = 50 * 1000 * 1000
+	// thumbnailSize is the size of the longest side of the generated thumbnails
+	thumbnailSize = 480
+	jpegQuality   = 90
+)
+
+// MediaMetadata describes a media file attached to an Item
+type MediaMetadata struct {
+	URI       string        `json:"uri"`
+	MimeType  string        `json:"mimeType"`
+	Name      string        `json:"name,omitempty"`
+	Thumbnail ImageMetadata `json:"thumbnail,omitempty"`
+}
+
+// MediaStore is the storage backend for uploaded media files
+type MediaStore interface {
+	// Save stores the content of r under name
+	Save(name string, r io.Reader) error
+	// Open returns the file stored under name
+	Open(name string) (http.File, error)
+	// Delete removes the file stored under name
+	Delete(name string) error
+}
+
+type fsMediaStore struct {
+	path string
+}
+
+// FSMediaStore returns a MediaStore that keeps the files in a local directory
+func FSMediaStore(p string) (*fsMediaStore, error) {
+	if err := os.MkdirAll(p, 0700); err != nil {
+		return nil, errors.Annotatef(err, "unable to create media storage path %s", p)
+	}
+	return &fsMediaStore{path: p}, nil
+}
+
+func (s fsMediaStore) Save(name string, r io.Reader) error {
+	f, err := os.OpenFile(filepath.Join(s.path, filepath.Base(name)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (s fsMediaStore) Open(name string) (http.File, error) {
+	return http.Dir(s.path).Open(path.Join("/", path.Base(name)))
+}
+
+func (s fsMediaStore) Delete(name string) error {
+	return os.Remove(filepath.Join(s.path, filepath.Base(name)))
+}
+
+// validMediaTypes holds the media types we accept as uploads, with their file extension.
+// The types are the ones returned by http.DetectContentType
+var validMediaTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"audio/mpeg":      ".mp3",
+	"audio/wave":      ".wav",
+	"application/ogg": ".ogg",
+	"audio/ogg":       ".ogg",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+}
+
+func mediaURL(name string) string {
+	return fmt.Sprintf("%s/media/%s", Instance.BaseURL, name)
+}
+
+// readMedia reads the uploaded file and detects its media type, which needs to be one we support
+func readMedia(f io.Reader, maxSize int64) ([]byte, string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, "", errors.Annotatef(err, "unable to read uploaded file")
+	}
+	if int64(len(data)) > maxSize {
+		return nil, "", errors.BadRequestf("uploaded file is larger than %s", sizeFmt(maxSize))
+	}
+	mimeType := http.DetectContentType(data)
+	if _, ok := validMediaTypes[mimeType]; !ok {
+		return nil, "", errors.BadRequestf("unsupported media type %s", mimeType)
+	}
+	if mimeType == "application/ogg" {
+		mimeType = "audio/ogg"
+	}
+	return data, mimeType, nil
+}
+
+// storeMedia saves the data of the mimeType media type in the media store.
+// Images are re-encoded, which removes their EXIF metadata, and get a thumbnail.
+func storeMedia(store MediaStore, data []byte, mimeType string) (*MediaMetadata, error) {
+	ext := validMediaTypes[mimeType]
+	name := uuid.New().String()
+	m := MediaMetadata{MimeType: mimeType}
+	if isImage(mimeType) {
+		var thumb []byte
+		var thumbType string
+		var err error
+		if data, thumb, thumbType, err = processImage(data, mimeType); err != nil {
+			return nil, err
+		}
+		thumbName := name + "-thumb" + validMediaTypes[thumbType]
+		if err := store.Save(thumbName, bytes.NewReader(thumb)); err != nil {
+			return nil, errors.Annotatef(err, "unable to save thumbnail")
+		}
+		m.Thumbnail = ImageMetadata{URI: mediaURL(thumbName), MimeType: thumbType}
+	}
+	if err := store.Save(name+ext, bytes.NewReader(data)); err != nil {
+		deleteMedia(store, m)
+		return nil, errors.Annotatef(err, "unable to save uploaded file")
+	}
+	m.URI = mediaURL(name + ext)
+	return &m, nil
+}
+
+// deleteMedia removes the files of the m media from the media store
+func deleteMedia(store MediaStore, m MediaMetadata) error {
+	for _, uri := range []string{m.URI, m.Thumbnail.URI} {
+		if len(uri) == 0 {
+			continue
+		}
+		if err := store.Delete(path.Base(uri)); err != nil && !os.IsNotExist(err) {
+			return errors.Annotatef(err, "unable to delete media %s", uri)
+		}
+	}
+	return nil
+}
+
+// processImage validates and re-encodes the image, which strips any metadata it contains.
+// It returns the clean image and a thumbnail for it.
+func processImage(data []byte, mimeType string) ([]byte, []byte, string, error) {
+	cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, "", errors.BadRequestf("invalid image: %s", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, nil, "", errors.BadRequestf("image is too large %dx%d", cfg.Width, cfg.Height)
+	}
+
+	var img stdimage.Image
+	clean := new(bytes.Buffer)
+	thumb := new(bytes.Buffer)
+	thumbType := "image/png"
+	switch mimeType {
+	case "image/jpeg":
+		if img, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+			return nil, nil, "", errors.BadRequestf("invalid image: %s", err)
+		}
+		// NOTE(marius): removing the EXIF data loses the orientation, so we apply it first
+		img = orientImage(img, jpegOrientation(data))
+		if err = jpeg.Encode(clean, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, nil, "", err
+		}
+		thumbType = "image/jpeg"
+		err = jpeg.Encode(thumb, thumbnail(img, thumbnailSize), &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		if img, err = png.Decode(bytes.NewReader(data)); err != nil {
+			return nil, nil, "", errors.BadRequestf("invalid image: %s", err)
+		}
+		if err = png.Encode(clean, img); err != nil {
+			return nil, nil, "", err
+		}
+		err = png.Encode(thumb, thumbnail(img, thumbnailSize))
+	case "image/gif":
+		// NOTE(marius): GIFs don't carry EXIF data, and re-encoding would lose their animation
+		if img, err = gif.Decode(bytes.NewReader(data)); err != nil {
+			return nil, nil, "", errors.BadRequestf("invalid image: %s", err)
+		}
+		clean.Write(data)
+		err = png.Encode(thumb, thumbnail(img, thumbnailSize))
+	default:
+		return nil, nil, "", errors.BadRequestf("unsupported image type %s", mimeType)
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return clean.Bytes(), thumb.Bytes(), thumbType, nil
+}
+
+// thumbnail scales down the img so that its longest side is max pixels, by averaging the source pixels
+func thumbnail(img stdimage.Image, max int) stdimage.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	tw, th := max, h*max/w
+	if h > w {
+		tw, th = w*max/h, max
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := stdimage.NewRGBA64(stdimage.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		sy0, sy1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			sx0, sx1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// orientImage transforms the img according to the EXIF orientation value o
+func orientImage(img stdimage.Image, o int) stdimage.Image {
+	if o < 2 || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := stdimage.NewRGBA64(stdimage.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, 1 being the default
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan, or end of image: there's no metadata after this
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+size]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation loads the orientation tag from the first IFD of the TIFF structure in EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 1
+	}
+	off := int(bo.Uint32(tiff[4:]))
+	if off < 8 || off+2 > len(tiff) {
+		return 1
+	}
+	entries := int(bo.Uint16(tiff[off:]))
+	for k := 0; k < entries; k++ {
+		e := off + 2 + k*12
+		if e+12 > len(tiff) {
+			return 1
+		}
+		if bo.Uint16(tiff[e:]) == 0x0112 {
+			if v := int(bo.Uint16(tiff[e+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+func sizeFmt(s int64) string {
+	switch {
+	case s >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(s)/(1<<20))
+	case s >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(s)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", s)
+}
+
+// loadMediaFromRequest saves the media file uploaded with a submission form
+// mediaUpload is a media file uploaded together with an item, which is stored only after the item passes its checks
+type mediaUpload struct {
+	data     []byte
+	mimeType string
+	name     string
+}
+
+func (h *handler) loadMediaFromRequest(r *http.Request) (*mediaUpload, error) {
+	if !h.conf.UploadsEnabled || h.media == nil {
+		return nil, nil
+	}
+	f, fh, err := r.FormFile("media")
+	if err != nil {
+		if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+			return nil, nil
+		}
+		return nil, errors.Annotatef(err, "unable to load uploaded file")
+	}
+	defer f.Close()
+	if fh.Size > h.conf.MaxUploadSize {
+		return nil, errors.BadRequestf("uploaded file is larger than %s", sizeFmt(h.conf.MaxUploadSize))
+	}
+	data, mimeType, err := readMedia(f, h.conf.MaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+	return &mediaUpload{
+		data:     data,
+		mimeType: mimeType,
+		name:     strings.TrimSpace(r.PostFormValue("media-description")),
+	}, nil
+}
+
+// storeUpload saves the uploaded media in the media store
+func (h *handler) storeUpload(u mediaUpload) (*MediaMetadata, error) {
+	m, err := storeMedia(h.media, u.data, u.mimeType)
+	if err != nil {
+		return nil, err
+	}
+	m.Name = u.name
+	return m, nil
+}
+
+// HandleMedia serves /media/{name} request
+func (h *handler) HandleMedia(w http.ResponseWriter, r *http.Request) {
+	if h.media == nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("media not found"))
+		return
+	}
+	name := chi.URLParam(r, "name")
+	f, err := h.media.Open(name)
+	if err != nil {
+		h.v.HandleErrors(w, r, errors.NotFoundf("media not found"))
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		h.v.HandleErrors(w, r, errors.NotFoundf("media not found"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+func mediaObjectType(mimeType string) pub.ActivityVocabularyType {
+	switch {
+	case isImage(mimeType):
+		return pub.ImageType
+	case isAudio(mimeType):
+		return pub.AudioType
+	case isVideo(mimeType):
+		return pub.VideoType
+	}
+	return pub.DocumentType
+}
+
+// loadAPAttachments converts the media metadata to the attachments of an object
+func loadAPAttachments(media []MediaMetadata) pub.ItemCollection {
+	attachments := make(pub.ItemCollection, 0)
+	for _, m := range media {
+		ob := pub.ObjectNew(mediaObjectType(m.MimeType))
+		ob.URL = pub.IRI(m.URI)
+		ob.MediaType = pub.MimeType(m.MimeType)
+		if len(m.Name) > 0 {
+			ob.Name.Set(pub.NilLangRef, pub.Content(m.Name))
+		}
+		if len(m.Thumbnail.URI) > 0 {
+			icon := pub.ObjectNew(pub.ImageType)
+			icon.URL = pub.IRI(m.Thumbnail.URI)
+			icon.MediaType = pub.MimeType(m.Thumbnail.MimeType)
+			ob.Icon = icon
+		}
+		attachments = append(attachments, ob)
+	}
+	return attachments
+}
+
+// mediaFromAttachments loads the media metadata from the attachments of an object
+func mediaFromAttachments(it pub.Item) []MediaMetadata {
+	media := make([]MediaMetadata, 0)
+	load := func(ob pub.Item) {
+		pub.OnObject(ob, func(o *pub.Object) error {
+			if o.URL == nil {
+				return nil
+			}
+			u := o.URL.GetLink().String()
+			if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+				return nil
+			}
+			m := MediaMetadata{
+				URI:      u,
+				MimeType: string(o.MediaType),
+				Name:     o.Name.First().Value.String(),
+			}
+			if o.Icon != nil {
+				pub.OnObject(o.Icon, func(i *pub.Object) error {
+					if i.URL != nil {
+						m.Thumbnail.URI = i.URL.GetLink().String()
+						m.Thumbnail.MimeType = string(i.MediaType)
+					}
+					return nil
+				})
+			}
+			media = append(media, m)
+			return nil
+		})
+	}
+	switch col := it.(type) {
+	case pub.ItemCollection:
+		for _, ob := range col {
+			load(ob)
+		}
+	case *pub.ItemCollection:
+		for _, ob := range *col {
+			load(ob)
+		}
+	default:
+		load(it)
+	}
+	return media
+}
@@ -0,0 +1,166 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	stdimage "image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// exifSegment builds an APP1 JPEG segment containing only the orientation tag
+func exifSegment(orientation uint16) []byte {
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("II")
+	binary.Write(tiff, binary.LittleEndian, uint16(42))
+	binary.Write(tiff, binary.LittleEndian, uint32(8))
+	binary.Write(tiff, binary.LittleEndian, uint16(1))
+	// tag, type SHORT, count, value
+	binary.Write(tiff, binary.LittleEndian, uint16(0x0112))
+	binary.Write(tiff, binary.LittleEndian, uint16(3))
+	binary.Write(tiff, binary.LittleEndian, uint32(1))
+	binary.Write(tiff, binary.LittleEndian, orientation)
+	binary.Write(tiff, binary.LittleEndian, uint16(0))
+	binary.Write(tiff, binary.LittleEndian, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	seg := []byte{0xFF, 0xE1}
+	size := make([]byte, 2)
+	binary.BigEndian.PutUint16(size, uint16(len(payload)+2))
+	seg = append(seg, size...)
+	return append(seg, payload...)
+}
+
+func testJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	img := stdimage.NewRGBA(stdimage.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("unable to encode test image: %s", err)
+	}
+	data := buf.Bytes()
+	if orientation == 0 {
+		return data
+	}
+	// NOTE(marius): the EXIF segment goes right after the start of image marker
+	return append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), data[2:]...)
+}
+
+func Test_jpegOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{
+			name: "empty",
+			data: nil,
+			want: 1,
+		},
+		{
+			name: "no-exif",
+			data: testJPEG(t, 4, 2, 0),
+			want: 1,
+		},
+		{
+			name: "rotated",
+			data: testJPEG(t, 4, 2, 6),
+			want: 6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processImage(t *testing.T) {
+	data := testJPEG(t, 800, 400, 6)
+	clean, thumb, thumbType, err := processImage(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("processImage() error = %s", err)
+	}
+	if bytes.Contains(clean, []byte("Exif")) {
+		t.Errorf("processImage() didn't strip the EXIF data")
+	}
+	if thumbType != "image/jpeg" {
+		t.Errorf("processImage() thumbnail type = %s, want %s", thumbType, "image/jpeg")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(clean))
+	if err != nil {
+		t.Fatalf("unable to decode cleaned image: %s", err)
+	}
+	if cfg.Width != 400 || cfg.Height != 800 {
+		t.Errorf("processImage() didn't apply orientation, size = %dx%d, want 400x800", cfg.Width, cfg.Height)
+	}
+	cfg, err = jpeg.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("unable to decode thumbnail: %s", err)
+	}
+	if cfg.Width != thumbnailSize/2 || cfg.Height != thumbnailSize {
+		t.Errorf("processImage() thumbnail size = %dx%d, want %dx%d", cfg.Width, cfg.Height, thumbnailSize/2, thumbnailSize)
+	}
+}
+
+func Test_readMedia(t *testing.T) {
+	jpg := testJPEG(t, 4, 4, 0)
+	tests := []struct {
+		name     string
+		data     []byte
+		maxSize  int64
+		mimeType string
+		wantErr  bool
+	}{
+		{name: "jpeg", data: jpg, maxSize: 1 << 20, mimeType: "image/jpeg"},
+		{name: "ogg", data: append([]byte("OggS\x00"), make([]byte, 32)...), maxSize: 1 << 20, mimeType: "audio/ogg"},
+		{name: "text", data: []byte("not an image"), maxSize: 1 << 20, wantErr: true},
+		{name: "too large", data: jpg, maxSize: int64(len(jpg) - 1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, mimeType, err := readMedia(bytes.NewReader(tt.data), tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readMedia() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if mimeType != tt.mimeType {
+				t.Errorf("readMedia() mime type = %q, want %q", mimeType, tt.mimeType)
+			}
+		})
+	}
+}
+
+func Test_deleteMedia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatalf("unable to create temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := FSMediaStore(dir)
+	if err != nil {
+		t.Fatalf("unable to create media store: %s", err)
+	}
+
+	m, err := storeMedia(store, testJPEG(t, 4, 4, 0), "image/jpeg")
+	if err != nil {
+		t.Fatalf("storeMedia() error = %s", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Fatalf("storeMedia() saved %d files, want 2", len(files))
+	}
+	if err := deleteMedia(store, *m); err != nil {
+		t.Fatalf("deleteMedia() error = %s", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("deleteMedia() left %d files in the media store", len(files))
+	}
+}
@@ -6,8 +6,11 @@ import (
 	pub "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
 	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
 	"html/template"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func (h handler) LoadAuthorMw(next http.Handler) http.Handler {
@@ -55,9 +58,11 @@ func LoadOutboxMw(next http.Handler) http.Handler {
 		var cursor = new(Cursor)
 		cursor.items = make(RenderableList, 0)
 		for _, author := range authors {
-			if c, err := repo.LoadAccountWithDetails(context.TODO(), author, f...); err == nil {
+			if c, err := repo.LoadAccountWithDetails(viewerContext(r), author, f...); err == nil {
 				cursor.items.Merge(c.items)
 				cursor.total += c.total
+				cursor.hidden += c.hidden
+				cursor.showHidden = c.showHidden
 				cursor.before = c.before
 				cursor.after = c.after
 			}
@@ -76,7 +81,7 @@ func LoadInboxMw(next http.Handler) http.Handler {
 			ctxtErr(next, w, r, errors.MethodNotAllowedf("nil account"))
 			return
 		}
-		cursor, err := repo.LoadActorInbox(context.TODO(), acc.pub, f...)
+		cursor, err := repo.LoadActorInbox(viewerContext(r), acc.pub, f...)
 		if err != nil {
 			ctxtErr(next, w, r, errors.Annotatef(err, "unable to load current account's inbox"))
 			return
@@ -90,7 +95,7 @@ func LoadServiceInboxMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		f := ContextActivityFilters(r.Context())
 		repo := ContextRepository(r.Context())
-		cursor, err := repo.LoadActorInbox(context.TODO(), repo.fedbox.Service(), f...)
+		cursor, err := repo.LoadActorInbox(viewerContext(r), repo.fedbox.Service(), f...)
 		if err != nil {
 			ctxtErr(next, w, r, errors.Annotatef(err, "unable to load the %s's inbox", repo.fedbox.Service().Type))
 			return
@@ -118,7 +123,7 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 
 		ff := ContextActivityFilters(r.Context())
 		repo := ContextRepository(r.Context())
-		ctx := context.TODO()
+		ctx := viewerContext(r)
 
 		if len(ff) == 0 {
 			ctxtErr(next, w, r, errors.Newf("invalid filter"))
@@ -172,8 +177,18 @@ func LoadObjectFromInboxMw(next http.Handler) http.Handler {
 		if items, err = repo.loadItemsVotes(ctx, items...); err != nil {
 			repo.errFn()("unable to load item votes")
 		}
+		items = repo.loadItemsLocalState(items...)
+		if items[0].IsHeld() && !canSeeHeld(ContextAccount(ctx), &items[0]) {
+			ctxtErr(next, w, r, errors.NotFoundf("Object not found"))
+			return
+		}
+		// NOTE(marius): we only hide the replies, the item that was requested is always shown
+		comments, hidden := hideItems(ContextAccount(ctx), items[1:], ContextShowHidden(ctx))
+		items = append(items[:1], comments...)
 		c := &Cursor{
-			items: make(RenderableList),
+			items:      make(RenderableList),
+			hidden:     hidden,
+			showHidden: ContextShowHidden(ctx),
 		}
 		for k := range items {
 			c.items.Append(Renderable(&items[k]))
@@ -271,13 +286,44 @@ func EditContentModelMw(next http.Handler) http.Handler {
 	})
 }
 
+// linkFromText returns the first http(s) URL found in a text.
+// Mobile share sheets usually send the page URL as part of the text.
+func linkFromText(s string) string {
+	for _, f := range strings.Fields(s) {
+		if u, err := url.ParseRequestURI(f); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			return f
+		}
+	}
+	return ""
+}
+
+// itemFromQuery loads a new Item from the "url", "title" and "text" query parameters used by
+// the bookmarklet and the web share target
+func itemFromQuery(q url.Values) *Item {
+	it := new(Item)
+	it.Title = strings.TrimSpace(q.Get("title"))
+	it.Data = strings.TrimSpace(q.Get("url"))
+	if len(it.Data) == 0 {
+		text := strings.TrimSpace(q.Get("text"))
+		if link := linkFromText(text); len(link) > 0 {
+			it.Data = link
+		} else {
+			it.Data = text
+		}
+	}
+	if len(it.Data) > 0 {
+		it.MimeType = detectMimeType(it.Data)
+	}
+	return it
+}
+
 func AddModelMw(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		m := ContextContentModel(ctx)
 		if m == nil {
 			m = new(contentModel)
-			m.Content = new(Item)
+			m.Content = itemFromQuery(r.URL.Query())
 		}
 		m.tpl = "new"
 		m.Message.ShowTitle = true
@@ -290,6 +336,32 @@ func AddModelMw(next http.Handler) http.Handler {
 	})
 }
 
+// LoadLinkDuplicatesMw loads the items that have already been submitted for the link
+// the submission form has been prefilled with
+func LoadLinkDuplicatesMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := ContextContentModel(r.Context())
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		it, ok := m.Content.(*Item)
+		if !ok || !it.IsLink() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		repo := ContextRepository(r.Context())
+		items, err := repo.LoadItemsByURL(context.TODO(), it.Data)
+		if err != nil {
+			repo.errFn(log.Ctx{"err": err.Error(), "url": it.Data})("unable to load items for link")
+		}
+		for i := range items {
+			m.Duplicates = append(m.Duplicates, &items[i])
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func reportModelFromCtx(ctx context.Context) *moderationModel {
 	if _, ok := ContextModel(ctx).(*errorModel); ok {
 		return nil
@@ -21,9 +21,12 @@ type listingModel struct {
 	User     *Account
 	Items    RenderableList
 	ShowText bool
-	after    Hash
-	before   Hash
-	sortFn   func(list RenderableList) []Renderable
+	// Hidden is the number of items from the accounts the viewer blocked or ignored
+	Hidden     int
+	ShowHidden bool
+	after      Hash
+	before     Hash
+	sortFn     func(list RenderableList) []Renderable
 }
 
 func (m listingModel) NextPage() Hash {
@@ -41,6 +44,8 @@ func (m *listingModel) SetCursor(c *Cursor) {
 	m.Items = c.items
 	m.after = c.after
 	m.before = c.before
+	m.Hidden = c.hidden
+	m.ShowHidden = c.showHidden
 }
 
 func (m *listingModel) SetTitle(s string) {
@@ -77,8 +82,12 @@ type contentModel struct {
 	Content      Renderable
 	ShowChildren bool
 	Message      mBox
-	after        Hash
-	before       Hash
+	Duplicates   []Renderable
+	// Hidden is the number of comments from the accounts the viewer blocked or ignored
+	Hidden     int
+	ShowHidden bool
+	after      Hash
+	before     Hash
 }
 
 func (m contentModel) NextPage() Hash {
@@ -136,6 +145,8 @@ func (m *contentModel) SetCursor(c *Cursor) {
 	}
 	m.after = c.after
 	m.before = c.before
+	m.Hidden = c.hidden
+	m.ShowHidden = c.showHidden
 	if len(c.items) == 0 {
 		return
 	}
@@ -222,6 +233,47 @@ func (loginModel) Template() string {
 
 func (*loginModel) SetCursor(c *Cursor) {}
 
+type forgotPasswordModel struct {
+	Title string
+}
+
+func (m *forgotPasswordModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (forgotPasswordModel) Template() string {
+	return "forgot-password"
+}
+
+func (*forgotPasswordModel) SetCursor(c *Cursor) {}
+
+type passwordResetModel struct {
+	Title  string
+	Handle string
+	Token  string
+}
+
+func (m *passwordResetModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (passwordResetModel) Template() string {
+	return "password-reset"
+}
+
+type twoFactorLoginModel struct {
+	Title  string
+	Handle string
+}
+
+func (m *twoFactorLoginModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (twoFactorLoginModel) Template() string {
+	return "login-2fa"
+}
+
 type registerModel struct {
 	Title   string
 	Account Account
@@ -237,8 +289,9 @@ func (registerModel) Template() string {
 func (*registerModel) SetCursor(c *Cursor) {}
 
 type aboutModel struct {
-	Title string
-	Desc  Desc
+	Title       string
+	Desc        Desc
+	Bookmarklet template.URL
 }
 
 func (m *aboutModel) SetTitle(s string) {
@@ -266,3 +319,282 @@ func (errorModel) Template() string {
 	return "error"
 }
 func (*errorModel) SetCursor(c *Cursor) {}
+
+// ItemRevision is a version of an Item together with the changes from its previous version
+type ItemRevision struct {
+	Item
+	TitleDiff []DiffLine
+	DataDiff  []DiffLine
+}
+
+type historyModel struct {
+	Title     string
+	Content   *Item
+	Revisions []ItemRevision
+}
+
+func (m *historyModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (historyModel) Template() string {
+	return "history"
+}
+
+func (*historyModel) SetCursor(c *Cursor) {}
+
+// itemRevisionDiffs builds the differences between successive revisions.
+// It returns them in reverse chronological order.
+func itemRevisionDiffs(revisions ItemCollection) []ItemRevision {
+	result := make([]ItemRevision, len(revisions))
+	prev := Item{}
+	for i, rev := range revisions {
+		result[len(revisions)-1-i] = ItemRevision{
+			Item:      rev,
+			TitleDiff: diffLines(prev.Title, rev.Title),
+			DataDiff:  diffLines(prev.Data, rev.Data),
+		}
+		prev = rev
+	}
+	return result
+}
+
+type rolesModel struct {
+	Title      string
+	Roles      AccountRoles
+	ValidRoles []Role
+	Admins     []string
+}
+
+func (m *rolesModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (rolesModel) Template() string {
+	return "roles"
+}
+
+type inviteesModel struct {
+	Title            string
+	User             *Account
+	Count            int
+	InvitesSuspended bool
+}
+
+func (m *inviteesModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (inviteesModel) Template() string {
+	return "invitees"
+}
+
+type invitationsModel struct {
+	Title       string
+	Invitations Invitations
+	Quota       int
+	Used        int
+	Suspended   bool
+}
+
+func (m *invitationsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (invitationsModel) Template() string {
+	return "invitations"
+}
+
+type transparencyModel struct {
+	Title  string
+	Months []transparencyMonth
+	Limit  int
+}
+
+func (m *transparencyModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (transparencyModel) Template() string {
+	return "transparency"
+}
+
+type settingsModel struct {
+	Title      string
+	User       *Account
+	Email      string
+	TwoFactor  bool
+	Identities []string
+}
+
+func (m *settingsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (settingsModel) Template() string {
+	return "settings"
+}
+
+// Linked returns true if the account is linked to a user of the provider login provider
+func (m settingsModel) Linked(provider string) bool {
+	return stringInSlice(m.Identities)(provider)
+}
+
+// Links returns the profile links of the account, padded with empty ones up to the maximum number allowed
+func (m settingsModel) Links() []ProfileLink {
+	links := make([]ProfileLink, 0, MaxProfileLinks)
+	links = append(links, m.User.ProfileLinks()...)
+	for len(links) < MaxProfileLinks {
+		links = append(links, ProfileLink{})
+	}
+	return links
+}
+
+type twoFactorModel struct {
+	Title         string
+	User          *Account
+	Enabled       bool
+	Secret        string
+	QRCode        template.URL
+	RecoveryCodes []string
+	Remaining     int
+}
+
+func (m *twoFactorModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (twoFactorModel) Template() string {
+	return "two-factor"
+}
+
+type notificationsModel struct {
+	Title         string
+	User          *Account
+	Notifications []Notification
+	Unread        int
+}
+
+func (m *notificationsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (notificationsModel) Template() string {
+	return "notifications"
+}
+
+// savedEntry is an item from the saved list, together with the item itself
+type savedEntry struct {
+	SavedItem
+	Item *Item
+}
+
+type savedModel struct {
+	Title  string
+	User   *Account
+	Items  []savedEntry
+	Tag    string
+	Tags   []string
+	after  Hash
+	before Hash
+}
+
+func (m savedModel) NextPage() Hash {
+	return m.after
+}
+
+func (m savedModel) PrevPage() Hash {
+	return m.before
+}
+
+func (m *savedModel) SetCursor(c *Cursor) {
+	if c == nil {
+		return
+	}
+	m.after = c.after
+	m.before = c.before
+}
+
+func (m *savedModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (savedModel) Template() string {
+	return "saved"
+}
+
+type hiddenItemsModel struct {
+	Title  string
+	User   *Account
+	Items  ItemPtrCollection
+	after  Hash
+	before Hash
+}
+
+func (m hiddenItemsModel) NextPage() Hash {
+	return m.after
+}
+
+func (m hiddenItemsModel) PrevPage() Hash {
+	return m.before
+}
+
+func (m *hiddenItemsModel) SetCursor(c *Cursor) {
+	if c == nil {
+		return
+	}
+	m.after = c.after
+	m.before = c.before
+}
+
+func (m *hiddenItemsModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (hiddenItemsModel) Template() string {
+	return "hidden"
+}
+
+type accessTokensModel struct {
+	Title  string
+	User   *Account
+	Token  string
+	Tokens []AccessToken
+	Scopes []string
+}
+
+func (m *accessTokensModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (accessTokensModel) Template() string {
+	return "access-tokens"
+}
+
+type deleteAccountModel struct {
+	Title     string
+	User      *Account
+	TwoFactor bool
+}
+
+func (m *deleteAccountModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (deleteAccountModel) Template() string {
+	return "delete-account"
+}
+
+type moveAccountModel struct {
+	Title string
+	User  *Account
+	Move  AccountMove
+}
+
+func (m *moveAccountModel) SetTitle(s string) {
+	m.Title = s
+}
+
+func (moveAccountModel) Template() string {
+	return "move-account"
+}
@@ -10,6 +10,37 @@ import (
 
 var ValidModerationActivityTypes = pub.ActivityVocabularyTypes{pub.BlockType, pub.IgnoreType, pub.FlagType}
 
+// ReportResolution is the way a moderator chose to resolve a report
+type ReportResolution string
+
+const (
+	// ResolutionDismiss closes the report without any action
+	ResolutionDismiss = ReportResolution("dismiss")
+	// ResolutionDelete removes the reported item
+	ResolutionDelete = ReportResolution("delete")
+	// ResolutionBlock blocks the reported account, or the author of the reported item
+	ResolutionBlock = ReportResolution("block")
+	// ResolutionEscalate forwards the report to the instance administrators
+	ResolutionEscalate = ReportResolution("escalate")
+)
+
+// ValidReportResolutions are the actions available to moderators for resolving a report
+var ValidReportResolutions = []ReportResolution{
+	ResolutionDismiss,
+	ResolutionDelete,
+	ResolutionBlock,
+	ResolutionEscalate,
+}
+
+// ModerationFollowupActivityTypes are the activity types moderators use to resolve a moderation request
+var ModerationFollowupActivityTypes = pub.ActivityVocabularyTypes{
+	pub.DeleteType,
+	pub.UpdateType,
+	pub.BlockType,
+	pub.RejectType,
+	pub.FlagType,
+}
+
 // ModerationRequests
 type ModerationRequests []ModerationOp
 
@@ -104,6 +135,50 @@ func (m ModerationGroup) IsReport() bool {
 	return m.Requests[0].IsReport()
 }
 
+// IsResolved returns true if all the requests in the group have received a followup from a moderator
+func (m ModerationGroup) IsResolved() bool {
+	if len(m.Requests) == 0 {
+		return false
+	}
+	for _, req := range m.Requests {
+		if m.followupFor(req) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// IsEscalated returns true if any of the requests in the group have been escalated by a moderator
+func (m ModerationGroup) IsEscalated() bool {
+	for _, fw := range m.Followup {
+		if fw.IsEscalation() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m ModerationGroup) hasFollowup(op ModerationOp) bool {
+	for _, fw := range m.Followup {
+		if fw.Hash == op.Hash {
+			return true
+		}
+	}
+	return false
+}
+
+func (m ModerationGroup) followupFor(req *ModerationOp) *ModerationOp {
+	if req == nil || req.AP() == nil {
+		return nil
+	}
+	for _, fw := range m.Followup {
+		if fw.IsFollowupOf(*req) {
+			return fw
+		}
+	}
+	return nil
+}
+
 type ModerationOp struct {
 	Hash        Hash                `json:"hash"`
 	Icon        template.HTML       `json:"-"`
@@ -178,6 +253,51 @@ func (m ModerationOp) IsReport() bool {
 	return m.pub.GetType() == pub.FlagType
 }
 
+// IsDismiss returns true if current moderation request is the dismissal of a report
+func (m ModerationOp) IsDismiss() bool {
+	if m.pub == nil {
+		return false
+	}
+	return m.pub.GetType() == pub.RejectType && !m.IsInviteRestriction()
+}
+
+// IsInviteRestriction returns true if current moderation request suspends the invite rights of an account
+func (m ModerationOp) IsInviteRestriction() bool {
+	if m.pub == nil || m.pub.GetType() != pub.RejectType {
+		return false
+	}
+	_, ok := m.Object.(*Account)
+	return ok
+}
+
+// IsDefederation returns true if current moderation request blocks a whole instance, instead of a single account
+func (m ModerationOp) IsDefederation() bool {
+	if !m.IsBlock() {
+		return false
+	}
+	a, ok := m.Object.(*Account)
+	if !ok || a.pub == nil {
+		return false
+	}
+	// NOTE(marius): the instances are represented by their Application or Service actors, blocking a Group
+	//   or an Organization is not a defederation
+	typ := a.pub.GetType()
+	return typ == pub.ApplicationType || typ == pub.ServiceType
+}
+
+// IsEscalation returns true if current moderation request is a report forwarded by a moderator
+func (m ModerationOp) IsEscalation() bool {
+	return m.IsReport() && m.Metadata != nil && len(m.Metadata.InReplyTo) > 0
+}
+
+// IsFollowupOf returns true if current moderation request was made in response to the req one
+func (m ModerationOp) IsFollowupOf(req ModerationOp) bool {
+	if m.Metadata == nil || req.AP() == nil {
+		return false
+	}
+	return m.Metadata.InReplyTo.Contains(req.AP().GetLink())
+}
+
 // AP returns the underlying actvitypub item
 func (m *ModerationOp) AP() pub.Item {
 	return m.pub
@@ -374,14 +494,22 @@ func aggregateModeration(rl RenderableList, followups []ModerationOp) Renderable
 			mg = groups[i]
 			mg.Requests = append(mg.Requests, m)
 		}
-		for _, fw := range followups {
+		for i := range followups {
+			fw := &followups[i]
+			if mg.hasFollowup(*fw) {
+				continue
+			}
+			if fw.IsFollowupOf(*m) {
+				mg.Followup = append(mg.Followup, fw)
+				continue
+			}
 			if mg.Object == nil || fw.Object == nil {
 				continue
 			}
 			mObIRI := mg.Object.AP()
 			fwIRI := fw.Object.AP()
 			if mObIRI != nil && fwIRI != nil && mObIRI.GetLink().Equals(fwIRI.GetLink(), false) {
-				mg.Followup = append(mg.Followup, &fw)
+				mg.Followup = append(mg.Followup, fw)
 			}
 		}
 	}
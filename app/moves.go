@@ -0,0 +1,580 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const movesCollection = "moves"
+
+const (
+	// actorFetchTimeout is the maximum time we allow for retrieving a remote actor or its WebFinger resource
+	actorFetchTimeout = 5 * time.Second
+	// actorFetchMaxSize is the maximum number of bytes we read from a remote actor or its WebFinger resource
+	actorFetchMaxSize = 256 * 1024
+
+	maxAccountAliases = 10
+	// maxHandledMoves is the number of processed Move activities we remember for an account
+	maxHandledMoves = 200
+	// incomingMovesTimeout is the maximum time we allow for handling the Move activities received by an account
+	incomingMovesTimeout = time.Minute
+)
+
+// alsoKnownAsRel is the relation of the links to the aliases of an account in the attachments of its actor
+const alsoKnownAsRel = pub.IRI("https://www.w3.org/ns/activitystreams#alsoKnownAs")
+
+// AccountMove holds the accounts the account is also known as, and the account it moved to.
+// FedBOX doesn't support the alsoKnownAs property of the actors, so we keep them in the instance's local storage,
+// and we publish them as links in the attachments of the actor.
+type AccountMove struct {
+	AlsoKnownAs []string  `json:"alsoKnownAs,omitempty"`
+	MovedTo     string    `json:"movedTo,omitempty"`
+	MovedAt     time.Time `json:"movedAt,omitempty"`
+	// Handled are the incoming Move activities of the followed accounts that were already processed
+	Handled []string `json:"handled,omitempty"`
+}
+
+// accountsMoves are the moves of all the accounts, keyed by their IRI.
+type accountsMoves map[string]AccountMove
+
+// Moved returns true if the account moved to another one
+func (m AccountMove) Moved() bool {
+	return len(m.MovedTo) > 0
+}
+
+// IsHandled returns true if the Move activity with the id was already processed
+func (m AccountMove) IsHandled(id string) bool {
+	return stringInSlice(m.Handled)(id)
+}
+
+var actorClient = newOutgoingClient(actorFetchTimeout)
+
+var validActorContentTypes = []string{
+	"application/activity+json",
+	"application/ld+json",
+	"application/json",
+	"application/jrd+json",
+}
+
+// actorIRI validates that the s value is the IRI of an actor
+func actorIRI(s string) (pub.IRI, error) {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", errors.BadRequestf("invalid actor IRI %q", s)
+	}
+	return pub.IRI(u.String()), nil
+}
+
+// aliasesFromString loads the aliases of an account from the lines of the s text
+func aliasesFromString(s string) ([]string, error) {
+	aliases := make([]string, 0)
+	for _, l := range strings.Split(s, "\n") {
+		if len(strings.TrimSpace(l)) == 0 {
+			continue
+		}
+		iri, err := actorIRI(l)
+		if err != nil {
+			return nil, err
+		}
+		if !stringInSlice(aliases)(iri.String()) {
+			aliases = append(aliases, iri.String())
+		}
+	}
+	if len(aliases) > maxAccountAliases {
+		return nil, errors.BadRequestf("too many aliases, the maximum is %d", maxAccountAliases)
+	}
+	return aliases, nil
+}
+
+// splitAccountHandle splits a @user@host account handle in its user and host parts
+func splitAccountHandle(s string) (string, string, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "@")
+	ar := strings.Split(s, "@")
+	if len(ar) != 2 || len(ar[0]) == 0 || len(ar[1]) == 0 {
+		return "", "", errors.BadRequestf("invalid account handle %q", s)
+	}
+	return ar[0], ar[1], nil
+}
+
+// parseAlsoKnownAs returns the alsoKnownAs property of the raw JSON actor.
+// The property can be a single IRI, a list of IRIs, or a list of objects.
+func parseAlsoKnownAs(raw []byte) ([]string, error) {
+	actor := struct {
+		AlsoKnownAs json.RawMessage `json:"alsoKnownAs"`
+		Attachment  json.RawMessage `json:"attachment"`
+	}{}
+	if err := json.Unmarshal(raw, &actor); err != nil {
+		return nil, err
+	}
+	if len(actor.AlsoKnownAs) == 0 {
+		return parseAliasLinks(actor.Attachment), nil
+	}
+	var single string
+	if err := json.Unmarshal(actor.AlsoKnownAs, &single); err == nil {
+		return []string{single}, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(actor.AlsoKnownAs, &list); err != nil {
+		return nil, err
+	}
+	aliases := make([]string, 0, len(list))
+	for _, el := range list {
+		var iri string
+		if err := json.Unmarshal(el, &iri); err == nil {
+			aliases = append(aliases, iri)
+			continue
+		}
+		ob := struct {
+			ID string `json:"id"`
+		}{}
+		if err := json.Unmarshal(el, &ob); err == nil && len(ob.ID) > 0 {
+			aliases = append(aliases, ob.ID)
+		}
+	}
+	return aliases, nil
+}
+
+// parseAliasLinks returns the aliases from the raw JSON attachments of an actor, for the servers which,
+// like FedBOX, don't keep the alsoKnownAs property
+func parseAliasLinks(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	type link struct {
+		Type string `json:"type"`
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	}
+	links := make([]link, 0)
+	if err := json.Unmarshal(raw, &links); err != nil {
+		l := link{}
+		if err = json.Unmarshal(raw, &l); err != nil {
+			return nil
+		}
+		links = append(links, l)
+	}
+	aliases := make([]string, 0)
+	for _, l := range links {
+		if l.Type == string(pub.LinkType) && l.Rel == alsoKnownAsRel.String() && len(l.Href) > 0 {
+			aliases = append(aliases, l.Href)
+		}
+	}
+	if len(aliases) == 0 {
+		return nil
+	}
+	return aliases
+}
+
+// loadAPAliases adds the aliases of an account to the attachments of its actor, as links with the alsoKnownAs
+// relation. FedBOX doesn't keep the alsoKnownAs property of the actors.
+func loadAPAliases(attachment pub.Item, aliases []string) pub.Item {
+	if len(aliases) == 0 {
+		return attachment
+	}
+	col := make(pub.ItemCollection, 0)
+	if items, ok := attachment.(pub.ItemCollection); ok {
+		col = append(col, items...)
+	}
+	for _, al := range aliases {
+		lnk := pub.LinkNew(pub.ID(al), pub.LinkType)
+		lnk.Href = pub.IRI(al)
+		lnk.Rel = alsoKnownAsRel
+		col = append(col, lnk)
+	}
+	return col
+}
+
+// aliasesFromAttachments loads the aliases of an account from the attachments of its actor
+func aliasesFromAttachments(it pub.Item) []string {
+	aliases := make([]string, 0)
+	pub.OnCollectionIntf(it, func(col pub.CollectionInterface) error {
+		for _, ob := range col.Collection() {
+			if ob == nil || ob.GetType() != pub.LinkType {
+				continue
+			}
+			pub.OnLink(ob, func(l *pub.Link) error {
+				if l.Rel.Equals(alsoKnownAsRel, false) && len(l.Href) > 0 {
+					aliases = append(aliases, l.Href.String())
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return aliases
+}
+
+// fetchJSON loads the JSON document at u with the accept content type
+func fetchJSON(ctx context.Context, u, accept string) ([]byte, error) {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid URL %s", u)
+	}
+	if err = checkOutgoingURL(uu); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, actorFetchTimeout)
+	defer cancel()
+
+	// NOTE(marius): we don't use the FedBOX client for these requests, as it would send the OAuth2 token
+	//   of the current account to the remote server
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uu.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", client.UserAgent)
+
+	resp, err := actorClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Newf("unable to load %s: %s", u, resp.Status)
+	}
+	ct, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid content type for %s", u)
+	}
+	if !stringInSlice(validActorContentTypes)(ct) {
+		return nil, errors.Newf("unable to load %s: content type %s", u, ct)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, actorFetchMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// resolveActorIRI returns the IRI of the actor with the s IRI or @user@host account handle
+func resolveActorIRI(ctx context.Context, s string) (pub.IRI, error) {
+	if !strings.Contains(s, "://") {
+		user, host, err := splitAccountHandle(s)
+		if err != nil {
+			return "", err
+		}
+		q := url.Values{}
+		q.Set("resource", fmt.Sprintf("acct:%s@%s", user, host))
+		wfURL := fmt.Sprintf("https://%s/.well-known/webfinger?%s", host, q.Encode())
+		raw, err := fetchJSON(ctx, wfURL, "application/jrd+json")
+		if err != nil {
+			return "", errors.Annotatef(err, "unable to load WebFinger resource for %s", s)
+		}
+		wf := node{}
+		if err = json.Unmarshal(raw, &wf); err != nil {
+			return "", errors.Annotatef(err, "invalid WebFinger resource for %s", s)
+		}
+		for _, l := range wf.Links {
+			if l.Rel == selfName && stringInSlice(validActorContentTypes)(l.Type) {
+				return actorIRI(l.Href)
+			}
+		}
+		return "", errors.NotFoundf("actor not found for %s", s)
+	}
+	return actorIRI(s)
+}
+
+// loadActorAliases loads the alsoKnownAs property of the remote actor with the iri
+func loadActorAliases(ctx context.Context, iri pub.IRI) ([]string, error) {
+	raw, err := fetchJSON(ctx, iri.String(), `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	if err != nil {
+		return nil, err
+	}
+	return parseAlsoKnownAs(raw)
+}
+
+// loadAccountAliases loads the aliases of the account with the iri.
+// For the local accounts we use the ones in the local storage, as FedBOX doesn't have them in the actor.
+func (r *repository) loadAccountAliases(ctx context.Context, iri pub.IRI) ([]string, error) {
+	if HostIsLocal(iri.String()) {
+		a := Account{}
+		a.FromActivityPub(iri)
+		m, err := r.LoadAccountMove(ctx, a)
+		return m.AlsoKnownAs, err
+	}
+	return loadActorAliases(ctx, iri)
+}
+
+// LoadAccountMove loads the aliases of the a account, and the account it moved to
+func (r *repository) LoadAccountMove(ctx context.Context, a Account) (AccountMove, error) {
+	all := make(accountsMoves)
+	if err := r.store.Load(movesCollection, &all); err != nil {
+		return AccountMove{}, err
+	}
+	return all[accountRoleID(a)], nil
+}
+
+func (r *repository) updateAccountMove(a Account, fn func(m *AccountMove) error) (AccountMove, error) {
+	all := make(accountsMoves)
+	var result AccountMove
+	err := r.store.Update(movesCollection, &all, func() error {
+		m := all[accountRoleID(a)]
+		if err := fn(&m); err != nil {
+			return err
+		}
+		all[accountRoleID(a)] = m
+		result = m
+		return nil
+	})
+	return result, err
+}
+
+// SaveAccountAliases sets the accounts the a account is also known as, these are the ones that are allowed to move to it
+// The actor of the account is updated, so the remote servers can load them too.
+func (r *repository) SaveAccountAliases(ctx context.Context, a Account, aliases []string) (AccountMove, error) {
+	m, err := r.updateAccountMove(a, func(m *AccountMove) error {
+		m.AlsoKnownAs = aliases
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	_, err = r.UpdateAccount(ctx, a)
+	return m, err
+}
+
+// MoveAccount moves the a account to the target one: it sends a Move activity to its followers and it redirects
+// its profile. The target account must have the a account as an alias.
+func (r *repository) MoveAccount(ctx context.Context, a Account, target pub.IRI) (AccountMove, error) {
+	if !accountValidForC2S(&a) {
+		return AccountMove{}, errors.Unauthorizedf("invalid account %s", a.Handle)
+	}
+	id := pub.IRI(a.Metadata.ID)
+	if target.Equals(id, false) {
+		return AccountMove{}, errors.BadRequestf("an account can not move to itself")
+	}
+	aliases, err := r.loadAccountAliases(ctx, target)
+	if err != nil {
+		return AccountMove{}, errors.Annotatef(err, "unable to load the account %s", target)
+	}
+	if !stringInSlice(aliases)(id.String()) {
+		return AccountMove{}, errors.BadRequestf("the account %s doesn't have %s as an alias", target, id)
+	}
+
+	cc := make(pub.ItemCollection, 0)
+	if len(a.Metadata.FollowersIRI) > 0 {
+		cc = append(cc, pub.IRI(a.Metadata.FollowersIRI))
+	}
+	move := &pub.Activity{
+		Type:   pub.MoveType,
+		To:     pub.ItemCollection{pub.PublicNS},
+		CC:     cc,
+		BCC:    pub.ItemCollection{r.fedbox.Service().ID},
+		Actor:  id,
+		Object: id,
+		Target: target,
+	}
+	if _, _, err = r.fedbox.ToOutbox(ctx, move); err != nil {
+		r.errFn(log.Ctx{"err": err, "handle": a.Handle, "target": target})("unable to move account")
+		return AccountMove{}, err
+	}
+	return r.updateAccountMove(a, func(m *AccountMove) error {
+		m.MovedTo = target.String()
+		m.MovedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// handleIncomingMoves follows the new accounts of the accounts followed by acc, that moved.
+// The Move activities are valid only if the new account has the old one as an alias.
+func (r *repository) handleIncomingMoves(ctx context.Context, acc *Account) error {
+	if !acc.IsLogged() || len(acc.Following) == 0 {
+		return nil
+	}
+	move, err := r.LoadAccountMove(ctx, *acc)
+	if err != nil {
+		return err
+	}
+	f := &Filters{Type: ActivityTypesFilter(pub.MoveType)}
+	col, err := r.fedbox.Inbox(ctx, r.loadAPPerson(*acc), Values(f))
+	if err != nil {
+		return err
+	}
+	ltx := log.Ctx{"handle": acc.Handle}
+	handled := make([]string, 0)
+	for _, it := range col.Collection() {
+		id := it.GetLink().String()
+		if move.IsHandled(id) {
+			continue
+		}
+		pub.OnActivity(it, func(act *pub.Activity) error {
+			if act.Actor == nil || act.Object == nil || act.Target == nil {
+				return nil
+			}
+			from := act.Object.GetLink()
+			if !act.Actor.GetLink().Equals(from, false) {
+				return nil
+			}
+			old := Account{}
+			old.FromActivityPub(from)
+			if !accountInCollection(old, acc.Following) {
+				return nil
+			}
+			handled = append(handled, id)
+
+			to := act.Target.GetLink()
+			aliases, err := r.loadAccountAliases(ctx, to)
+			if err != nil {
+				r.errFn(ltx, log.Ctx{"err": err.Error(), "target": to})("unable to load moved account")
+				return nil
+			}
+			if !stringInSlice(aliases)(from.String()) {
+				r.infoFn(ltx, log.Ctx{"from": from, "target": to})("invalid move, the target doesn't have the account as an alias")
+				return nil
+			}
+			ed := Account{}
+			ed.FromActivityPub(to)
+			if accountInCollection(ed, acc.Following) {
+				return nil
+			}
+			if err := r.FollowAccount(ctx, *acc, ed, nil); err != nil {
+				r.errFn(ltx, log.Ctx{"err": err.Error(), "target": to})("unable to follow moved account")
+				return nil
+			}
+			r.infoFn(ltx, log.Ctx{"from": from, "target": to})("followed moved account")
+			return nil
+		})
+	}
+	if len(handled) == 0 {
+		return nil
+	}
+	_, err = r.updateAccountMove(*acc, func(m *AccountMove) error {
+		m.Handled = append(m.Handled, handled...)
+		if len(m.Handled) > maxHandledMoves {
+			m.Handled = m.Handled[len(m.Handled)-maxHandledMoves:]
+		}
+		return nil
+	})
+	return err
+}
+
+// incomingMoves holds the accounts for which we are handling the received Move activities
+var incomingMoves sync.Map
+
+// HandleIncomingMoves handles in the background the Move activities received by the acc account.
+// The requests are signed as the account by their own client, as the shared one changes its signing account
+// with every request we serve.
+func (r *repository) HandleIncomingMoves(acc Account) {
+	if !acc.IsLogged() || !acc.HasMetadata() {
+		return
+	}
+	id := accountRoleID(acc)
+	if _, running := incomingMoves.LoadOrStore(id, true); running {
+		return
+	}
+	md := *acc.Metadata
+	acc.Metadata = &md
+	go func() {
+		defer incomingMoves.Delete(id)
+		ctx, cancel := context.WithTimeout(context.Background(), incomingMovesTimeout)
+		defer cancel()
+		if err := r.forAccount(&acc).handleIncomingMoves(ctx, &acc); err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to handle Move activities of followed accounts")
+		}
+	}()
+}
+
+// RedirectMovedMw redirects the profile of the accounts that moved to their new account.
+// The account itself still has access to the profile.
+func (h *handler) RedirectMovedMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authors := ContextAuthors(r.Context())
+		if len(authors) == 0 || !authors[0].IsLocal() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		move, err := h.storage.LoadAccountMove(context.TODO(), authors[0])
+		if err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": authors[0].Handle})("unable to load account's move")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if move.Moved() && loggedAccount(r).Hash != authors[0].Hash {
+			h.v.Redirect(w, r, move.MovedTo, http.StatusFound)
+			return
+		}
+		authors[0].Move = move
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleMoveAccountForm serves /~{handle}/settings/move request
+func (h *handler) HandleMoveAccountForm(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	move, err := h.storage.LoadAccountMove(context.TODO(), *acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &moveAccountModel{Title: "Move account", User: acc, Move: move}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleMoveAccount serves /~{handle}/settings/move POST request
+func (h *handler) HandleMoveAccount(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings/move", PermaLink(acc))
+	ctx := context.TODO()
+
+	switch r.PostFormValue("action") {
+	case "aliases":
+		aliases, err := aliasesFromString(r.PostFormValue("aliases"))
+		if err != nil {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save aliases: %s", err))
+			break
+		}
+		if _, err = h.storage.SaveAccountAliases(ctx, *acc, aliases); err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to save account aliases")
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save aliases: %s", err))
+			break
+		}
+		h.v.addFlashMessage(Success, w, r, "Aliases saved")
+	case "move":
+		config := GetOauth2Config("fedbox", h.conf.BaseURL)
+		tok, err := config.PasswordCredentialsToken(ctx, acc.Handle, r.PostFormValue("pw"))
+		if err != nil || tok == nil {
+			h.v.addFlashMessage(Error, w, r, "Unable to move account: the password is not valid")
+			break
+		}
+		target, err := resolveActorIRI(ctx, r.PostFormValue("target"))
+		if err != nil {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to move account: %s", err))
+			break
+		}
+		if _, err = h.storage.MoveAccount(ctx, *acc, target); err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle, "target": target})("unable to move account")
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to move account: %s", err))
+			break
+		}
+		h.infoFn(log.Ctx{"handle": acc.Handle, "target": target})("account moved")
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Your account was moved to %s, your followers were notified", target))
+	default:
+		h.v.addFlashMessage(Error, w, r, "Invalid action")
+	}
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAlsoKnownAs(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{name: "missing", raw: `{"id":"https://example.com/users/jane"}`, want: nil},
+		{name: "single", raw: `{"alsoKnownAs":"https://example.com/users/jane"}`, want: []string{"https://example.com/users/jane"}},
+		{
+			name: "list",
+			raw:  `{"alsoKnownAs":["https://example.com/users/jane","https://example.org/~jane"]}`,
+			want: []string{"https://example.com/users/jane", "https://example.org/~jane"},
+		},
+		{
+			name: "objects",
+			raw:  `{"alsoKnownAs":[{"id":"https://example.com/users/jane","type":"Person"},"https://example.org/~jane"]}`,
+			want: []string{"https://example.com/users/jane", "https://example.org/~jane"},
+		},
+		{
+			name: "attachments",
+			raw:  `{"attachment":[{"type":"Link","href":"https://example.org/~jane","rel":"https://www.w3.org/ns/activitystreams#alsoKnownAs"},{"type":"Link","href":"https://jane.example.com"}]}`,
+			want: []string{"https://example.org/~jane"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAlsoKnownAs([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("parseAlsoKnownAs() error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAlsoKnownAs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAliasesFromString(t *testing.T) {
+	got, err := aliasesFromString("https://example.com/users/jane\n\n  https://example.org/~jane \r\nhttps://example.com/users/jane")
+	if err != nil {
+		t.Fatalf("aliasesFromString() error: %s", err)
+	}
+	want := []string{"https://example.com/users/jane", "https://example.org/~jane"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("aliasesFromString() = %v, want %v", got, want)
+	}
+	if _, err := aliasesFromString("jane@example.com"); err == nil {
+		t.Errorf("aliasesFromString() expected error for an account handle")
+	}
+}
+
+func TestSplitAccountHandle(t *testing.T) {
+	tests := []struct {
+		handle  string
+		user    string
+		host    string
+		wantErr bool
+	}{
+		{handle: "@jane@example.com", user: "jane", host: "example.com"},
+		{handle: "jane@example.com", user: "jane", host: "example.com"},
+		{handle: "jane", wantErr: true},
+		{handle: "@jane@", wantErr: true},
+	}
+	for _, tt := range tests {
+		user, host, err := splitAccountHandle(tt.handle)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitAccountHandle(%q) error = %v, wantErr %t", tt.handle, err, tt.wantErr)
+			continue
+		}
+		if user != tt.user || host != tt.host {
+			t.Errorf("splitAccountHandle(%q) = %q, %q, want %q, %q", tt.handle, user, host, tt.user, tt.host)
+		}
+	}
+}
+
+func TestAliasesFromAttachments(t *testing.T) {
+	links := []ProfileLink{{Name: "blog", URL: "https://jane.example.com"}}
+	aliases := []string{"https://example.org/~jane"}
+
+	attachment := loadAPAliases(loadAPProfileLinks(links), aliases)
+	if got := aliasesFromAttachments(attachment); !reflect.DeepEqual(got, aliases) {
+		t.Errorf("aliasesFromAttachments() = %v, want %v", got, aliases)
+	}
+	if got := profileLinksFromAttachments(attachment); !reflect.DeepEqual(got, links) {
+		t.Errorf("profileLinksFromAttachments() = %v, want %v", got, links)
+	}
+}
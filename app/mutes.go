@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const mutesCollection = "mutes"
+
+const (
+	muteTag    = "tag"
+	muteDomain = "domain"
+)
+
+// AccountMutes are the tags and link domains an account doesn't want to see in its listings
+type AccountMutes struct {
+	Tags    []string `json:"tags,omitempty"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// accountsMutes are the mutes of all the accounts, keyed by their IRI.
+// The ActivityPub vocabulary doesn't have a way to represent them, so we keep them in the instance's local storage.
+type accountsMutes map[string]AccountMutes
+
+func mutedTag(s string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+}
+
+func mutedDomain(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if u, err := url.Parse(s); err == nil && len(u.Host) > 0 {
+		s = getDomain(u)
+	}
+	return strings.Trim(strings.TrimPrefix(s, "www."), "/")
+}
+
+// IsEmpty returns true if there are no muted tags or domains
+func (m AccountMutes) IsEmpty() bool {
+	return len(m.Tags) == 0 && len(m.Domains) == 0
+}
+
+// MutesTag returns true if the t tag is muted
+func (m AccountMutes) MutesTag(t string) bool {
+	t = mutedTag(t)
+	for _, mt := range m.Tags {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// MutesDomain returns true if the d domain, or one of its parent domains, is muted.
+// A muted domain also matches the accounts on it, like github.com matches github.com/mariusor.
+func (m AccountMutes) MutesDomain(d string) bool {
+	return domainInList(d, m.Domains)
+}
+
+// domainInList returns true if the d domain, one of its parent domains, or the domain of the account it belongs to,
+// is present in the list
+func domainInList(d string, list []string) bool {
+	d = mutedDomain(d)
+	if len(d) == 0 || d == unknownDomain {
+		return false
+	}
+	for _, md := range list {
+		if d == md || strings.HasSuffix(d, "."+md) || strings.HasPrefix(d, md+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MutesItem returns true if the it item has a muted tag or links to a muted domain
+func (m AccountMutes) MutesItem(it *Item) bool {
+	if it == nil || m.IsEmpty() {
+		return false
+	}
+	if it.HasMetadata() {
+		for _, t := range it.Metadata.Tags {
+			if m.MutesTag(t.Name) {
+				return true
+			}
+		}
+	}
+	if it.IsLink() {
+		if u, err := url.Parse(it.Data); err == nil && m.MutesDomain(getDomain(u)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadAccountMutes loads the tags and domains muted by the a account
+func (r *repository) LoadAccountMutes(ctx context.Context, a Account) (AccountMutes, error) {
+	all := make(accountsMutes)
+	if err := r.store.Load(mutesCollection, &all); err != nil {
+		return AccountMutes{}, err
+	}
+	return all[accountRoleID(a)], nil
+}
+
+// UpdateAccountMutes adds, or removes if rm is true, the value tag or domain to the mutes of the a account
+func (r *repository) UpdateAccountMutes(ctx context.Context, a Account, typ, value string, rm bool) (AccountMutes, error) {
+	all := make(accountsMutes)
+	var result AccountMutes
+	err := r.store.Update(mutesCollection, &all, func() error {
+		m := all[accountRoleID(a)]
+		var list *[]string
+		switch typ {
+		case muteTag:
+			value = mutedTag(value)
+			list = &m.Tags
+		case muteDomain:
+			value = mutedDomain(value)
+			list = &m.Domains
+		default:
+			return errors.BadRequestf("invalid mute type %q", typ)
+		}
+		if len(value) == 0 {
+			return errors.BadRequestf("empty %s", typ)
+		}
+		found := -1
+		for i, v := range *list {
+			if v == value {
+				found = i
+			}
+		}
+		if rm {
+			if found < 0 {
+				return errors.NotFoundf("%s %s is not muted", typ, value)
+			}
+			*list = append((*list)[:found], (*list)[found+1:]...)
+		} else {
+			if found >= 0 {
+				return errors.BadRequestf("%s %s is already muted", typ, value)
+			}
+			*list = append(*list, value)
+		}
+		if m.IsEmpty() {
+			delete(all, accountRoleID(a))
+		} else {
+			all[accountRoleID(a)] = m
+		}
+		result = m
+		return nil
+	})
+	return result, err
+}
+
+// HideMutedMw removes from the loaded listing the items that have a tag, or link to a domain, muted by the logged account
+func HideMutedMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acc := loggedAccount(r)
+		c := ContextCursor(r.Context())
+		if c == nil || !acc.IsLogged() || acc.Mutes.IsEmpty() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		show, _ := strconv.ParseBool(r.URL.Query().Get(showHiddenParam))
+		for k, ren := range c.items {
+			if it, ok := ren.(*Item); ok && acc.Mutes.MutesItem(it) {
+				c.hidden++
+				if !show {
+					delete(c.items, k)
+				}
+			}
+		}
+		c.showHidden = show
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleMutes serves /~{handle}/mutes POST request
+func (h *handler) HandleMutes(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 || authors[0].Hash != acc.Hash {
+		h.v.HandleErrors(w, r, errors.Forbiddenf("you can only change your own muted tags and domains"))
+		return
+	}
+	typ := r.PostFormValue("type")
+	value := r.PostFormValue("value")
+	rm := r.PostFormValue("action") == "rm"
+
+	mutes, err := h.storage.UpdateAccountMutes(context.TODO(), *acc, typ, value, rm)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "type": typ, "value": value})("unable to update mutes")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to update muted %ss: %s", typ, err))
+	} else {
+		acc.Mutes = mutes
+		if rm {
+			h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Unmuted %s %s", typ, value))
+		} else {
+			h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Muted %s %s", typ, value))
+		}
+	}
+	h.v.Redirect(w, r, AccountPermaLink(acc), http.StatusSeeOther)
+}
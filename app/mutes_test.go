@@ -0,0 +1,56 @@
+package app
+
+import "testing"
+
+func TestAccountMutes_MutesItem(t *testing.T) {
+	m := AccountMutes{Tags: []string{"politics"}, Domains: []string{"example.com", "github.com/mariusor"}}
+	tagged := func(tags ...string) *Item {
+		it := &Item{Metadata: &ItemMetadata{}}
+		for _, tag := range tags {
+			it.Metadata.Tags = append(it.Metadata.Tags, Tag{Type: TagTag, Name: tag})
+		}
+		return it
+	}
+	link := func(u string) *Item {
+		return &Item{MimeType: MimeTypeURL, Data: u}
+	}
+
+	tests := []struct {
+		name string
+		it   *Item
+		want bool
+	}{
+		{name: "nil", it: nil, want: false},
+		{name: "no tags", it: tagged(), want: false},
+		{name: "other tag", it: tagged("#golang"), want: false},
+		{name: "muted tag", it: tagged("#Politics"), want: true},
+		{name: "other domain", it: link("https://littr.me/about"), want: false},
+		{name: "muted domain", it: link("https://example.com/news/1"), want: true},
+		{name: "muted subdomain", it: link("https://news.example.com/1"), want: true},
+		{name: "muted www domain", it: link("https://www.example.com/1"), want: true},
+		{name: "similar domain", it: link("https://notexample.com/1"), want: false},
+		{name: "muted user domain", it: link("https://github.com/mariusor/go-littr"), want: true},
+		{name: "other user domain", it: link("https://github.com/go-ap/activitypub"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.MutesItem(tt.it); got != tt.want {
+				t.Errorf("MutesItem() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutedDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":               "example.com",
+		" WWW.Example.com/ ":        "example.com",
+		"https://example.com/news":  "example.com",
+		"https://github.com/go-ap/": "github.com/go-ap",
+	}
+	for in, want := range tests {
+		if got := mutedDomain(in); got != want {
+			t.Errorf("mutedDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
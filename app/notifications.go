@@ -0,0 +1,410 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	stdhtml "html"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const notificationsCollection = "notifications"
+
+// incomingCollection holds, for each account, the date of the newest activity we checked in its inbox
+// for the replies and mentions received from other instances
+const incomingCollection = "incoming"
+
+// NotificationType is the kind of event an account is notified about
+type NotificationType string
+
+const (
+	NotificationReply          NotificationType = "reply"
+	NotificationMention        NotificationType = "mention"
+	NotificationFollow         NotificationType = "follow"
+	NotificationFollowAccepted NotificationType = "follow-accepted"
+	NotificationFollowRejected NotificationType = "follow-rejected"
+	NotificationModeration     NotificationType = "moderation"
+)
+
+const (
+	// MaxNotifications is the number of notifications we keep for an account, the older ones are removed
+	MaxNotifications = 200
+
+	maxNotificationTitleLength = 80
+	// incomingTimeout is the maximum time we allow for checking the inbox of an account for replies and mentions
+	incomingTimeout = time.Minute
+)
+
+// Notification tells an account about a reply to its content, a mention, a follow, or a moderation action.
+// We keep the links and the names it shows, so the notifications page doesn't need to load anything from FedBOX.
+type Notification struct {
+	ID        string           `json:"id"`
+	Type      NotificationType `json:"type"`
+	Actor     string           `json:"actor,omitempty"`
+	ActorIRI  string           `json:"actorIRI,omitempty"`
+	ActorURL  string           `json:"actorURL,omitempty"`
+	Action    string           `json:"action,omitempty"`
+	Title     string           `json:"title,omitempty"`
+	URL       string           `json:"url,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Read      bool             `json:"read,omitempty"`
+}
+
+// accountNotifications are the notifications of the accounts, keyed by the account's ID, newest first
+type accountNotifications map[string][]Notification
+
+// Message returns the text shown for the notification, after the name of the account that caused it
+func (n Notification) Message() string {
+	switch n.Type {
+	case NotificationReply:
+		return "replied to your post"
+	case NotificationMention:
+		return "mentioned you"
+	case NotificationFollow:
+		return "wants to follow you"
+	case NotificationFollowAccepted:
+		return "accepted your follow request"
+	case NotificationFollowRejected:
+		return "rejected your follow request"
+	case NotificationModeration:
+		return fmt.Sprintf("A moderator %s your post", n.Action)
+	}
+	return string(n.Type)
+}
+
+// notificationTitle returns a short plain text version of the it item, for showing it in the notifications
+func notificationTitle(it Item) string {
+	t := it.Title
+	if len(t) == 0 {
+		t = stdhtml.UnescapeString(bluemonday.StrictPolicy().Sanitize(it.Data))
+	}
+	return truncate(strings.Join(strings.Fields(t), " "), maxNotificationTitleLength)
+}
+
+func itemNotification(typ NotificationType, it Item) Notification {
+	n := Notification{Type: typ, Title: notificationTitle(it), URL: ItemPermaLink(&it)}
+	if it.SubmittedBy != nil {
+		n = n.withActor(*it.SubmittedBy)
+	}
+	return n
+}
+
+func accountNotification(typ NotificationType, a Account) Notification {
+	return Notification{Type: typ}.withActor(a)
+}
+
+// moderationNotification tells the author of the it item about the action a moderator took on it.
+// NOTE(marius): like in the moderation log, we don't show which moderator it was.
+func moderationNotification(action string, it Item) Notification {
+	return Notification{Type: NotificationModeration, Action: action, Title: notificationTitle(it), URL: ItemPermaLink(&it)}
+}
+
+func (n Notification) withActor(a Account) Notification {
+	n.Actor = a.Handle
+	n.ActorURL = PermaLink(&a)
+	if a.HasMetadata() {
+		n.ActorIRI = a.Metadata.ID
+	}
+	return n
+}
+
+// addNotification adds n on top of the list of notifications, keeping at most MaxNotifications of them
+func addNotification(list []Notification, n Notification) []Notification {
+	list = append([]Notification{n}, list...)
+	if len(list) > MaxNotifications {
+		list = list[:MaxNotifications]
+	}
+	return list
+}
+
+// markNotificationsRead marks the notifications with the ids as read, or all of them when no ids are passed
+func markNotificationsRead(list []Notification, ids ...string) {
+	for i := range list {
+		if len(ids) == 0 || stringInSlice(ids)(list[i].ID) {
+			list[i].Read = true
+		}
+	}
+}
+
+func unreadNotifications(list []Notification) int {
+	count := 0
+	for _, n := range list {
+		if !n.Read {
+			count++
+		}
+	}
+	return count
+}
+
+// Notify saves the n notification for the to account.
+// Only the local accounts are notified, and not about their own actions.
+func (r *repository) Notify(to *Account, n Notification) {
+	if !to.IsValid() || !to.HasMetadata() || len(to.Metadata.ID) == 0 || !to.IsLocal() || to.Deleted() {
+		return
+	}
+	if len(n.ActorIRI) > 0 && n.ActorIRI == to.Metadata.ID {
+		return
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to generate notification id")
+		return
+	}
+	n.ID = hex.EncodeToString(b)
+	n.CreatedAt = time.Now().UTC()
+
+	all := make(accountNotifications)
+	id := accountRoleID(*to)
+	err := r.store.Update(notificationsCollection, &all, func() error {
+		all[id] = addNotification(all[id], n)
+		return nil
+	})
+	if err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": to.Handle, "type": n.Type})("unable to save notification")
+		return
+	}
+	unreadCounts.remove(id)
+}
+
+// notifyItem notifies the author of the parent item about the it reply, and the accounts mentioned in it.
+// The parent is loaded again, as the author of the one submitted with the reply can't be trusted.
+// The replies and mentions we receive from other instances are handled by notifyIncoming.
+func (r *repository) notifyItem(ctx context.Context, it Item, parent *Item, mentioned AccountCollection) {
+	var parentAuthor *Account
+	if parent.IsValid() {
+		p, err := r.LoadItem(ctx, objects.IRI(r.fedbox.Service()).AddPath(parent.Hash.String()))
+		if err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "hash": parent.Hash})("unable to load parent item for notification")
+		} else if p.SubmittedBy.IsValid() {
+			parentAuthor = p.SubmittedBy
+			r.Notify(parentAuthor, itemNotification(NotificationReply, it))
+		}
+	}
+	for i := range mentioned {
+		m := &mentioned[i]
+		if parentAuthor != nil && accountsEqual(*m, *parentAuthor) {
+			// NOTE(marius): the reply notification is enough
+			continue
+		}
+		r.Notify(m, itemNotification(NotificationMention, it))
+	}
+}
+
+// itemMentions returns true if the it item mentions the a account, or is addressed directly to it
+func itemMentions(it Item, a Account) bool {
+	if !it.HasMetadata() || !a.Hash.IsValid() {
+		return false
+	}
+	for _, m := range it.Metadata.Mentions {
+		if len(m.URL) > 0 && HashFromIRI(pub.IRI(m.URL)) == a.Hash {
+			return true
+		}
+	}
+	for _, recipients := range []AccountCollection{it.Metadata.To, it.Metadata.CC} {
+		for _, rec := range recipients {
+			if rec.Hash == a.Hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// incomingChecks are the dates of the newest activities we checked in the inboxes of the accounts,
+// keyed by the account's ID
+type incomingChecks map[string]time.Time
+
+// notifyIncoming notifies the acc account about the replies and mentions it received from other instances since
+// the last check of its inbox. The first check only records the date of the newest activity, so the accounts
+// don't get notified about everything they ever received.
+func (r *repository) notifyIncoming(ctx context.Context, acc *Account) error {
+	f := &Filters{Type: ActivityTypesFilter(pub.CreateType)}
+	cursor, err := r.LoadActorInbox(ctx, r.loadAPPerson(*acc), f)
+	if err != nil {
+		return err
+	}
+	checks := make(incomingChecks)
+	if err := r.store.Load(incomingCollection, &checks); err != nil {
+		return err
+	}
+	id := accountRoleID(*acc)
+	since, checked := checks[id]
+	newest := since
+	for _, it := range cursor.items.Items() {
+		if it.SubmittedAt.After(newest) {
+			newest = it.SubmittedAt
+		}
+		if !checked || !it.SubmittedAt.After(since) || !it.SubmittedBy.IsValid() || it.SubmittedBy.IsLocal() {
+			continue
+		}
+		r.notifyIncomingItem(ctx, acc, it)
+	}
+	if checked && !newest.After(since) {
+		return nil
+	}
+	return r.store.Update(incomingCollection, &checks, func() error {
+		if last, ok := checks[id]; !ok || newest.After(last) {
+			checks[id] = newest
+		}
+		return nil
+	})
+}
+
+// notifyIncomingItem notifies the acc account about the it item received from another instance,
+// if it's a reply to one of the account's items, or if it mentions the account
+func (r *repository) notifyIncomingItem(ctx context.Context, acc *Account, it Item) {
+	if it.Parent.IsValid() {
+		p, err := r.LoadItem(ctx, objects.IRI(r.fedbox.Service()).AddPath(it.Parent.Hash.String()))
+		if err == nil && p.SubmittedBy.IsValid() && accountsEqual(*p.SubmittedBy, *acc) {
+			r.Notify(acc, itemNotification(NotificationReply, it))
+			return
+		}
+	}
+	if itemMentions(it, *acc) {
+		r.Notify(acc, itemNotification(NotificationMention, it))
+	}
+}
+
+// incomingNotifications holds the accounts for which we are checking the inbox for replies and mentions
+var incomingNotifications sync.Map
+
+// HandleIncomingNotifications checks in the background the inbox of the acc account for the replies and mentions
+// received from other instances. Like for the Move activities, the requests are signed as the account by their
+// own client.
+func (r *repository) HandleIncomingNotifications(acc Account) {
+	if !acc.IsLogged() || !acc.HasMetadata() {
+		return
+	}
+	id := accountRoleID(acc)
+	if _, running := incomingNotifications.LoadOrStore(id, true); running {
+		return
+	}
+	md := *acc.Metadata
+	acc.Metadata = &md
+	go func() {
+		defer incomingNotifications.Delete(id)
+		ctx, cancel := context.WithTimeout(context.Background(), incomingTimeout)
+		defer cancel()
+		if err := r.forAccount(&acc).notifyIncoming(ctx, &acc); err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to notify about the received replies and mentions")
+		}
+	}()
+}
+
+// LoadNotifications loads the notifications of the a account, newest first
+func (r *repository) LoadNotifications(a Account) ([]Notification, error) {
+	all := make(accountNotifications)
+	if err := r.store.Load(notificationsCollection, &all); err != nil {
+		return nil, err
+	}
+	return all[accountRoleID(a)], nil
+}
+
+// notificationCounts are the numbers of unread notifications of the accounts, keyed by the account's ID.
+// They are shown on every page, so we don't load the notifications for each request. The count of an account
+// is removed whenever its notifications change, and it's loaded again when needed.
+type notificationCounts struct {
+	sync.RWMutex
+	m map[string]int
+	// version changes with every removed count, so we don't save a count loaded before the notifications changed
+	version uint64
+}
+
+var unreadCounts = &notificationCounts{m: make(map[string]int)}
+
+func (c *notificationCounts) get(id string) (int, bool, uint64) {
+	c.RLock()
+	defer c.RUnlock()
+	n, ok := c.m[id]
+	return n, ok, c.version
+}
+
+func (c *notificationCounts) set(id string, n int, version uint64) {
+	c.Lock()
+	if c.version == version {
+		c.m[id] = n
+	}
+	c.Unlock()
+}
+
+func (c *notificationCounts) remove(id string) {
+	c.Lock()
+	delete(c.m, id)
+	c.version++
+	c.Unlock()
+}
+
+// UnreadNotificationsCount returns the number of notifications the a account didn't read yet
+func (r *repository) UnreadNotificationsCount(a Account) (int, error) {
+	id := accountRoleID(a)
+	n, ok, version := unreadCounts.get(id)
+	if ok {
+		return n, nil
+	}
+	list, err := r.LoadNotifications(a)
+	if err != nil {
+		return 0, err
+	}
+	n = unreadNotifications(list)
+	unreadCounts.set(id, n, version)
+	return n, nil
+}
+
+// MarkNotificationsRead marks the notifications with the ids of the a account as read, or all of them when
+// no ids are passed
+func (r *repository) MarkNotificationsRead(a Account, ids ...string) error {
+	all := make(accountNotifications)
+	id := accountRoleID(a)
+	err := r.store.Update(notificationsCollection, &all, func() error {
+		markNotificationsRead(all[id], ids...)
+		return nil
+	})
+	unreadCounts.remove(id)
+	return err
+}
+
+// RemoveNotifications removes the notifications of the a account
+func (r *repository) RemoveNotifications(a Account) error {
+	all := make(accountNotifications)
+	id := accountRoleID(a)
+	defer unreadCounts.remove(id)
+	return r.store.Update(notificationsCollection, &all, func() error {
+		delete(all, id)
+		return nil
+	})
+}
+
+// HandleNotifications serves /notifications request
+func (h *handler) HandleNotifications(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	list, err := h.storage.LoadNotifications(*acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &notificationsModel{Title: "Notifications", User: acc, Notifications: list, Unread: unreadNotifications(list)}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleReadNotifications serves /notifications POST request
+// It marks as read the notification with the id from the form, or all of them when it's missing.
+func (h *handler) HandleReadNotifications(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ids := make([]string, 0)
+	if id := r.PostFormValue("id"); len(id) > 0 {
+		ids = append(ids, id)
+	}
+	if err := h.storage.MarkNotificationsRead(*acc, ids...); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to mark notifications as read")
+		h.v.addFlashMessage(Error, w, r, "Unable to mark notifications as read")
+	}
+	h.v.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
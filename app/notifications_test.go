@@ -0,0 +1,136 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAddNotification(t *testing.T) {
+	list := make([]Notification, 0)
+	for i := 0; i < MaxNotifications+5; i++ {
+		list = addNotification(list, Notification{ID: fmt.Sprintf("%d", i)})
+	}
+	if len(list) != MaxNotifications {
+		t.Fatalf("addNotification() kept %d notifications, want %d", len(list), MaxNotifications)
+	}
+	if want := fmt.Sprintf("%d", MaxNotifications+4); list[0].ID != want {
+		t.Errorf("addNotification() first notification = %s, want the newest %s", list[0].ID, want)
+	}
+	if want := "5"; list[len(list)-1].ID != want {
+		t.Errorf("addNotification() last notification = %s, want %s", list[len(list)-1].ID, want)
+	}
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	list := []Notification{{ID: "a"}, {ID: "b"}, {ID: "c", Read: true}}
+	if got := unreadNotifications(list); got != 2 {
+		t.Fatalf("unreadNotifications() = %d, want 2", got)
+	}
+	markNotificationsRead(list, "b", "unknown")
+	if list[0].Read || !list[1].Read {
+		t.Errorf("markNotificationsRead(b) = %+v, want only b marked", list)
+	}
+	markNotificationsRead(list)
+	if got := unreadNotifications(list); got != 0 {
+		t.Errorf("markNotificationsRead() left %d unread notifications", got)
+	}
+}
+
+func TestNotificationTitle(t *testing.T) {
+	tests := []struct {
+		it   Item
+		want string
+	}{
+		{it: Item{Title: "Ask littr: what are you reading?", Data: "<p>body</p>"}, want: "Ask littr: what are you reading?"},
+		{it: Item{Data: "<p>I <strong>agree</strong> with\n @jane &amp; you</p>"}, want: "I agree with @jane & you"},
+		{it: Item{Data: fmt.Sprintf("<p>%090d</p>", 0)}, want: fmt.Sprintf("%079d…", 0)},
+	}
+	for _, tt := range tests {
+		if got := notificationTitle(tt.it); got != tt.want {
+			t.Errorf("notificationTitle() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestNotification_Message(t *testing.T) {
+	tests := map[string]Notification{
+		"replied to your post":          {Type: NotificationReply},
+		"accepted your follow request":  {Type: NotificationFollowAccepted},
+		"A moderator locked your post":  {Type: NotificationModeration, Action: "locked"},
+		"A moderator deleted your post": {Type: NotificationModeration, Action: "deleted"},
+	}
+	for want, n := range tests {
+		if got := n.Message(); got != want {
+			t.Errorf("Message() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRepository_UnreadNotificationsCount(t *testing.T) {
+	r, cleanup := testRepository(t)
+	defer cleanup()
+
+	jane := Account{Handle: "jane", Metadata: &AccountMetadata{ID: "https://example.com/actors/jane"}}
+	all := accountNotifications{
+		accountRoleID(jane): {{ID: "a"}, {ID: "b"}, {ID: "c", Read: true}},
+	}
+	if err := r.store.Save(notificationsCollection, all); err != nil {
+		t.Fatalf("unable to save notifications: %s", err)
+	}
+	if n, err := r.UnreadNotificationsCount(jane); err != nil || n != 2 {
+		t.Fatalf("UnreadNotificationsCount() = %d, %v, want 2", n, err)
+	}
+	if err := r.MarkNotificationsRead(jane, "a"); err != nil {
+		t.Fatalf("MarkNotificationsRead() error: %s", err)
+	}
+	if n, err := r.UnreadNotificationsCount(jane); err != nil || n != 1 {
+		t.Errorf("UnreadNotificationsCount() after marking one as read = %d, %v, want 1", n, err)
+	}
+	if err := r.RemoveNotifications(jane); err != nil {
+		t.Fatalf("RemoveNotifications() error: %s", err)
+	}
+	if n, err := r.UnreadNotificationsCount(jane); err != nil || n != 0 {
+		t.Errorf("UnreadNotificationsCount() after removing them = %d, %v, want 0", n, err)
+	}
+}
+
+func TestNotificationCounts_set(t *testing.T) {
+	c := &notificationCounts{m: make(map[string]int)}
+	_, _, version := c.get("jane")
+	c.remove("jane")
+	c.set("jane", 3, version)
+	if _, ok, _ := c.get("jane"); ok {
+		t.Errorf("set() saved a count loaded before the notifications changed")
+	}
+	_, _, version = c.get("jane")
+	c.set("jane", 3, version)
+	if n, ok, _ := c.get("jane"); !ok || n != 3 {
+		t.Errorf("get() = %d, %t, want 3", n, ok)
+	}
+}
+
+func TestItemMentions(t *testing.T) {
+	jane := Account{Hash: HashFromString("6c7a5e8e-3b43-4c5e-9b8a-3d1c2f0e5a71"), Handle: "jane"}
+	john := Account{Hash: HashFromString("0b4e3c2a-1d5f-4e6a-8b7c-9d0e1f2a3b4c"), Handle: "john"}
+	mention := func(a Account) Tag {
+		return Tag{Type: TagMention, Name: "@" + a.Handle, URL: "https://example.com/actors/" + a.Hash.String()}
+	}
+	tests := []struct {
+		name string
+		it   Item
+		want bool
+	}{
+		{name: "no metadata", it: Item{}, want: false},
+		{name: "mentioned", it: Item{Metadata: &ItemMetadata{Mentions: TagCollection{mention(john), mention(jane)}}}, want: true},
+		{name: "other mention", it: Item{Metadata: &ItemMetadata{Mentions: TagCollection{mention(john)}}}, want: false},
+		{name: "addressed", it: Item{Metadata: &ItemMetadata{CC: AccountCollection{jane}}}, want: true},
+		{name: "not addressed", it: Item{Metadata: &ItemMetadata{To: AccountCollection{john}}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := itemMentions(tt.it, jane); got != tt.want {
+				t.Errorf("itemMentions() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,630 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/client"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/config"
+	"github.com/mariusor/go-littr/internal/log"
+	"golang.org/x/oauth2"
+)
+
+const identitiesCollection = "identities"
+
+const (
+	// SessionAuthProviderKey is the session key for the login provider the user was sent to
+	SessionAuthProviderKey = "__auth_provider"
+	// SessionAuthStateKey is the session key for the state of the login provider's authorization request
+	SessionAuthStateKey = "__auth_state"
+	// SessionAuthNonceKey is the session key for the OpenID Connect nonce of the authorization request
+	SessionAuthNonceKey = "__auth_nonce"
+)
+
+const (
+	// providerFetchTimeout is the maximum time we allow for the requests to the login providers
+	providerFetchTimeout = 10 * time.Second
+	// providerFetchMaxSize is the maximum number of bytes we read from the responses of the login providers
+	providerFetchMaxSize = 256 * 1024
+
+	maxHandleLength = 32
+)
+
+var defaultOIDCScopes = []string{"openid", "profile", "email"}
+
+// accountIdentities are the IRIs of the accounts linked to the users of the login providers, keyed by
+// the provider name and the user's identifier with it
+type accountIdentities map[string]string
+
+func identityKey(provider, subject string) string {
+	return fmt.Sprintf("%s:%s", provider, subject)
+}
+
+// oidcDiscovery is the OpenID Connect provider metadata we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+var discovered = struct {
+	sync.RWMutex
+	m map[string]oidcDiscovery
+}{m: make(map[string]oidcDiscovery)}
+
+var providerClient = &http.Client{
+	Timeout: providerFetchTimeout,
+}
+
+// providerClaims are the information about the user we get from the login provider
+type providerClaims struct {
+	Subject       string
+	Handle        string
+	Name          string
+	Email         string
+	EmailVerified bool
+}
+
+// fetchProviderJSON loads the JSON document at u. When the token is not empty, it's sent as bearer authorization.
+func fetchProviderJSON(ctx context.Context, u, token string) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, providerFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", client.UserAgent)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Newf("unable to load %s: %s", u, resp.Status)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, providerFetchMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONObject(raw)
+}
+
+func decodeJSONObject(raw []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// NOTE(marius): some providers use numeric user identifiers, which would lose precision as float64 values
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, errors.Annotatef(err, "invalid JSON object")
+	}
+	return m, nil
+}
+
+// discoverProvider loads the OpenID Connect metadata of the issuer
+func discoverProvider(ctx context.Context, issuer string) (oidcDiscovery, error) {
+	discovered.RLock()
+	d, ok := discovered.m[issuer]
+	discovered.RUnlock()
+	if ok {
+		return d, nil
+	}
+
+	m, err := fetchProviderJSON(ctx, fmt.Sprintf("%s/.well-known/openid-configuration", issuer), "")
+	if err != nil {
+		return d, errors.Annotatef(err, "unable to load OpenID Connect configuration of %s", issuer)
+	}
+	d = oidcDiscovery{
+		Issuer:                claimString(m, "issuer"),
+		AuthorizationEndpoint: claimString(m, "authorization_endpoint"),
+		TokenEndpoint:         claimString(m, "token_endpoint"),
+		UserInfoEndpoint:      claimString(m, "userinfo_endpoint"),
+	}
+	if strings.TrimRight(d.Issuer, "/") != issuer {
+		return d, errors.NotValidf("the OpenID Connect configuration is for issuer %s instead of %s", d.Issuer, issuer)
+	}
+	if len(d.AuthorizationEndpoint) == 0 || len(d.TokenEndpoint) == 0 {
+		return d, errors.NotValidf("the OpenID Connect configuration of %s is missing its endpoints", issuer)
+	}
+
+	discovered.Lock()
+	discovered.m[issuer] = d
+	discovered.Unlock()
+	return d, nil
+}
+
+// loadProviderEndpoints fills in the endpoints of the OpenID Connect providers from their discovery document
+func loadProviderEndpoints(ctx context.Context, p config.AuthProvider) (config.AuthProvider, error) {
+	if !p.IsOIDC() {
+		return p, nil
+	}
+	d, err := discoverProvider(ctx, p.Issuer)
+	if err != nil {
+		return p, err
+	}
+	if len(p.AuthURL) == 0 {
+		p.AuthURL = d.AuthorizationEndpoint
+	}
+	if len(p.TokenURL) == 0 {
+		p.TokenURL = d.TokenEndpoint
+	}
+	if len(p.UserInfoURL) == 0 {
+		p.UserInfoURL = d.UserInfoEndpoint
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = defaultOIDCScopes
+	}
+	return p, nil
+}
+
+// providerOauth2Config returns the OAuth2 configuration for the p login provider
+func providerOauth2Config(p config.AuthProvider, localBaseURL string) oauth2.Config {
+	return oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.AuthURL,
+			TokenURL: p.TokenURL,
+		},
+		RedirectURL: fmt.Sprintf("%s/auth/%s/callback", localBaseURL, p.Name),
+		Scopes:      p.Scopes,
+	}
+}
+
+func claimString(m map[string]interface{}, k string) string {
+	switch v := m[k].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func claimBool(m map[string]interface{}, k string) bool {
+	switch v := m[k].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// claimsFromMap loads the claims of the user from its OpenID Connect ID token or from the user info
+// response of a generic OAuth2 provider
+func claimsFromMap(m map[string]interface{}) providerClaims {
+	c := providerClaims{
+		Subject:       claimString(m, "sub"),
+		Name:          claimString(m, "name"),
+		Email:         claimString(m, "email"),
+		EmailVerified: claimBool(m, "email_verified"),
+	}
+	if len(c.Subject) == 0 {
+		c.Subject = claimString(m, "id")
+	}
+	for _, k := range []string{"preferred_username", "login", "nickname", "username"} {
+		if c.Handle = claimString(m, k); len(c.Handle) > 0 {
+			break
+		}
+	}
+	return c
+}
+
+// merge fills in the claims that are missing from c with the ones from o
+func (c providerClaims) merge(o providerClaims) providerClaims {
+	if len(c.Subject) == 0 {
+		c.Subject = o.Subject
+	}
+	if len(c.Handle) == 0 {
+		c.Handle = o.Handle
+	}
+	if len(c.Name) == 0 {
+		c.Name = o.Name
+	}
+	if len(c.Email) == 0 {
+		c.Email = o.Email
+		c.EmailVerified = o.EmailVerified
+	}
+	return c
+}
+
+var invalidHandleChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// handle returns the handle for the local account created for the user
+func (c providerClaims) handle() string {
+	h := c.Handle
+	if len(h) == 0 && len(c.Email) > 0 {
+		h = strings.SplitN(c.Email, "@", 2)[0]
+	}
+	if len(h) == 0 {
+		h = c.Name
+	}
+	h = invalidHandleChars.ReplaceAllString(strings.ToLower(strings.TrimSpace(h)), "-")
+	h = strings.Trim(h, "-.")
+	if len(h) > maxHandleLength {
+		h = strings.Trim(h[:maxHandleLength], "-.")
+	}
+	if len(h) == 0 || h == Anonymous || h == selfName {
+		h = "user"
+	}
+	return h
+}
+
+// parseIDToken returns the claims of the raw OpenID Connect ID token, after it validates its issuer, audience,
+// expiry time and nonce.
+// NOTE(marius): we don't validate the signature of the token, as we receive it directly from the provider's
+// token endpoint, over TLS. See OpenID Connect Core 1.0, section 3.1.3.7
+func parseIDToken(raw, issuer, clientID, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.NotValidf("invalid ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid ID token payload")
+	}
+	m, err := decodeJSONObject(payload)
+	if err != nil {
+		return nil, err
+	}
+	if iss := strings.TrimRight(claimString(m, "iss"), "/"); iss != issuer {
+		return nil, errors.NotValidf("invalid ID token issuer %s", iss)
+	}
+	validAud := false
+	switch aud := m["aud"].(type) {
+	case string:
+		validAud = aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientID {
+				validAud = true
+			}
+		}
+	}
+	if !validAud {
+		return nil, errors.NotValidf("the ID token was not issued for %s", clientID)
+	}
+	exp, ok := m["exp"].(json.Number)
+	if !ok {
+		return nil, errors.NotValidf("the ID token has no expiry time")
+	}
+	if sec, err := exp.Int64(); err != nil || now.After(time.Unix(sec, 0)) {
+		return nil, errors.NotValidf("the ID token is expired")
+	}
+	if claimString(m, "nonce") != nonce {
+		return nil, errors.NotValidf("invalid ID token nonce")
+	}
+	if len(claimString(m, "sub")) == 0 {
+		return nil, errors.NotValidf("the ID token has no subject")
+	}
+	return m, nil
+}
+
+// loadProviderClaims loads the claims of the user that logged in with the p provider, from the ID token
+// and the user info endpoint
+func loadProviderClaims(ctx context.Context, p config.AuthProvider, tok *oauth2.Token, nonce string) (providerClaims, error) {
+	var c providerClaims
+	if p.IsOIDC() {
+		raw, _ := tok.Extra("id_token").(string)
+		if len(raw) == 0 {
+			return c, errors.NotValidf("%s didn't return an ID token", p.Label)
+		}
+		m, err := parseIDToken(raw, p.Issuer, p.ClientID, nonce, time.Now())
+		if err != nil {
+			return c, err
+		}
+		c = claimsFromMap(m)
+	}
+	if len(p.UserInfoURL) > 0 {
+		m, err := fetchProviderJSON(ctx, p.UserInfoURL, tok.AccessToken)
+		if err != nil {
+			return c, errors.Annotatef(err, "unable to load user information from %s", p.Label)
+		}
+		info := claimsFromMap(m)
+		if p.IsOIDC() && info.Subject != c.Subject {
+			// NOTE(marius): the user info response must be for the same user as the ID token
+			return c, errors.NotValidf("the user information from %s doesn't match the ID token", p.Label)
+		}
+		c = c.merge(info)
+	}
+	if len(c.Subject) == 0 {
+		return c, errors.NotValidf("%s didn't return an user identifier", p.Label)
+	}
+	return c, nil
+}
+
+func newAuthState() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Annotatef(err, "unable to generate authorization state")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// LoadIdentity loads the IRI of the account linked to the subject user of the provider
+func (r *repository) LoadIdentity(provider, subject string) (string, error) {
+	all := make(accountIdentities)
+	if err := r.store.Load(identitiesCollection, &all); err != nil {
+		return "", err
+	}
+	id, ok := all[identityKey(provider, subject)]
+	if !ok {
+		return "", errors.NotFoundf("no account for %s user %s", provider, subject)
+	}
+	return id, nil
+}
+
+// LinkIdentity links the subject user of the provider to the a account
+func (r *repository) LinkIdentity(provider, subject string, a Account) error {
+	all := make(accountIdentities)
+	return r.store.Update(identitiesCollection, &all, func() error {
+		k := identityKey(provider, subject)
+		if id, ok := all[k]; ok && id != accountRoleID(a) {
+			return errors.BadRequestf("the %s user is already linked to another account", provider)
+		}
+		all[k] = accountRoleID(a)
+		return nil
+	})
+}
+
+// LoadAccountIdentities loads the names of the providers the a account is linked to
+func (r *repository) LoadAccountIdentities(a Account) ([]string, error) {
+	all := make(accountIdentities)
+	if err := r.store.Load(identitiesCollection, &all); err != nil {
+		return nil, err
+	}
+	providers := make([]string, 0)
+	for k, id := range all {
+		if id == accountRoleID(a) {
+			providers = append(providers, strings.SplitN(k, ":", 2)[0])
+		}
+	}
+	return providers, nil
+}
+
+// accountForVerifiedEmail loads the local account with the email address, if the account confirmed it owns the address
+func (r *repository) accountForVerifiedEmail(ctx context.Context, email string) (*Account, error) {
+	emails := make(accountEmails)
+	if err := r.store.Load(accountEmailsCollection, &emails); err != nil {
+		return nil, err
+	}
+	k, ae, err := emails.accountFor(email)
+	if err != nil {
+		return nil, err
+	}
+	if !ae.Verified {
+		return nil, errors.NotFoundf("no account with verified email %s", email)
+	}
+	return r.LoadAccount(ctx, pub.IRI(k))
+}
+
+// HandleAuth serves /auth/{provider} request
+func (h *handler) HandleAuth(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.conf.AuthProvider(chi.URLParam(r, "provider"))
+	if !ok {
+		h.v.HandleErrors(w, r, errors.NotFoundf("login provider %s", chi.URLParam(r, "provider")))
+		return
+	}
+	p, err := loadProviderEndpoints(r.Context(), p)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "provider": p.Name})("unable to load login provider")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to log in with %s", p.Label))
+		h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	state, err := newAuthState()
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	nonce, err := newAuthState()
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	s, err := h.v.s.get(w, r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	s.Values[SessionAuthProviderKey] = p.Name
+	s.Values[SessionAuthStateKey] = state
+	s.Values[SessionAuthNonceKey] = nonce
+
+	conf := providerOauth2Config(p, h.conf.BaseURL)
+	opts := make([]oauth2.AuthCodeOption, 0)
+	if p.IsOIDC() {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	h.v.Redirect(w, r, conf.AuthCodeURL(state, opts...), http.StatusFound)
+}
+
+// providerAccount loads the local account of the user that logged in with the p provider.
+// If the user is not linked to one, we link it to the logged account, to the account with the same
+// verified email address, or to a new account, in this order, as the provider configuration allows.
+// The email addresses must be verified by both the provider and the local account.
+func (h *handler) providerAccount(ctx context.Context, r *http.Request, p config.AuthProvider, c providerClaims) (*Account, error) {
+	ltx := log.Ctx{"provider": p.Name, "subject": c.Subject}
+	if id, err := h.storage.LoadIdentity(p.Name, c.Subject); err == nil {
+		return h.storage.LoadAccount(ctx, pub.IRI(id))
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var acc *Account
+	if logged := loggedAccount(r); logged.IsLogged() {
+		acc = logged
+	}
+	if acc == nil && p.LinkByEmail && len(c.Email) > 0 && c.EmailVerified {
+		if a, err := h.storage.accountForVerifiedEmail(ctx, c.Email); err == nil {
+			acc = a
+		}
+	}
+	if acc == nil {
+		if !p.CreateAccounts || !h.conf.UserCreatingEnabled {
+			return nil, errors.Forbiddenf("there is no account linked to your %s user", p.Label)
+		}
+		// NOTE(marius): the callback is a GET request, so the sign-ups are limited here instead of in the router
+		if ok, wait := h.allowAction(r, rateRegister); !ok {
+			return nil, errors.Forbiddenf("too many accounts were created, try again in %s", waitFmt(wait))
+		}
+		a, err := h.createProviderAccount(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		h.infoFn(ltx, log.Ctx{"handle": a.Handle})("created account for login provider user")
+		acc = &a
+	}
+	if err := h.storage.LinkIdentity(p.Name, c.Subject, *acc); err != nil {
+		return nil, err
+	}
+	h.infoFn(ltx, log.Ctx{"handle": acc.Handle})("linked login provider user to account")
+	return acc, nil
+}
+
+// createProviderAccount creates a local account for the user of a login provider.
+// The account has no password, the user can set one with the password reset form if it has an email address.
+func (h *handler) createProviderAccount(ctx context.Context, c providerClaims) (Account, error) {
+	base := c.handle()
+	handle := base
+	for i := 2; ; i++ {
+		f := &Filters{Name: CompStrs{EqualsString(handle)}}
+		ex, err := h.storage.account(ctx, f)
+		if err != nil && !errors.IsNotFound(err) {
+			return Account{}, err
+		}
+		if !ex.IsValid() {
+			break
+		}
+		if i > 100 {
+			return Account{}, errors.BadRequestf("unable to find a free handle for %s", base)
+		}
+		handle = fmt.Sprintf("%s%d", base, i)
+	}
+
+	app := h.storage.app
+	a := Account{Handle: handle, CreatedBy: app, Metadata: &AccountMetadata{}}
+	if len(c.Name) > 0 && c.Name != handle {
+		a.Metadata.Name = c.Name
+	}
+	a, err := h.storage.WithAccount(app).SaveAccount(ctx, a)
+	if err != nil {
+		return a, err
+	}
+	if !a.IsValid() || !a.HasMetadata() || len(a.Metadata.ID) == 0 {
+		return a, errors.Newf("unable to save actor")
+	}
+	if len(c.Email) > 0 && c.EmailVerified {
+		if err := h.storage.SaveAccountEmail(ctx, a, c.Email, c.EmailVerified); err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to save account email")
+		}
+	}
+	return a, nil
+}
+
+// accountToken requests from FedBOX an OAuth2 token for the a account
+func (h *handler) accountToken(r *http.Request, a Account) (*oauth2.Token, error) {
+	code, err := h.accountAuthorizationCode(r, a)
+	if err != nil {
+		return nil, err
+	}
+	config := GetOauth2Config("fedbox", h.conf.BaseURL)
+	return config.Exchange(r.Context(), code)
+}
+
+// handleProviderCallback logs in the user that authorized us with the p login provider
+func (h *handler) handleProviderCallback(w http.ResponseWriter, r *http.Request, p config.AuthProvider) {
+	ctx := r.Context()
+	ltx := log.Ctx{"provider": p.Name}
+	loginErr := func(msg string, err error) {
+		if err != nil {
+			ltx["err"] = err.Error()
+		}
+		h.errFn(ltx)("login with provider failed")
+		h.v.addFlashMessage(Error, w, r, msg)
+		h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+
+	s, err := h.v.s.get(w, r)
+	if err != nil {
+		loginErr("Login failed: unable to load session", err)
+		return
+	}
+	state, _ := s.Values[SessionAuthStateKey].(string)
+	nonce, _ := s.Values[SessionAuthNonceKey].(string)
+	provider, _ := s.Values[SessionAuthProviderKey].(string)
+	delete(s.Values, SessionAuthStateKey)
+	delete(s.Values, SessionAuthNonceKey)
+	delete(s.Values, SessionAuthProviderKey)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); len(e) > 0 {
+		loginErr(fmt.Sprintf("Login with %s failed: %s", p.Label, q.Get("error_description")), errors.Newf("%s", e))
+		return
+	}
+	if len(state) == 0 || provider != p.Name || q.Get("state") != state {
+		loginErr(fmt.Sprintf("Login with %s failed: invalid state, please try again", p.Label), nil)
+		return
+	}
+	if p, err = loadProviderEndpoints(ctx, p); err != nil {
+		loginErr(fmt.Sprintf("Login with %s failed", p.Label), err)
+		return
+	}
+	conf := providerOauth2Config(p, h.conf.BaseURL)
+	tok, err := conf.Exchange(ctx, q.Get("code"))
+	if err != nil {
+		loginErr(fmt.Sprintf("Login with %s failed", p.Label), err)
+		return
+	}
+	c, err := loadProviderClaims(ctx, p, tok, nonce)
+	if err != nil {
+		loginErr(fmt.Sprintf("Login with %s failed", p.Label), err)
+		return
+	}
+	ltx["subject"] = c.Subject
+
+	acc, err := h.providerAccount(ctx, r, p, c)
+	if err != nil {
+		loginErr(fmt.Sprintf("Login with %s failed: %s", p.Label, err), err)
+		return
+	}
+	if logged := loggedAccount(r); logged.IsLogged() && logged.Hash == acc.Hash {
+		// NOTE(marius): the user linked the provider to its current account
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Your %s login was linked to your account", p.Label))
+		h.v.Redirect(w, r, fmt.Sprintf("%s/settings", PermaLink(acc)), http.StatusSeeOther)
+		return
+	}
+	fedTok, err := h.accountToken(r, *acc)
+	if err != nil {
+		loginErr("Login failed: unable to authorize account", err)
+		return
+	}
+	acc.Metadata.OAuth.Provider = "fedbox"
+	acc.Metadata.OAuth.Token = fedTok
+	h.infoFn(ltx, log.Ctx{"handle": acc.Handle})("logged in with provider")
+	h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Login successful with %s", p.Label))
+	if err = h.loginAccount(w, r, *acc); err != nil {
+		loginErr("Login failed: unable to save session", err)
+	}
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/config"
+)
+
+func testIDToken(claims map[string]interface{}) string {
+	enc := func(v interface{}) string {
+		dat, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(dat)
+	}
+	return fmt.Sprintf("%s.%s.signature", enc(map[string]string{"alg": "RS256", "typ": "JWT"}), enc(claims))
+}
+
+// mockIssuer is an OpenID Connect provider that issues tokens for the jane user
+func mockIssuer(nonce string) *httptest.Server {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token": testIDToken(map[string]interface{}{
+				"iss":   srv.URL,
+				"aud":   "littr",
+				"sub":   "1234567890123456789",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"nonce": nonce,
+			}),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"sub":                "1234567890123456789",
+			"preferred_username": "Jane.Doe",
+			"name":               "Jane Doe",
+			"email":              "jane@example.com",
+			"email_verified":     true,
+		})
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestProviderLogin(t *testing.T) {
+	nonce := "test-nonce"
+	srv := mockIssuer(nonce)
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := config.AuthProvider{Name: "company", Label: "Company", Issuer: srv.URL, ClientID: "littr", ClientSecret: "secret"}
+	p, err := loadProviderEndpoints(ctx, p)
+	if err != nil {
+		t.Fatalf("loadProviderEndpoints() error: %s", err)
+	}
+	if p.TokenURL != srv.URL+"/token" || p.UserInfoURL != srv.URL+"/userinfo" {
+		t.Fatalf("loadProviderEndpoints() = %+v, invalid endpoints", p)
+	}
+	conf := providerOauth2Config(p, "https://littr.example.com")
+	if conf.RedirectURL != "https://littr.example.com/auth/company/callback" {
+		t.Errorf("RedirectURL = %s", conf.RedirectURL)
+	}
+
+	if _, err := conf.Exchange(ctx, "invalid-code"); err == nil {
+		t.Errorf("Exchange() expected error for an invalid code")
+	}
+	tok, err := conf.Exchange(ctx, "valid-code")
+	if err != nil {
+		t.Fatalf("Exchange() error: %s", err)
+	}
+	c, err := loadProviderClaims(ctx, p, tok, nonce)
+	if err != nil {
+		t.Fatalf("loadProviderClaims() error: %s", err)
+	}
+	want := providerClaims{Subject: "1234567890123456789", Handle: "Jane.Doe", Name: "Jane Doe", Email: "jane@example.com", EmailVerified: true}
+	if c != want {
+		t.Errorf("loadProviderClaims() = %+v, want %+v", c, want)
+	}
+	if c.handle() != "jane.doe" {
+		t.Errorf("handle() = %s, want jane.doe", c.handle())
+	}
+	if _, err := loadProviderClaims(ctx, p, tok, "other-nonce"); err == nil {
+		t.Errorf("loadProviderClaims() expected error for an invalid nonce")
+	}
+}
+
+func TestParseIDToken(t *testing.T) {
+	now := time.Now()
+	valid := map[string]interface{}{
+		"iss":   "https://sso.example.com",
+		"aud":   []string{"other", "littr"},
+		"sub":   "jane",
+		"exp":   now.Add(time.Minute).Unix(),
+		"nonce": "nonce",
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		m := make(map[string]interface{})
+		for kk, vv := range valid {
+			m[kk] = vv
+		}
+		m[k] = v
+		return m
+	}
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{name: "valid", claims: valid},
+		{name: "issuer", claims: with("iss", "https://evil.example.com"), wantErr: true},
+		{name: "audience", claims: with("aud", "other"), wantErr: true},
+		{name: "expired", claims: with("exp", now.Add(-time.Minute).Unix()), wantErr: true},
+		{name: "nonce", claims: with("nonce", "other"), wantErr: true},
+		{name: "subject", claims: with("sub", ""), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseIDToken(testIDToken(tt.claims), "https://sso.example.com", "littr", "nonce", now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseIDToken() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := parseIDToken("invalid", "https://sso.example.com", "littr", "nonce", now); err == nil {
+		t.Errorf("parseIDToken() expected error for an invalid token")
+	}
+}
+
+func TestProviderClaims_handle(t *testing.T) {
+	tests := []struct {
+		claims providerClaims
+		want   string
+	}{
+		{claims: providerClaims{Handle: "jane"}, want: "jane"},
+		{claims: providerClaims{Email: "Jane.Doe@example.com"}, want: "jane.doe"},
+		{claims: providerClaims{Name: "Jane Doe"}, want: "jane-doe"},
+		{claims: providerClaims{Handle: "anonymous"}, want: "user"},
+		{claims: providerClaims{Handle: "--"}, want: "user"},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.handle(); got != tt.want {
+			t.Errorf("handle() for %+v = %q, want %q", tt.claims, got, tt.want)
+		}
+	}
+}
+
+func TestRepository_accountForVerifiedEmail(t *testing.T) {
+	r, cleanup := testRepository(t)
+	defer cleanup()
+
+	emails := accountEmails{
+		"https://example.com/actors/jane": {Address: "jane@example.com"},
+	}
+	if err := r.store.Save(accountEmailsCollection, emails); err != nil {
+		t.Fatalf("unable to save emails: %s", err)
+	}
+	if _, err := r.accountForVerifiedEmail(context.Background(), "jane@example.com"); !errors.IsNotFound(err) {
+		t.Errorf("accountForVerifiedEmail() error = %v, want not found for an address that is not verified", err)
+	}
+	if _, err := r.accountForVerifiedEmail(context.Background(), "john@example.com"); !errors.IsNotFound(err) {
+		t.Errorf("accountForVerifiedEmail() error = %v, want not found for an unknown address", err)
+	}
+}
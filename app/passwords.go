@@ -0,0 +1,419 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"text/template"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/assets"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/mariusor/go-littr/internal/mail"
+)
+
+const (
+	passwordResetsCollection  = "password-resets"
+	accountEmailsCollection   = "emails"
+	revokedSessionsCollection = "revoked-sessions"
+)
+
+// PasswordResetTTL is the time a password reset link can be used for
+const PasswordResetTTL = time.Hour
+
+// passwordResetMailTemplate is the template for the body of the password reset emails
+const passwordResetMailTemplate = "templates/mail/password-reset.txt"
+
+// passwordReset is a pending request for resetting the password of an account.
+type passwordReset struct {
+	Account   string    `json:"account"`
+	Handle    string    `json:"handle"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (p passwordReset) valid(now time.Time) bool {
+	return len(p.Account) > 0 && now.Before(p.ExpiresAt)
+}
+
+// passwordResets are the pending password resets, keyed by the hash of their token.
+// NOTE(marius): we don't keep the tokens themselves, so the file can't be used to reset anyone's password.
+type passwordResets map[string]passwordReset
+
+// accountEmails are the email addresses of the local accounts, keyed by their ID.
+// They are used only for sending the password reset links, so we don't add them to the accounts' actors.
+type accountEmails map[string]accountEmail
+
+// revokedSessions keeps, for the accounts which changed their passwords, the time before which their sessions are
+// not valid anymore, keyed by the account's ID.
+type revokedSessions map[string]time.Time
+
+// newPasswordResetToken generates the random token of a password reset link
+func newPasswordResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Annotatef(err, "unable to generate password reset token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// passwordResetKey returns the key under which we save the password reset with the token
+func passwordResetKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SavePasswordReset creates a password reset for the a account, valid for the ttl duration, and returns its token.
+// The pending resets of the account are invalidated, so only the link sent last can be used.
+func (r *repository) SavePasswordReset(ctx context.Context, a Account, ttl time.Duration) (string, passwordReset, error) {
+	pr := passwordReset{}
+	if !a.IsValid() || !a.HasMetadata() || len(a.Metadata.ID) == 0 {
+		return "", pr, errors.NotValidf("invalid account %s", a.Handle)
+	}
+	token, err := newPasswordResetToken()
+	if err != nil {
+		return "", pr, err
+	}
+	now := time.Now().UTC()
+	pr = passwordReset{
+		Account:   a.Metadata.ID,
+		Handle:    a.Handle,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	resets := make(passwordResets)
+	err = r.store.Update(passwordResetsCollection, &resets, func() error {
+		for k, p := range resets {
+			if !p.valid(now) || p.Account == pr.Account {
+				delete(resets, k)
+			}
+		}
+		resets[passwordResetKey(token)] = pr
+		return nil
+	})
+	return token, pr, err
+}
+
+// LoadPasswordReset loads the password reset with the token, if it hasn't expired
+func (r *repository) LoadPasswordReset(ctx context.Context, token string) (passwordReset, error) {
+	resets := make(passwordResets)
+	if err := r.store.Load(passwordResetsCollection, &resets); err != nil {
+		return passwordReset{}, err
+	}
+	pr, ok := resets[passwordResetKey(token)]
+	if !ok || !pr.valid(time.Now()) {
+		return passwordReset{}, errors.NotFoundf("invalid or expired password reset")
+	}
+	return pr, nil
+}
+
+// DeletePasswordReset removes the password reset with the token, once it was used
+func (r *repository) DeletePasswordReset(ctx context.Context, token string) error {
+	resets := make(passwordResets)
+	return r.store.Update(passwordResetsCollection, &resets, func() error {
+		delete(resets, passwordResetKey(token))
+		return nil
+	})
+}
+
+// LoadAccountEmail loads the email address of the a account.
+// When the account didn't set one, we use the address of the invitation it was created from.
+func (r *repository) LoadAccountEmail(ctx context.Context, a Account) (string, error) {
+	emails := make(accountEmails)
+	if err := r.store.Load(accountEmailsCollection, &emails); err != nil {
+		return "", err
+	}
+	if email, ok := emails[accountRoleID(a)]; ok {
+		return email.Address, nil
+	}
+	all := make(Invitations, 0)
+	if err := r.store.Load(invitationsCollection, &all); err != nil {
+		return "", err
+	}
+	for _, inv := range all {
+		if inv.IsAccepted() && inv.AcceptedBy == a.Handle {
+			return inv.Email, nil
+		}
+	}
+	return "", nil
+}
+
+// SaveAccountEmail saves the email address of the a account, which needs to be unique among the local accounts.
+// An empty email removes it.
+func (r *repository) SaveAccountEmail(ctx context.Context, a Account, email string, verified bool) error {
+	emails := make(accountEmails)
+	return r.store.Update(accountEmailsCollection, &emails, func() error {
+		if len(email) > 0 {
+			used, err := r.emailInUse(emails, a, email)
+			if err != nil {
+				return err
+			}
+			if used {
+				return errors.BadRequestf("the email address is used by another account")
+			}
+		}
+		// NOTE(marius): we keep the empty addresses, so they take precedence over the one of the account's invitation
+		emails[accountRoleID(a)] = accountEmail{Address: email, Verified: verified && len(email) > 0}
+		return nil
+	})
+}
+
+// accountForPasswordReset loads the local account with the id handle or email address, and the address
+// to send the password reset link to
+func (r *repository) accountForPasswordReset(ctx context.Context, id string) (Account, string, error) {
+	handle := id
+	if strings.Contains(id, "@") {
+		handle = ""
+		emails := make(accountEmails)
+		if err := r.store.Load(accountEmailsCollection, &emails); err != nil {
+			return Account{}, "", err
+		}
+		if k, email, err := emails.accountFor(id); err == nil {
+			a, err := r.LoadAccount(ctx, pub.IRI(k))
+			if err != nil {
+				return Account{}, "", err
+			}
+			return *a, email.Address, nil
+		} else if !errors.IsNotFound(err) {
+			return Account{}, "", err
+		}
+		all := make(Invitations, 0)
+		if err := r.store.Load(invitationsCollection, &all); err != nil {
+			return Account{}, "", err
+		}
+		for _, inv := range all {
+			if inv.IsAccepted() && strings.EqualFold(inv.Email, id) {
+				handle = inv.AcceptedBy
+				break
+			}
+		}
+		if len(handle) == 0 {
+			return Account{}, "", errors.NotFoundf("no account for email %s", id)
+		}
+	}
+	a, err := r.account(ctx, &Filters{
+		Name: CompStrs{EqualsString(handle)},
+		Type: ActivityTypesFilter(ValidActorTypes...),
+	})
+	if err != nil {
+		return a, "", err
+	}
+	if !a.IsValid() || !a.IsLocal() {
+		return a, "", errors.NotFoundf("no local account %s", handle)
+	}
+	email, err := r.LoadAccountEmail(ctx, a)
+	if err != nil {
+		return a, "", err
+	}
+	if len(email) == 0 {
+		return a, "", errors.NotFoundf("account %s has no email address", a.Handle)
+	}
+	return a, email, nil
+}
+
+// RevokeSessions logs out the sessions of the a account which were started before the t time
+func (r *repository) RevokeSessions(a Account, t time.Time) error {
+	revoked := make(revokedSessions)
+	return r.store.Update(revokedSessionsCollection, &revoked, func() error {
+		// NOTE(marius): the login time of the sessions has a precision of one second
+		revoked[accountRoleID(a)] = time.Unix(t.Unix(), 0).UTC()
+		return nil
+	})
+}
+
+// sessionsRevokedAt returns the time before which the sessions of the a account are not valid
+func (r *repository) sessionsRevokedAt(a Account) time.Time {
+	v, err := r.store.LoadCached(revokedSessionsCollection, func() interface{} {
+		return &revokedSessions{}
+	})
+	if err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load revoked sessions")
+		return time.Time{}
+	}
+	return (*v.(*revokedSessions))[accountRoleID(a)]
+}
+
+// sessionRevoked returns true if the current session of the a account was started before its sessions were revoked
+func (h *handler) sessionRevoked(w http.ResponseWriter, r *http.Request, a Account) bool {
+	at := h.storage.sessionsRevokedAt(a)
+	if at.IsZero() {
+		return false
+	}
+	return h.v.sessionLoginTime(w, r).Before(at)
+}
+
+// passwordResetMail renders the email with the token password reset link for the a account
+func passwordResetMail(c appConfig, a Account, email, token string, pr passwordReset) (mail.Message, error) {
+	m := mail.Message{
+		To:      email,
+		Subject: fmt.Sprintf("Reset your %s password", c.Name),
+	}
+	raw, err := assets.Template(passwordResetMailTemplate)
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to load password reset template")
+	}
+	t, err := template.New("password-reset").Parse(string(raw))
+	if err != nil {
+		return m, errors.Annotatef(err, "unable to parse password reset template")
+	}
+	// @todo(marius): :link_generation:
+	data := struct {
+		Handle    string
+		Name      string
+		BaseURL   string
+		URL       string
+		ExpiresAt time.Time
+	}{
+		Handle:    a.Handle,
+		Name:      c.Name,
+		BaseURL:   c.BaseURL,
+		URL:       fmt.Sprintf("%s/reset-password/%s", c.BaseURL, token),
+		ExpiresAt: pr.ExpiresAt,
+	}
+	body := bytes.Buffer{}
+	if err = t.Execute(&body, data); err != nil {
+		return m, errors.Annotatef(err, "unable to render password reset")
+	}
+	m.Body = body.String()
+	return m, nil
+}
+
+// HandleForgotPassword serves /forgot-password POST request
+func (h *handler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := context.TODO()
+	id := strings.TrimPrefix(strings.TrimSpace(r.PostFormValue("handle")), "~")
+	if len(id) == 0 {
+		h.v.addFlashMessage(Error, w, r, "Please enter your handle or your email address")
+		h.v.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	a, email, err := h.storage.accountForPasswordReset(ctx, id)
+	if err != nil {
+		h.infoFn(log.Ctx{"err": err.Error(), "id": id})("password reset not sent")
+	} else {
+		token, pr, err := h.storage.SavePasswordReset(ctx, a, PasswordResetTTL)
+		var m mail.Message
+		if err == nil {
+			m, err = passwordResetMail(h.conf, a, email, token, pr)
+		}
+		if err == nil {
+			err = h.mail.Send(m)
+		}
+		if err != nil {
+			h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to send password reset")
+		} else {
+			h.infoFn(log.Ctx{"handle": a.Handle})("password reset sent")
+		}
+	}
+	// NOTE(marius): we show the same message whether the account exists or not, so it can't be used to find out
+	// which handles and email addresses are registered
+	h.v.addFlashMessage(Info, w, r, "If the account exists and it has an email address, we sent it a link for resetting the password")
+	h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *handler) invalidPasswordReset(w http.ResponseWriter, r *http.Request) {
+	h.v.addFlashMessage(Error, w, r, "The password reset link is invalid or it has expired")
+	h.v.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+}
+
+// HandleResetPasswordForm serves /reset-password/{token} request
+func (h *handler) HandleResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	pr, err := h.storage.LoadPasswordReset(context.TODO(), token)
+	if err != nil {
+		h.invalidPasswordReset(w, r)
+		return
+	}
+	m := &passwordResetModel{Title: "Reset password", Handle: pr.Handle, Token: token}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleResetPassword serves /reset-password/{token} POST request
+func (h *handler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := context.TODO()
+	token := chi.URLParam(r, "token")
+	pr, err := h.storage.LoadPasswordReset(ctx, token)
+	if err != nil {
+		h.invalidPasswordReset(w, r)
+		return
+	}
+	a, err := h.storage.LoadAccount(ctx, pub.IRI(pr.Account))
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "iri": pr.Account})("unable to load account for password reset")
+		h.invalidPasswordReset(w, r)
+		return
+	}
+	if err = h.setAccountPassword(r, *a, r.PostFormValue("pw"), r.PostFormValue("pw-confirm")); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to reset password")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to reset password: %s", err))
+		h.v.Redirect(w, r, fmt.Sprintf("/reset-password/%s", token), http.StatusSeeOther)
+		return
+	}
+	if err = h.storage.DeletePasswordReset(ctx, token); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to remove used password reset")
+	}
+	if err = h.storage.RevokeSessions(*a, time.Now()); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to revoke sessions")
+	}
+	h.infoFn(log.Ctx{"handle": a.Handle})("password reset")
+	h.v.addFlashMessage(Success, w, r, "Your password was reset, you can log in with the new one")
+	h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// HandleChangePassword serves /~{handle}/settings/password POST request
+func (h *handler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+
+	config := GetOauth2Config("fedbox", h.conf.BaseURL)
+	tok, err := config.PasswordCredentialsToken(context.TODO(), acc.Handle, r.PostFormValue("pw-current"))
+	if err != nil || tok == nil {
+		h.v.addFlashMessage(Error, w, r, "Unable to change password: the current password is not valid")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if err = h.setAccountPassword(r, *acc, r.PostFormValue("pw"), r.PostFormValue("pw-confirm")); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to change password")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to change password: %s", err))
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	now := time.Now()
+	if err = h.storage.RevokeSessions(*acc, now); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to revoke sessions")
+	}
+	// NOTE(marius): the current session stays logged in
+	if err = h.v.setSessionLoginTime(w, r, now); err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to save login time to session")
+	}
+	h.v.addFlashMessage(Success, w, r, "Password changed, your other sessions were logged out")
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// emailFromRequest loads the email address of the account from the settings form
+func emailFromRequest(r *http.Request) (string, error) {
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	if len(email) == 0 {
+		return "", nil
+	}
+	addr, err := netmail.ParseAddress(email)
+	if err != nil {
+		return "", errors.BadRequestf("invalid email address %q", email)
+	}
+	return addr.Address, nil
+}
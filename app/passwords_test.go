@@ -0,0 +1,82 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPasswordResetToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		token, err := newPasswordResetToken()
+		if err != nil {
+			t.Fatalf("newPasswordResetToken() error: %s", err)
+		}
+		if len(token) < 40 {
+			t.Errorf("newPasswordResetToken() = %q, too short", token)
+		}
+		if url.PathEscape(token) != token {
+			t.Errorf("newPasswordResetToken() = %q, it's not safe to use in an URL path", token)
+		}
+		if seen[token] {
+			t.Errorf("newPasswordResetToken() = %q, generated twice", token)
+		}
+		seen[token] = true
+
+		key := passwordResetKey(token)
+		if key == token || strings.Contains(key, token) {
+			t.Errorf("passwordResetKey(%q) = %q, it contains the token", token, key)
+		}
+		if key != passwordResetKey(token) {
+			t.Errorf("passwordResetKey(%q) is not stable", token)
+		}
+	}
+}
+
+func TestPasswordReset_valid(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		pr   passwordReset
+		want bool
+	}{
+		{name: "empty", pr: passwordReset{}, want: false},
+		{name: "pending", pr: passwordReset{Account: "https://example.com/actors/jane", ExpiresAt: now.Add(time.Minute)}, want: true},
+		{name: "expired", pr: passwordReset{Account: "https://example.com/actors/jane", ExpiresAt: now.Add(-time.Minute)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pr.valid(now); got != tt.want {
+				t.Errorf("valid() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmailFromRequest(t *testing.T) {
+	tests := []struct {
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{email: "", want: ""},
+		{email: " jane@example.com ", want: "jane@example.com"},
+		{email: "Jane <jane@example.com>", want: "jane@example.com"},
+		{email: "jane", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest(http.MethodPost, "/~jane/settings", strings.NewReader(url.Values{"email": {tt.email}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := emailFromRequest(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("emailFromRequest(%q) error = %v, wantErr %t", tt.email, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("emailFromRequest(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const pinsCollection = "pins"
+
+const (
+	// DefaultPinDuration is the time an item stays pinned when the moderator doesn't specify one
+	DefaultPinDuration = 7 * 24 * time.Hour
+	// MaxPinDuration is the longest time an item can stay pinned
+	MaxPinDuration = 90 * 24 * time.Hour
+)
+
+// itemPin records which moderator pinned an item to the top of the front page, or of a tag listing, and until when
+type itemPin struct {
+	IRI      string    `json:"iri"`
+	Tag      string    `json:"tag,omitempty"`
+	By       string    `json:"by"`
+	At       time.Time `json:"at"`
+	Until    time.Time `json:"until"`
+	Activity string    `json:"activity,omitempty"`
+}
+
+func (p itemPin) active(now time.Time) bool {
+	return now.Before(p.Until)
+}
+
+// itemPins are the pinned items, keyed by their hash.
+// The ActivityPub vocabulary doesn't have a way to represent them, so we keep them in the instance's local storage,
+// and we federate only the Add activity that put the item on top of the listing.
+type itemPins map[string]itemPin
+
+// inListing returns the hashes of the items pinned to the tag listing, or to the front page when tag is empty
+func (p itemPins) inListing(tag string, now time.Time) []string {
+	hashes := make([]string, 0)
+	for hash, pin := range p {
+		if pin.active(now) && strings.EqualFold(pin.Tag, tag) {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+func itemHasTag(it Item, tag string) bool {
+	for _, t := range it.Tags() {
+		if strings.EqualFold(t.Name, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinTarget returns the IRI of the listing the item is pinned to
+func pinTarget(tag string) pub.IRI {
+	// @todo(marius) :link_generation:
+	if len(tag) == 0 {
+		return pub.IRI(Instance.BaseURL)
+	}
+	return pub.IRI(fmt.Sprintf("%s/t/%s", Instance.BaseURL, url.PathEscape(tag)))
+}
+
+// loadItemsPins sets the pinned flag on the items that are currently pinned to a listing
+func (r *repository) loadItemsPins(items ...Item) ItemCollection {
+	if len(items) == 0 {
+		return items
+	}
+	pins := make(itemPins)
+	if err := r.store.Load(pinsCollection, &pins); err != nil {
+		r.errFn(log.Ctx{"err": err.Error()})("unable to load pinned items")
+		return items
+	}
+	if len(pins) == 0 {
+		return items
+	}
+	now := time.Now()
+	for k := range items {
+		it := &items[k]
+		if pin, ok := pins[it.Hash.String()]; ok && pin.active(now) {
+			it.Pin()
+		}
+	}
+	return items
+}
+
+// loadItemsLocalState sets the flags we keep in the instance's local storage on the items
+func (r *repository) loadItemsLocalState(items ...Item) ItemCollection {
+	return r.loadItemsHeld(r.loadItemsPins(r.loadItemsLocks(items...)...)...)
+}
+
+// LoadPinnedItems loads the items pinned to the tag listing, or to the front page when tag is empty
+func (r *repository) LoadPinnedItems(ctx context.Context, tag string) (ItemCollection, error) {
+	pins := make(itemPins)
+	if err := r.store.Load(pinsCollection, &pins); err != nil {
+		return nil, err
+	}
+	items := make(ItemCollection, 0)
+	for _, hash := range pins.inListing(tag, time.Now()) {
+		it, err := r.LoadItem(ctx, pub.IRI(pins[hash].IRI))
+		if err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "iri": pins[hash].IRI})("unable to load pinned item")
+			continue
+		}
+		if it.Deleted() {
+			continue
+		}
+		it.Pin()
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// PinItem puts the it item on top of the tag listing, or of the front page when tag is empty, for the d duration.
+// The pin is federated as an Add activity of the by moderator, having the listing as target.
+func (r *repository) PinItem(ctx context.Context, by Account, it Item, tag string, d time.Duration) error {
+	if !it.IsValid() {
+		return errors.NotFoundf("invalid item")
+	}
+	if !it.IsTop() {
+		return errors.BadRequestf("only top level items can be pinned")
+	}
+	if d <= 0 || d > MaxPinDuration {
+		return errors.BadRequestf("items can be pinned for at most %s", MaxPinDuration)
+	}
+	if len(tag) > 0 && !itemHasTag(it, tag) {
+		return errors.BadRequestf("item is not tagged as #%s", tag)
+	}
+	add, err := r.moderationActivityOnItem(ctx, by, it, nil)
+	if err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	now := time.Now().UTC()
+	add.Type = pub.AddType
+	add.Target = pinTarget(tag)
+	add.StartTime = now
+	add.EndTime = now.Add(d)
+
+	hash := it.Hash.String()
+	// NOTE(marius): we record the pin before federating it, so concurrent requests can't pin the item twice,
+	//   but we don't hold the storage lock while waiting for FedBOX
+	err = r.addPin(hash, itemPin{
+		IRI:   it.Metadata.ID,
+		Tag:   tag,
+		By:    by.Handle,
+		At:    now,
+		Until: now.Add(d),
+	})
+	if err != nil {
+		return err
+	}
+	iri, _, err := r.fedbox.ToOutbox(ctx, add)
+	pins := make(itemPins)
+	if err != nil {
+		r.errFn()(err.Error())
+		if uerr := r.store.Update(pinsCollection, &pins, func() error {
+			delete(pins, hash)
+			return nil
+		}); uerr != nil {
+			r.errFn(log.Ctx{"err": uerr.Error()})("unable to remove pin")
+		}
+		return err
+	}
+	err = r.store.Update(pinsCollection, &pins, func() error {
+		if pin, ok := pins[hash]; ok {
+			pin.Activity = iri.String()
+			pins[hash] = pin
+		}
+		return nil
+	})
+	if err == nil {
+		r.Notify(it.SubmittedBy, moderationNotification("pinned", it))
+	}
+	return err
+}
+
+// addPin records the pin of the hash item, removing the pins that expired in the meantime
+func (r *repository) addPin(hash string, pin itemPin) error {
+	pins := make(itemPins)
+	return r.store.Update(pinsCollection, &pins, func() error {
+		for h, p := range pins {
+			if !p.active(pin.At) {
+				delete(pins, h)
+			}
+		}
+		if _, ok := pins[hash]; ok {
+			return errors.BadRequestf("item is already pinned")
+		}
+		pins[hash] = pin
+		return nil
+	})
+}
+
+// UnpinItem removes the it item from the top of its listing, undoing the activity that pinned it.
+// Pins that expired are removed without federating anything, as their Add activity has an end time.
+// The tag moderators can unpin only the items pinned to the listings of their tags.
+func (r *repository) UnpinItem(ctx context.Context, by Account, it Item) error {
+	if !accountValidForC2S(&by) {
+		return errors.Unauthorizedf("invalid account %s", by.Handle)
+	}
+	hash := it.Hash.String()
+	var pin itemPin
+	pins := make(itemPins)
+	err := r.store.Update(pinsCollection, &pins, func() error {
+		var ok bool
+		if pin, ok = pins[hash]; !ok {
+			return errors.NotFoundf("item is not pinned")
+		}
+		if !by.IsModerator() && (len(pin.Tag) == 0 || !by.Roles.ContainsScope(RoleTagModerator, pin.Tag)) {
+			return errors.Forbiddenf("you can't unpin items from this listing")
+		}
+		delete(pins, hash)
+		return nil
+	})
+	if err != nil || len(pin.Activity) == 0 || !pin.active(time.Now()) {
+		return err
+	}
+	undo := new(pub.Activity)
+	undo.Type = pub.UndoType
+	undo.Actor = r.loadAPPerson(by).GetLink()
+	undo.Object = pub.IRI(pin.Activity)
+	undo.BCC = pub.ItemCollection{r.fedbox.Service().ID, pub.PublicNS}
+	if _, _, err = r.fedbox.ToOutbox(ctx, undo); err != nil {
+		r.errFn()(err.Error())
+		// NOTE(marius): the Undo didn't get federated, so we put the pin back
+		pins = make(itemPins)
+		if uerr := r.store.Update(pinsCollection, &pins, func() error {
+			if _, ok := pins[hash]; !ok {
+				pins[hash] = pin
+			}
+			return nil
+		}); uerr != nil {
+			r.errFn(log.Ctx{"err": uerr.Error()})("unable to restore pin")
+		}
+		return err
+	}
+	return nil
+}
+
+// PinnedItemsMw adds the items pinned to the current listing to its first page, and sorts them on top of it.
+// It needs to run after the listing was loaded and its sorting function was set.
+func PinnedItemsMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := ContextCursor(r.Context())
+		m := ContextListingModel(r.Context())
+		repo := ContextRepository(r.Context())
+		if c == nil || m == nil || repo == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tag := chi.URLParam(r, "tag")
+		q := r.URL.Query()
+		firstPage := len(q.Get("after")) == 0 && len(q.Get("before")) == 0
+
+		pinned := make(map[Hash]bool)
+		if firstPage {
+			items, err := repo.LoadPinnedItems(context.TODO(), tag)
+			if err != nil {
+				repo.errFn(log.Ctx{"err": err.Error(), "tag": tag})("unable to load pinned items")
+			}
+			viewer := ContextAccount(r.Context())
+			for k := range items {
+				if hidesItem(viewer, &items[k]) {
+					continue
+				}
+				pinned[items[k].Hash] = true
+				c.items.Append(&items[k])
+			}
+		}
+		// NOTE(marius): the items pinned to other listings, or shown on the next pages, keep their place
+		for _, ren := range c.items {
+			if it, ok := ren.(*Item); ok && it.IsPinned() && !pinned[it.Hash] {
+				it.Unpin()
+			}
+		}
+		if sortFn := m.sortFn; sortFn != nil && len(pinned) > 0 {
+			m.sortFn = func(list RenderableList) []Renderable {
+				return pinnedFirst(sortFn(list))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pinnedFirst moves the pinned items on top of the sorted rl list, keeping their order
+func pinnedFirst(rl []Renderable) []Renderable {
+	result := make([]Renderable, 0, len(rl))
+	for _, ren := range rl {
+		if it, ok := ren.(*Item); ok && it.IsPinned() {
+			result = append(result, ren)
+		}
+	}
+	for _, ren := range rl {
+		if it, ok := ren.(*Item); !ok || !it.IsPinned() {
+			result = append(result, ren)
+		}
+	}
+	return result
+}
+
+// HandlePin serves /{year}/{month}/{day}/{hash}/pin and /{year}/{month}/{day}/{hash}/unpin requests
+func (h *handler) HandlePin(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	repo := h.storage
+	ctx := context.TODO()
+	p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.errFn()("Error: %s", err)
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
+		return
+	}
+	q := r.URL.Query()
+	tag := strings.TrimPrefix(q.Get("tag"), "#")
+	redirect := ItemPermaLink(&p)
+
+	op := "unpin"
+	if strings.HasSuffix(r.URL.Path, "/pin") {
+		op = "pin"
+		// NOTE(marius): tag moderators can pin items only to the listings of their tags
+		if !acc.IsModerator() && (len(tag) == 0 || !acc.Roles.ContainsScope(RoleTagModerator, tag)) {
+			h.v.HandleErrors(w, r, errors.Forbiddenf("you can't pin items to this listing"))
+			return
+		}
+		d := DefaultPinDuration
+		if days := q.Get("days"); len(days) > 0 {
+			n, err := strconv.Atoi(days)
+			if err != nil || n <= 0 {
+				h.v.HandleErrors(w, r, errors.BadRequestf("invalid number of days %q", days))
+				return
+			}
+			// NOTE(marius): we clamp the number of days before converting it, so large values can't overflow the duration
+			if maxDays := int(MaxPinDuration / (24 * time.Hour)); n > maxDays {
+				n = maxDays
+			}
+			d = time.Duration(n) * 24 * time.Hour
+		}
+		if err = repo.PinItem(ctx, *acc, p, tag, d); err == nil {
+			redirect = "/"
+			if len(tag) > 0 {
+				redirect = fmt.Sprintf("/t/%s", url.PathEscape(tag))
+			}
+		}
+	} else {
+		err = repo.UnpinItem(ctx, *acc, p)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": p.Hash})("unable to %s item", op)
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s item: %s", op, err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Item %sned", op))
+	}
+	h.v.Redirect(w, r, redirect, http.StatusFound)
+}
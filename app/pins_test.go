@@ -0,0 +1,75 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemPins_inListing(t *testing.T) {
+	now := time.Now()
+	pins := itemPins{
+		"front":   {Until: now.Add(time.Hour)},
+		"expired": {Until: now.Add(-time.Hour)},
+		"golang":  {Tag: "golang", Until: now.Add(time.Hour)},
+	}
+	if got := pins.inListing("", now); len(got) != 1 || got[0] != "front" {
+		t.Errorf("inListing(front page) = %v, want [front]", got)
+	}
+	if got := pins.inListing("GoLang", now); len(got) != 1 || got[0] != "golang" {
+		t.Errorf("inListing(golang) = %v, want [golang]", got)
+	}
+	if got := pins.inListing("", now.Add(2*time.Hour)); len(got) != 0 {
+		t.Errorf("inListing(front page) after expiry = %v, want none", got)
+	}
+}
+
+func TestPinnedFirst(t *testing.T) {
+	pinned := &Item{Title: "pinned", Flags: FlagsPinned}
+	first := &Item{Title: "first"}
+	second := &Item{Title: "second"}
+	got := pinnedFirst([]Renderable{first, pinned, second})
+	want := []*Item{pinned, first, second}
+	if len(got) != len(want) {
+		t.Fatalf("pinnedFirst() returned %d items, want %d", len(got), len(want))
+	}
+	for i, ren := range got {
+		if ren.(*Item) != want[i] {
+			t.Errorf("pinnedFirst()[%d] = %s, want %s", i, ren.(*Item).Title, want[i].Title)
+		}
+	}
+}
+
+func TestPinTarget(t *testing.T) {
+	Instance.BaseURL = "https://example.com"
+	if got := pinTarget(""); got != "https://example.com" {
+		t.Errorf("pinTarget(\"\") = %s, want https://example.com", got)
+	}
+	if got := pinTarget("c++/go lang"); got != "https://example.com/t/c++%2Fgo%20lang" {
+		t.Errorf("pinTarget() = %s, want the escaped tag", got)
+	}
+}
+
+func TestRepository_addPin(t *testing.T) {
+	r, cleanup := testRepository(t)
+	defer cleanup()
+
+	now := time.Now().UTC()
+	expired := itemPin{IRI: "https://example.com/objects/expired", At: now.Add(-2 * time.Hour), Until: now.Add(-time.Hour)}
+	if err := r.addPin("expired", expired); err != nil {
+		t.Fatalf("addPin() error = %s", err)
+	}
+	pin := itemPin{IRI: "https://example.com/objects/expired", At: now, Until: now.Add(time.Hour)}
+	if err := r.addPin("expired", pin); err != nil {
+		t.Fatalf("addPin() of an item with an expired pin error = %s", err)
+	}
+	if err := r.addPin("expired", pin); err == nil {
+		t.Errorf("addPin() of an item which is already pinned didn't return an error")
+	}
+	pins := make(itemPins)
+	if err := r.store.Load(pinsCollection, &pins); err != nil {
+		t.Fatalf("unable to load pins: %s", err)
+	}
+	if len(pins) != 1 || !pins["expired"].Until.Equal(pin.Until) {
+		t.Errorf("pins = %v, want only the new pin", pins)
+	}
+}
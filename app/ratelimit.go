@@ -0,0 +1,313 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// rateAction is an action that has its own rate limits
+type rateAction string
+
+const (
+	rateSubmit    = rateAction("submit")
+	rateVote      = rateAction("vote")
+	rateReport    = rateAction("report")
+	rateRegister  = rateAction("register")
+	ratePassword  = rateAction("password")
+	rateTwoFactor = rateAction("2fa")
+	rateInvite    = rateAction("invite")
+)
+
+// rateTier groups the accounts which share the same rate limits
+type rateTier string
+
+const (
+	tierDefault   = rateTier("")
+	tierNew       = rateTier("new")
+	tierAnonymous = rateTier("anonymous")
+)
+
+// rateLimit allows Burst requests, which are replenished evenly over Period.
+// A zero Burst means the action is not limited.
+type rateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// rateLimits maps "action" and "action.tier" keys to their limits
+type rateLimits map[string]rateLimit
+
+// DefaultRateLimits are the limits used for the actions that are not present in the configuration
+const DefaultRateLimits = "submit=60/1h,submit.new=10/1h,submit.anonymous=5/1h," +
+	"vote=300/1h,vote.new=60/1h,vote.anonymous=30/1h," +
+	"report=30/1h,report.new=5/1h,report.anonymous=2/1h," +
+	"register=5/1h,password=5/1h,2fa=10/15m,invite=20/24h"
+
+func parseRateLimit(s string) (rateLimit, error) {
+	l := rateLimit{}
+	pieces := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	burst, err := strconv.Atoi(strings.TrimSpace(pieces[0]))
+	if err != nil || burst < 0 {
+		return l, errors.Newf("invalid rate limit %q", s)
+	}
+	if burst == 0 {
+		return l, nil
+	}
+	if len(pieces) != 2 {
+		return l, errors.Newf("missing period for rate limit %q", s)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(pieces[1]))
+	if err != nil || period <= 0 {
+		return l, errors.Newf("invalid period for rate limit %q", s)
+	}
+	l.Burst = burst
+	l.Period = period
+	return l, nil
+}
+
+// parseRateLimits parses a comma separated list of action[.tier]=burst/period values, eg: submit.new=10/1h
+func parseRateLimits(s string) (rateLimits, error) {
+	limits := make(rateLimits)
+	for _, piece := range strings.Split(s, ",") {
+		if piece = strings.TrimSpace(piece); len(piece) == 0 {
+			continue
+		}
+		kv := strings.SplitN(piece, "=", 2)
+		if len(kv) != 2 {
+			return limits, errors.Newf("invalid rate limit %q", piece)
+		}
+		l, err := parseRateLimit(kv[1])
+		if err != nil {
+			return limits, err
+		}
+		limits[strings.ToLower(strings.TrimSpace(kv[0]))] = l
+	}
+	return limits, nil
+}
+
+// get returns the limit of the action for the accounts in the tier.
+// If the tier doesn't have its own limit, the action's default limit is used.
+func (rl rateLimits) get(action rateAction, tier rateTier) rateLimit {
+	if tier != tierDefault {
+		if l, ok := rl[fmt.Sprintf("%s.%s", action, tier)]; ok {
+			return l
+		}
+	}
+	return rl[string(action)]
+}
+
+type tokenBucket struct {
+	tokens float64
+	rate   float64
+	last   time.Time
+}
+
+// rateLimiter is an in memory token-bucket limiter
+type rateLimiter struct {
+	m       sync.Mutex
+	limits  rateLimits
+	buckets map[string]*tokenBucket
+	// trusted are the networks of the reverse proxies whose forwarding headers we use to find the client's address
+	trusted []*net.IPNet
+}
+
+// maxRateBuckets is the maximum number of buckets we keep, after reaching it we drop the ones that are full again,
+// and if that's not enough, the ones that have been used least recently
+const maxRateBuckets = 10000
+
+func newRateLimiter(limits rateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow consumes a token from the key bucket, and returns false and the time until the next token is available
+// if there were none left.
+func (l *rateLimiter) allow(key string, lim rateLimit, now time.Time) (bool, time.Duration) {
+	if lim.Burst <= 0 {
+		return true, 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	rate := float64(lim.Burst) / lim.Period.Seconds()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.prune(now, maxRateBuckets-1)
+		}
+		b = &tokenBucket{tokens: float64(lim.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.rate = rate
+	b.tokens = math.Min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune removes the buckets that have not been used for long enough to be full again,
+// then the least recently used ones until there are at most max buckets left
+func (l *rateLimiter) prune(now time.Time, max int) {
+	for k, b := range l.buckets {
+		// NOTE(marius): every bucket gets replenished at the rate of the limit it was last used with
+		if b.rate > 0 && now.Sub(b.last).Seconds()*b.rate >= 1 {
+			delete(l.buckets, k)
+		}
+	}
+	if len(l.buckets) <= max {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for k := range l.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last)
+	})
+	for _, k := range keys[:len(keys)-max] {
+		delete(l.buckets, k)
+	}
+}
+
+// parseTrustedProxies parses a list of IP addresses and CIDR networks
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nets, errors.Annotatef(err, "invalid trusted proxy %s", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client that made the request.
+// When the request comes from one of the trusted proxies, we use the address it forwarded in the
+// X-Forwarded-For header, skipping the other trusted proxies in the chain, or the X-Real-IP header.
+// The headers sent by any other client are ignored, as they can be forged.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !isTrustedProxy(ip, trusted) {
+		return host
+	}
+	if fwd := r.Header.Get("X-Forwarded-For"); len(fwd) > 0 {
+		hops := strings.Split(fwd, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if !isTrustedProxy(ip, trusted) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// rateTierFor returns the tier of the a account: anonymous, new, or default for the accounts older than age
+func rateTierFor(a *Account, age time.Duration, now time.Time) rateTier {
+	if !a.IsLogged() {
+		return tierAnonymous
+	}
+	if !a.CreatedAt.IsZero() && now.Sub(a.CreatedAt) < age {
+		return tierNew
+	}
+	return tierDefault
+}
+
+// rateKey returns the key of the bucket for the action: the logged accounts are limited by their hash,
+// the anonymous ones by their IP address.
+func rateKey(action rateAction, a *Account, ip string) string {
+	if a.IsLogged() && a.Hash.IsValid() {
+		return fmt.Sprintf("%s:acct:%s", action, a.Hash)
+	}
+	return fmt.Sprintf("%s:ip:%s", action, ip)
+}
+
+func waitFmt(d time.Duration) string {
+	if d < time.Minute {
+		return "a minute"
+	}
+	if d < time.Hour {
+		m := int(math.Ceil(d.Minutes()))
+		return fmt.Sprintf("%d %s", m, pluralize(float64(m), "minute"))
+	}
+	hr := int(math.Ceil(d.Hours()))
+	return fmt.Sprintf("%d %s", hr, pluralize(float64(hr), "hour"))
+}
+
+// allowAction returns true if the current account, or the client's IP address for anonymous users, can perform
+// the action now, otherwise it returns how long they have to wait
+func (h *handler) allowAction(r *http.Request, action rateAction) (bool, time.Duration) {
+	if h.limiter == nil {
+		return true, 0
+	}
+	acc := loggedAccount(r)
+	now := time.Now().UTC()
+	lim := h.limiter.limits.get(action, rateTierFor(acc, h.conf.NewAccountAge, now))
+	ip := clientIP(r, h.limiter.trusted)
+	ok, wait := h.limiter.allow(rateKey(action, acc, ip), lim, now)
+	if !ok {
+		h.infoFn(log.Ctx{"action": action, "handle": acc.Handle, "ip": ip})("rate limit reached")
+	}
+	return ok, wait
+}
+
+// RateLimit limits how often the current account, or the client's IP address for anonymous users, can perform the action
+func (h *handler) RateLimit(action rateAction) Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if h.limiter == nil || (r.Method != http.MethodPost && action != rateVote) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, wait := h.allowAction(r, action); !ok {
+				h.v.addFlashMessage(Error, w, r, fmt.Sprintf("You are doing this too often. Please take a break and try again in %s.", waitFmt(wait)))
+				backURL := "/"
+				if ref := r.Header.Get("Referer"); len(ref) > 0 && HostIsLocal(ref) {
+					backURL = ref
+				}
+				h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("submit=10/1h, submit.new = 2/30m,vote=0")
+	if err != nil {
+		t.Fatalf("parseRateLimits() error: %s", err)
+	}
+	if l := limits.get(rateSubmit, tierDefault); l.Burst != 10 || l.Period != time.Hour {
+		t.Errorf("submit limit = %v, want 10/1h", l)
+	}
+	if l := limits.get(rateSubmit, tierNew); l.Burst != 2 || l.Period != 30*time.Minute {
+		t.Errorf("submit.new limit = %v, want 2/30m", l)
+	}
+	if l := limits.get(rateSubmit, tierAnonymous); l.Burst != 10 {
+		t.Errorf("submit.anonymous limit = %v, want the submit one", l)
+	}
+	if l := limits.get(rateVote, tierNew); l.Burst != 0 {
+		t.Errorf("vote limit = %v, want disabled", l)
+	}
+
+	for _, invalid := range []string{"submit", "submit=ten/1h", "submit=10", "submit=10/forever", "submit=-1/1h"} {
+		if _, err := parseRateLimits(invalid); err == nil {
+			t.Errorf("parseRateLimits(%q) expected error", invalid)
+		}
+	}
+	if _, err := parseRateLimits(DefaultRateLimits); err != nil {
+		t.Errorf("parseRateLimits(DefaultRateLimits) error: %s", err)
+	}
+}
+
+func TestRateLimiter_allow(t *testing.T) {
+	l := newRateLimiter(nil)
+	lim := rateLimit{Burst: 2, Period: time.Minute}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("jane", lim, now); !ok {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+	ok, wait := l.allow("jane", lim, now)
+	if ok {
+		t.Fatalf("request over the burst was allowed")
+	}
+	if wait != 30*time.Second {
+		t.Errorf("wait = %s, want %s", wait, 30*time.Second)
+	}
+	if ok, _ := l.allow("john", lim, now); !ok {
+		t.Errorf("request for a different key was limited")
+	}
+	if ok, _ := l.allow("jane", lim, now.Add(30*time.Second)); !ok {
+		t.Errorf("request after the token was replenished was limited")
+	}
+	if ok, _ := l.allow("jane", rateLimit{}, now); !ok {
+		t.Errorf("request without a limit was limited")
+	}
+}
+
+func TestRateLimiter_prune(t *testing.T) {
+	l := newRateLimiter(nil)
+	now := time.Now()
+	slow := rateLimit{Burst: 1, Period: time.Hour}
+	fast := rateLimit{Burst: 1, Period: time.Second}
+
+	l.allow("fast", fast, now.Add(-time.Minute))
+	for i := 0; i < 5; i++ {
+		l.allow(fmt.Sprintf("slow-%d", i), slow, now.Add(time.Duration(i)*time.Second))
+	}
+	l.prune(now.Add(10*time.Second), 3)
+	if len(l.buckets) != 3 {
+		t.Fatalf("prune() left %d buckets, want 3", len(l.buckets))
+	}
+	if _, ok := l.buckets["fast"]; ok {
+		t.Errorf("prune() kept the bucket that was full again")
+	}
+	for _, k := range []string{"slow-2", "slow-3", "slow-4"} {
+		if _, ok := l.buckets[k]; !ok {
+			t.Errorf("prune() removed the recently used bucket %s", k)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies() error: %s", err)
+	}
+	if _, err := parseTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Errorf("parseTrustedProxies() expected error for a host name")
+	}
+	tests := []struct {
+		name   string
+		remote string
+		fwd    string
+		real   string
+		want   string
+	}{
+		{name: "direct", remote: "203.0.113.7:1234", want: "203.0.113.7"},
+		{name: "forged header", remote: "203.0.113.7:1234", fwd: "198.51.100.1", want: "203.0.113.7"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", fwd: "198.51.100.1", want: "198.51.100.1"},
+		{name: "chain of proxies", remote: "10.0.0.1:1234", fwd: "1.2.3.4, 198.51.100.1, 192.168.1.1", want: "198.51.100.1"},
+		{name: "real ip", remote: "192.168.1.1:1234", real: "198.51.100.2", want: "198.51.100.2"},
+		{name: "trusted proxy without headers", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPost, "/submit", nil)
+			r.RemoteAddr = tt.remote
+			if len(tt.fwd) > 0 {
+				r.Header.Set("X-Forwarded-For", tt.fwd)
+			}
+			if len(tt.real) > 0 {
+				r.Header.Set("X-Real-IP", tt.real)
+			}
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateTierFor(t *testing.T) {
+	now := time.Now()
+	anon := &Account{Handle: "anonymous"}
+	fresh := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1"), CreatedAt: now.Add(-time.Hour)}
+	old := &Account{Handle: "john", Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a"), CreatedAt: now.Add(-30 * 24 * time.Hour)}
+
+	if tier := rateTierFor(anon, 24*time.Hour, now); tier != tierAnonymous {
+		t.Errorf("rateTierFor(anonymous) = %q, want %q", tier, tierAnonymous)
+	}
+	if tier := rateTierFor(fresh, 24*time.Hour, now); tier != tierNew {
+		t.Errorf("rateTierFor(new) = %q, want %q", tier, tierNew)
+	}
+	if tier := rateTierFor(old, 24*time.Hour, now); tier != tierDefault {
+		t.Errorf("rateTierFor(old) = %q, want %q", tier, tierDefault)
+	}
+}
@@ -34,6 +34,8 @@ type repository struct {
 	SelfURL string
 	app     *Account
 	fedbox  *fedbox
+	store   *localStore
+	checks  *ContentChecks
 	infoFn  CtxLogFn
 	errFn   CtxLogFn
 }
@@ -64,9 +66,16 @@ func ActivityPubService(c appConfig) (*repository, error) {
 
 	repo := &repository{
 		SelfURL: c.BaseURL,
+		store:   newLocalStore(c.StoragePath),
 		infoFn:  infoFn,
 		errFn:   errFn,
 	}
+	var errs []error
+	if repo.checks, errs = NewContentChecks(c.Configuration); len(errs) > 0 {
+		for _, err := range errs {
+			errFn(log.Ctx{"err": err})("invalid content checks configuration")
+		}
+	}
 	var err error
 	repo.fedbox, err = NewClient(SetURL(c.APIURL), SetInfoLogger(infoFn), SetErrorLogger(errFn), SetUA(ua))
 	if err != nil {
@@ -104,6 +113,9 @@ func loadAPItem(it pub.Item, item Item) error {
 		if item.MimeType == MimeTypeURL {
 			o.Type = pub.PageType
 			o.URL = pub.IRI(item.Data)
+			if item.Metadata != nil {
+				o.Preview = loadAPLinkPreview(item.Metadata.Preview)
+			}
 		} else {
 			wordCount := strings.Count(item.Data, " ") +
 				strings.Count(item.Data, "\t") +
@@ -173,6 +185,9 @@ func loadAPItem(it pub.Item, item Item) error {
 		if item.Title != "" {
 			o.Name.Set("en", pub.Content(item.Title))
 		}
+		if item.Summary != "" {
+			o.Summary.Set("en", pub.Content(item.Summary))
+		}
 		if item.SubmittedBy != nil {
 			o.AttributedTo = BuildActorID(*item.SubmittedBy)
 		}
@@ -261,6 +276,12 @@ func loadAPItem(it pub.Item, item Item) error {
 				}
 			}
 		}
+		if item.HasAttachments() {
+			o.Attachment = loadAPAttachments(item.Metadata.Attachments)
+		}
+		if item.HasContentWarning() {
+			o.Tag.Append(sensitiveTag())
+		}
 		o.To = to
 		o.CC = cc
 		o.BCC = bcc
@@ -294,16 +315,23 @@ func (r *repository) loadAPPerson(a Account) *pub.Actor {
 	p.PreferredUsername = pub.NaturalLanguageValuesNew()
 
 	if a.HasMetadata() {
+		p.Summary = nil
+		p.Source = pub.Source{}
 		if a.Metadata.Blurb != nil && len(a.Metadata.Blurb) > 0 {
 			p.Summary = pub.NaturalLanguageValuesNew()
-			p.Summary.Set(pub.NilLangRef, a.Metadata.Blurb)
+			p.Summary.Set(pub.NilLangRef, pub.Content(renderBio(a.Metadata.Blurb)))
+			p.Source.MediaType = MimeTypeMarkdown
+			p.Source.Content = pub.NaturalLanguageValuesNew()
+			p.Source.Content.Set(pub.NilLangRef, a.Metadata.Blurb)
 		}
+		p.Icon = nil
 		if len(a.Metadata.Icon.URI) > 0 {
 			avatar := pub.ObjectNew(pub.ImageType)
 			avatar.MediaType = pub.MimeType(a.Metadata.Icon.MimeType)
 			avatar.URL = pub.IRI(a.Metadata.Icon.URI)
 			p.Icon = avatar
 		}
+		p.Attachment = loadAPAliases(loadAPProfileLinks(a.Metadata.Links), a.Move.AlsoKnownAs)
 	}
 
 	p.PreferredUsername.Set(pub.NilLangRef, pub.Content(a.Handle))
@@ -362,6 +390,14 @@ func (r *repository) WithAccount(a *Account) *repository {
 	return r
 }
 
+// forAccount returns a copy of the repository which signs its requests to FedBOX as the a account.
+// It's used for the work done outside of the HTTP request of the account.
+func (r *repository) forAccount(a *Account) *repository {
+	c := *r
+	c.fedbox = r.fedbox.withSignFn(r.withAccountC2S(a))
+	return &c
+}
+
 func (r *repository) withAccountC2S(a *Account) client.RequestSignFn {
 	return func(req *http.Request) error {
 		// TODO(marius): this needs to be added to the federated requests, which we currently don't support
@@ -429,6 +465,7 @@ func (r *repository) LoadItem(ctx context.Context, iri pub.IRI) (Item, error) {
 		var items ItemCollection
 		items, err = r.loadItemsAuthors(ctx, item)
 		items, err = r.loadItemsVotes(ctx, items...)
+		items = r.loadItemsLocalState(items...)
 		if len(items) > 0 {
 			item = items[0]
 		}
@@ -671,6 +708,9 @@ func (r *repository) loadItemsVotes(ctx context.Context, items ...Item) (ItemCol
 			}
 			v := new(Vote)
 			if err := v.FromActivityPub(vAct); err == nil {
+				if hidesAccount(ContextAccount(ctx), v.SubmittedBy) {
+					continue
+				}
 				for k, ob := range items {
 					if itemsEqual(*v.Item, ob) {
 						items[k].Score += v.Weight
@@ -803,7 +843,7 @@ func (r *repository) loadModerationFollowups(ctx context.Context, items Renderab
 	}
 
 	modActions := new(Filters)
-	modActions.Type = ActivityTypesFilter(pub.DeleteType, pub.UpdateType)
+	modActions.Type = ActivityTypesFilter(ModerationFollowupActivityTypes...)
 	modActions.InReplTo = IRIsFilter(inReplyTo...)
 	modActions.Actor = &Filters{
 		IRI: notNilIRIs,
@@ -1194,7 +1234,7 @@ func (r *repository) objects(ctx context.Context, ff ...*Filters) (ItemCollectio
 	if items, err = r.loadItemsVotes(ctx, items...); err != nil {
 		return nil, err
 	}
-	return items, nil
+	return r.loadItemsLocalState(items...), nil
 }
 
 func (r *repository) Objects(ctx context.Context, ff ...*Filters) (Cursor, error) {
@@ -1202,6 +1242,8 @@ func (r *repository) Objects(ctx context.Context, ff ...*Filters) (Cursor, error
 	if err != nil {
 		return emptyCursor, err
 	}
+	showHidden := ContextShowHidden(ctx)
+	items, hidden := hideItems(ContextAccount(ctx), items, showHidden)
 	result := make(RenderableList, 0)
 	for _, it := range items {
 		if it.Hash.IsValid() {
@@ -1214,13 +1256,31 @@ func (r *repository) Objects(ctx context.Context, ff ...*Filters) (Cursor, error
 		prev = HashFromString(f.Prev)
 	}
 	return Cursor{
-		after:  next,
-		before: prev,
-		items:  result,
-		total:  uint(len(result)),
+		after:      next,
+		before:     prev,
+		items:      result,
+		total:      uint(len(result)),
+		hidden:     hidden,
+		showHidden: showHidden,
 	}, nil
 }
 
+// LoadItemsByURL loads the link items that have been submitted for URL u
+func (r *repository) LoadItemsByURL(ctx context.Context, u string) (ItemCollection, error) {
+	urls := CompStrs{EqualsString(u)}
+	if strings.HasSuffix(u, "/") {
+		urls = append(urls, EqualsString(strings.TrimSuffix(u, "/")))
+	} else {
+		urls = append(urls, EqualsString(u+"/"))
+	}
+	f := &Filters{
+		Type:     CompStrs{EqualsString(string(pub.PageType))},
+		URL:      urls,
+		MaxItems: MaxContentItems,
+	}
+	return r.objects(ctx, f)
+}
+
 func validFederated(i Item, f *Filters) bool {
 	ob, err := pub.ToObject(i.pub)
 	if err != nil {
@@ -1438,6 +1498,11 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 	if err != nil {
 		return emptyCursor, err
 	}
+	items = r.loadItemsLocalState(items...)
+	viewer := ContextAccount(ctx)
+	showHidden := ContextShowHidden(ctx)
+	items, hidden := hideItems(viewer, items, showHidden)
+	appreciations = hideVotes(viewer, appreciations)
 	follows, err = r.loadFollowsAuthors(ctx, follows...)
 	if err != nil {
 		return emptyCursor, err
@@ -1505,10 +1570,12 @@ func (r *repository) ActorCollection(ctx context.Context, fn CollectionFn, ff ..
 	}
 
 	return Cursor{
-		after:  next,
-		before: prev,
-		items:  result,
-		total:  uint(len(result)),
+		after:      next,
+		before:     prev,
+		items:      result,
+		total:      uint(len(result)),
+		hidden:     hidden,
+		showHidden: showHidden,
 	}, nil
 }
 
@@ -1661,6 +1728,26 @@ func (r *repository) getAuthorRequestURL(a *Account) string {
 }
 
 func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
+	if it.SubmittedBy == nil || !it.SubmittedBy.HasMetadata() {
+		return Item{}, errors.Newf("invalid account")
+	}
+	check := checkAllowed
+	if !it.Deleted() {
+		if check = r.checks.CheckItem(ctx, it); !check.Allowed() {
+			r.infoFn(log.Ctx{"check": check.Check, "reason": check.Reason, "author": it.SubmittedBy.Handle})("item rejected")
+			return it, errors.Forbiddenf("your submission was rejected, it %s", check.Reason)
+		}
+	}
+	saved, err := r.saveItem(ctx, it, check, false)
+	if err == nil && !it.Deleted() {
+		r.checks.ItemSaved(it)
+	}
+	return saved, err
+}
+
+// saveItem publishes the it item with the outcome of the content checks already known.
+// The held items are addressed only to their authors until a moderator approves them.
+func (r *repository) saveItem(ctx context.Context, it Item, check CheckResult, approved bool) (Item, error) {
 	if it.SubmittedBy == nil || !it.SubmittedBy.HasMetadata() {
 		return Item{}, errors.Newf("invalid account")
 	}
@@ -1677,6 +1764,8 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 	to := make(pub.ItemCollection, 0)
 	cc := make(pub.ItemCollection, 0)
 	bcc := make(pub.ItemCollection, 0)
+	parent := it.Parent
+	mentioned := make(AccountCollection, 0)
 
 	var err error
 
@@ -1705,6 +1794,7 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 			for _, actor := range actors {
 				if actor.HasMetadata() && len(actor.Metadata.ID) > 0 {
 					cc = append(cc, pub.IRI(actor.Metadata.ID))
+					mentioned = append(mentioned, actor)
 				}
 			}
 		}
@@ -1737,9 +1827,27 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 		bcc = append(bcc, r.fedbox.Service().ID)
 	}
 
+	wasHeld := false
+	if !approved && !it.Deleted() && it.Hash.IsValid() {
+		// NOTE(marius): saving again an item that waits for moderation, be it an edit of its author or the update
+		//   with its link's metadata, doesn't publish it, only the approval of a moderator does
+		var res CheckResult
+		if res, wasHeld = r.heldCheck(it.Hash); wasHeld && !check.Held() {
+			check = res
+		}
+	}
+
 	art := new(pub.Object)
 	loadAPItem(art, it)
 	id := art.GetLink()
+	if check.Held() {
+		// NOTE(marius): the held items don't get federated, and only their authors can see them
+		//   until a moderator approves them
+		to = pub.ItemCollection{author.GetLink()}
+		cc = make(pub.ItemCollection, 0)
+		bcc = pub.ItemCollection{r.fedbox.Service().ID}
+		art.To, art.CC, art.BCC = to, cc, bcc
+	}
 
 	act := &pub.Activity{
 		To:     to,
@@ -1749,6 +1857,8 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 		Object: art,
 	}
 	loadAuthors := true
+	isNew := false
+	var prev *itemRevision
 	if it.Deleted() {
 		if len(id) == 0 {
 			r.errFn(log.Ctx{
@@ -1762,8 +1872,14 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 	} else {
 		if len(id) == 0 {
 			act.Type = pub.CreateType
+			isNew = true
 		} else {
 			act.Type = pub.UpdateType
+			if it.pub != nil {
+				// NOTE(marius): we keep the previous version of the object in the local storage,
+				//   which allows us to show the revisions of an item
+				prev = revisionFromAPObject(it.pub)
+			}
 		}
 	}
 	var ob pub.Item
@@ -1772,14 +1888,42 @@ func (r *repository) SaveItem(ctx context.Context, it Item) (Item, error) {
 		r.errFn()(err.Error())
 		return it, err
 	}
+	if prev != nil && !prev.sameContent(it) {
+		prev.By = author.GetLink().String()
+		prev.At = time.Now().UTC()
+		if err := r.addItemRevision(it.Hash, *prev); err != nil {
+			r.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to save item revision")
+		}
+	}
+	if it.Deleted() {
+		if err := r.removeItemRevisions(it.Hash); err != nil {
+			r.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to remove item revisions")
+		}
+	}
 	err = it.FromActivityPub(ob)
 	if err != nil {
 		r.errFn()(err.Error())
 		return it, err
 	}
+	if check.Held() {
+		if !wasHeld {
+			if err := r.holdContent(heldItem, it.Hash, ob.GetLink(), check); err != nil {
+				r.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to hold item for moderation")
+			}
+		}
+		it.Hold()
+	}
+	it = r.loadItemsHeld(it)[0]
 	if loadAuthors {
-		items, err := r.loadItemsAuthors(ctx, it)
-		return items[0], err
+		var items ItemCollection
+		if items, err = r.loadItemsAuthors(ctx, it); len(items) > 0 {
+			it = items[0]
+		}
+	}
+	if (isNew || approved) && !it.IsHeld() {
+		// NOTE(marius): the held items are visible only to their authors, so we notify about them
+		//   only once a moderator approves them
+		r.notifyItem(ctx, it, parent, mentioned)
 	}
 	return it, err
 }
@@ -1870,6 +2014,9 @@ func (r *repository) LoadAccountDetails(ctx context.Context, acc *Account) error
 	if err = r.loadAccountsOutbox(ctx, acc); err != nil {
 		r.infoFn(ltx, log.Ctx{"err": err.Error()})("unable to load outbox")
 	}
+	if err = r.loadAccountHiddenItems(acc); err != nil {
+		r.infoFn(ltx, log.Ctx{"err": err.Error()})("unable to load hidden items")
+	}
 	return nil
 }
 
@@ -1963,6 +2110,11 @@ func (r *repository) SendFollowResponse(ctx context.Context, f FollowRequest, ac
 		})("unable to respond to follow")
 		return err
 	}
+	typ := NotificationFollowRejected
+	if accept {
+		typ = NotificationFollowAccepted
+	}
+	r.Notify(er, accountNotification(typ, *ed))
 	return nil
 }
 
@@ -1998,10 +2150,24 @@ func (r *repository) FollowAccount(ctx context.Context, er, ed Account, reason *
 		})("Unable to follow")
 		return err
 	}
+	r.Notify(&ed, accountNotification(NotificationFollow, er))
 	return nil
 }
 
 func (r *repository) SaveAccount(ctx context.Context, a Account) (Account, error) {
+	check := checkAllowed
+	if !a.Deleted() {
+		if check = r.checks.CheckAccount(ctx, a); !check.Allowed() {
+			r.infoFn(log.Ctx{"check": check.Check, "reason": check.Reason, "handle": a.Handle})("account rejected")
+			return a, errors.Forbiddenf("the account was rejected, it %s", check.Reason)
+		}
+	}
+	return r.saveAccount(ctx, a, check)
+}
+
+// saveAccount publishes the a account with the outcome of the content checks already known.
+// The held accounts are not public until a moderator approves them.
+func (r *repository) saveAccount(ctx context.Context, a Account, check CheckResult) (Account, error) {
 	p := r.loadAPPerson(a)
 	id := p.GetLink()
 
@@ -2013,8 +2179,12 @@ func (r *repository) SaveAccount(ctx context.Context, a Account) (Account, error
 	p.Updated = now
 
 	fedbox := r.fedbox.Service()
+	to := pub.ItemCollection{pub.PublicNS}
+	if check.Held() {
+		to = make(pub.ItemCollection, 0)
+	}
 	act := &pub.Activity{
-		To:      pub.ItemCollection{pub.PublicNS},
+		To:      to,
 		BCC:     pub.ItemCollection{fedbox.ID},
 		Updated: now,
 	}
@@ -2040,7 +2210,7 @@ func (r *repository) SaveAccount(ctx context.Context, a Account) (Account, error
 		act.Object = id
 	} else {
 		act.Object = p
-		p.To = pub.ItemCollection{pub.PublicNS}
+		p.To = to
 		p.BCC = pub.ItemCollection{fedbox.ID}
 		if len(id) == 0 {
 			act.Type = pub.CreateType
@@ -2062,6 +2232,11 @@ func (r *repository) SaveAccount(ctx context.Context, a Account) (Account, error
 	if err := a.FromActivityPub(ap); err != nil {
 		r.errFn(ltx, log.Ctx{"err": err})("loading of actor from JSON failed")
 	}
+	if check.Held() {
+		if err := r.holdContent(heldAccount, a.Hash, ap.GetLink(), check); err != nil {
+			r.errFn(ltx, log.Ctx{"err": err})("unable to hold account for moderation")
+		}
+	}
 	return a, nil
 }
 
@@ -2229,6 +2404,107 @@ func (r *repository) ReportItem(ctx context.Context, er Account, it Item, reason
 	return nil
 }
 
+// ModerateDeleteItem removes the it item on behalf of the mod moderator
+func (r *repository) ModerateDeleteItem(ctx context.Context, mod Account, it Item, reason *Item) error {
+	del, err := r.moderationActivityOnItem(ctx, mod, it, reason)
+	if err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	del.Type = pub.DeleteType
+	if _, _, err = r.fedbox.ToOutbox(ctx, del); err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	if err = r.removeItemRevisions(it.Hash); err != nil {
+		r.errFn(log.Ctx{"err": err, "hash": it.Hash})("unable to remove item revisions")
+	}
+	r.Notify(it.SubmittedBy, moderationNotification("deleted", it))
+	return nil
+}
+
+// LoadModerationOp loads the moderation activity at iri together with its actor and object
+func (r *repository) LoadModerationOp(ctx context.Context, iri pub.IRI) (ModerationOp, error) {
+	m := ModerationOp{}
+	act, err := r.fedbox.Activity(ctx, iri)
+	if err != nil {
+		return m, err
+	}
+	if err = m.FromActivityPub(act); err != nil {
+		return m, err
+	}
+	if m.Object == nil && act.Object != nil {
+		// NOTE(marius): the activities we receive from FedBOX have their objects flattened to IRIs
+		ob, err := r.fedbox.object(ctx, act.Object.GetLink())
+		if err != nil {
+			return m, errors.NewNotFound(err, "unable to load moderation object")
+		}
+		m.Object = loadItemActorOrActivityFromModerationActivityObject(ob)
+	}
+	ops, err := r.loadModerationDetails(ctx, m)
+	if err != nil || len(ops) == 0 {
+		return m, err
+	}
+	return ops[0], nil
+}
+
+// ResolveReport creates the moderation activity corresponding to the res resolution of the report.
+// The activity is marked as a reply to the report, so it shows as its followup in the moderation log.
+func (r *repository) ResolveReport(ctx context.Context, mod Account, report ModerationOp, res ReportResolution, reason *Item) error {
+	if !report.IsReport() || report.AP() == nil {
+		return errors.BadRequestf("invalid report")
+	}
+	if report.Object == nil || report.Object.AP() == nil {
+		return errors.NotFoundf("reported object not found")
+	}
+	if !accountValidForC2S(&mod) {
+		return errors.Unauthorizedf("invalid account %s", mod.Handle)
+	}
+	moderator := r.loadAPPerson(mod)
+	act, err := r.moderationActivity(ctx, moderator, report.Object.AP(), reason)
+	if err != nil {
+		return err
+	}
+	act.InReplyTo = report.AP().GetLink()
+	switch res {
+	case ResolutionDismiss:
+		act.Type = pub.RejectType
+		act.Object = report.AP().GetLink()
+	case ResolutionDelete:
+		if report.Object.Type() != CommentType {
+			return errors.BadRequestf("only items can be deleted")
+		}
+		act.Type = pub.DeleteType
+	case ResolutionBlock:
+		act.Type = pub.BlockType
+		if it, ok := report.Object.(*Item); ok {
+			// NOTE(marius): when the report is about an item, we block its author
+			if !it.SubmittedBy.IsValid() || it.SubmittedBy.pub == nil {
+				return errors.NotFoundf("unable to find the author of the reported item")
+			}
+			act.Object = it.SubmittedBy.pub.GetLink()
+		}
+	case ResolutionEscalate:
+		// TODO(marius): we need to address this to the instance administrators
+		act.Type = pub.FlagType
+	default:
+		return errors.BadRequestf("invalid report resolution %q", res)
+	}
+	if _, _, err = r.fedbox.ToOutbox(ctx, act); err != nil {
+		r.errFn()(err.Error())
+		return err
+	}
+	if it, ok := report.Object.(*Item); ok {
+		switch res {
+		case ResolutionDelete:
+			r.Notify(it.SubmittedBy, moderationNotification("deleted", *it))
+		case ResolutionBlock:
+			r.Notify(it.SubmittedBy, moderationNotification("blocked you for", *it))
+		}
+	}
+	return nil
+}
+
 func (r *repository) ReportAccount(ctx context.Context, er, ed Account, reason *Item) error {
 	flag, err := r.moderationActivityOnAccount(ctx, er, ed, reason)
 	if err != nil {
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+const (
+	revisionsCollection = "revisions"
+	// MaxItemRevisions is the maximum number of previous versions we keep for an item
+	MaxItemRevisions = 100
+)
+
+// itemRevision is a previous version of an item, together with who replaced it and when
+type itemRevision struct {
+	Title    string    `json:"title,omitempty"`
+	Summary  string    `json:"summary,omitempty"`
+	Data     string    `json:"data,omitempty"`
+	MimeType string    `json:"mimeType,omitempty"`
+	By       string    `json:"by,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// itemsRevisions are the previous versions of the items, keyed by their hash, in chronological order.
+// NOTE(marius): we don't keep them in the Update activities, as those get federated, and the other
+// instances have no business knowing what an item looked like before being edited.
+type itemsRevisions map[string][]itemRevision
+
+// revisionFromAPObject loads the content of the prev object into a revision
+func revisionFromAPObject(prev pub.Item) *itemRevision {
+	var rev *itemRevision
+	pub.OnObject(prev, func(o *pub.Object) error {
+		rev = &itemRevision{
+			Title:    o.Name.First().Value.String(),
+			Summary:  o.Summary.First().Value.String(),
+			Data:     o.Content.First().Value.String(),
+			MimeType: string(o.MediaType),
+		}
+		if len(o.Source.Content) > 0 && len(o.Source.MediaType) > 0 {
+			rev.Data = o.Source.Content.First().Value.String()
+			rev.MimeType = string(o.Source.MediaType)
+		}
+		return nil
+	})
+	return rev
+}
+
+// sameContent returns true if the it item has the same content as the rev revision
+func (rev itemRevision) sameContent(it Item) bool {
+	return rev.Title == it.Title && rev.Summary == it.Summary && rev.Data == it.Data
+}
+
+// addItemRevision records rev as the latest previous version of the item with hash h
+func (r *repository) addItemRevision(h Hash, rev itemRevision) error {
+	revisions := make(itemsRevisions)
+	return r.store.Update(revisionsCollection, &revisions, func() error {
+		revs := append(revisions[h.String()], rev)
+		if len(revs) > MaxItemRevisions {
+			revs = revs[len(revs)-MaxItemRevisions:]
+		}
+		revisions[h.String()] = revs
+		return nil
+	})
+}
+
+// removeItemRevisions removes the previous versions of the item with hash h
+func (r *repository) removeItemRevisions(h Hash) error {
+	revisions := make(itemsRevisions)
+	return r.store.Update(revisionsCollection, &revisions, func() error {
+		delete(revisions, h.String())
+		return nil
+	})
+}
+
+// LoadItemRevisions loads the previous versions of an item from the local storage.
+// The revisions are returned in chronological order with the current version of the item as the last one.
+func (r *repository) LoadItemRevisions(ctx context.Context, it Item) (ItemCollection, error) {
+	if !it.IsValid() {
+		return nil, errors.NotFoundf("invalid item")
+	}
+	all := make(itemsRevisions)
+	if err := r.store.Load(revisionsCollection, &all); err != nil {
+		return nil, err
+	}
+
+	revisions := make(ItemCollection, 0)
+	updatedAt := it.SubmittedAt
+	for _, prev := range all[it.Hash.String()] {
+		rev := Item{
+			Hash:        it.Hash,
+			SubmittedAt: it.SubmittedAt,
+			SubmittedBy: it.SubmittedBy,
+			UpdatedAt:   updatedAt,
+			Metadata:    &ItemMetadata{},
+			Title:       prev.Title,
+			Summary:     prev.Summary,
+			Data:        prev.Data,
+			MimeType:    MimeTypeHTML,
+		}
+		if len(prev.MimeType) > 0 {
+			rev.MimeType = prev.MimeType
+		}
+		if it.IsLink() && len(rev.Data) == 0 {
+			rev.Data = it.Data
+			rev.MimeType = it.MimeType
+		}
+		if len(prev.By) > 0 {
+			by := Account{Metadata: &AccountMetadata{}}
+			by.FromActivityPub(pub.IRI(prev.By))
+			rev.UpdatedBy = &by
+		}
+		revisions = append(revisions, rev)
+		updatedAt = prev.At
+	}
+	revisions = append(revisions, it)
+	return revisions, nil
+}
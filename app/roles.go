@@ -0,0 +1,282 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+// Role is the type of elevated rights an account can have on the instance
+type Role string
+
+const (
+	// RoleAdmin can moderate all content and can manage the roles of other accounts
+	RoleAdmin = Role("admin")
+	// RoleModerator can moderate all content
+	RoleModerator = Role("moderator")
+	// RoleTagModerator can moderate the content tagged with the tag in the role's scope
+	RoleTagModerator = Role("tag-moderator")
+)
+
+// ValidRoles are the roles that can be given to an account
+var ValidRoles = []Role{RoleAdmin, RoleModerator, RoleTagModerator}
+
+const rolesCollection = "roles"
+
+// AccountRole represents a role given to an account.
+// The roles are not part of the ActivityPub vocabulary, so we keep them in the instance's local storage.
+type AccountRole struct {
+	Role    Role      `json:"role"`
+	Scope   string    `json:"scope,omitempty"`
+	Account string    `json:"account"`
+	Handle  string    `json:"handle,omitempty"`
+	AddedBy string    `json:"addedBy,omitempty"`
+	AddedAt time.Time `json:"addedAt,omitempty"`
+}
+
+type AccountRoles []AccountRole
+
+func validRole(r Role) bool {
+	for _, v := range ValidRoles {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
+
+func roleScope(s string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(s), "#"))
+}
+
+// accountRoleID returns the identifier we use for an account in the roles storage
+func accountRoleID(a Account) string {
+	if a.HasMetadata() && len(a.Metadata.ID) > 0 {
+		return a.Metadata.ID
+	}
+	return a.Hash.String()
+}
+
+// IsValid returns true if the role is known and it has the scope it requires
+func (r AccountRole) IsValid() bool {
+	if len(r.Account) == 0 || !validRole(r.Role) {
+		return false
+	}
+	return r.Role != RoleTagModerator || len(r.Scope) > 0
+}
+
+// Equals returns true if o is the same role, for the same scope and account, as r
+func (r AccountRole) Equals(o AccountRole) bool {
+	return r.Account == o.Account && r.Role == o.Role && roleScope(r.Scope) == roleScope(o.Scope)
+}
+
+// Contains returns true if the collection contains the role, regardless of its scope
+func (rr AccountRoles) Contains(role Role) bool {
+	for _, r := range rr {
+		if r.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsScope returns true if the collection contains the role for scope
+func (rr AccountRoles) ContainsScope(role Role, scope string) bool {
+	scope = roleScope(scope)
+	for _, r := range rr {
+		if r.Role == role && roleScope(r.Scope) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ForAccount returns the roles belonging to the a account
+func (rr AccountRoles) ForAccount(a Account) AccountRoles {
+	result := make(AccountRoles, 0)
+	id := accountRoleID(a)
+	for _, r := range rr {
+		if r.Account == id {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// IsAdmin returns true if the account is an administrator of the instance
+func (a *Account) IsAdmin() bool {
+	return a.IsLogged() && a.Roles.Contains(RoleAdmin)
+}
+
+// IsModerator returns true if the account can moderate all the content on the instance
+func (a *Account) IsModerator() bool {
+	return a.IsLogged() && (a.Roles.Contains(RoleAdmin) || a.Roles.Contains(RoleModerator))
+}
+
+// HasModerationRole returns true if the account can moderate at least some of the content on the instance
+func (a *Account) HasModerationRole() bool {
+	return a.IsLogged() && len(a.Roles) > 0
+}
+
+// CanModerate returns true if the account is allowed to take moderation actions on r
+func (a *Account) CanModerate(r Renderable) bool {
+	if !a.HasModerationRole() || r == nil {
+		return false
+	}
+	if a.IsModerator() {
+		return true
+	}
+	it, ok := r.(*Item)
+	if !ok || !it.HasMetadata() {
+		// NOTE(marius): only global moderators can take action on accounts
+		return false
+	}
+	tags := make(TagCollection, 0)
+	tags = append(tags, it.Metadata.Tags...)
+	if it.OP != nil && it.OP.HasMetadata() {
+		// NOTE(marius): comments belong to the same community as their thread
+		tags = append(tags, it.OP.Metadata.Tags...)
+	}
+	for _, t := range tags {
+		if a.Roles.ContainsScope(RoleTagModerator, t.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRoles loads all the roles given on the instance
+func (r *repository) LoadRoles(ctx context.Context) (AccountRoles, error) {
+	roles := make(AccountRoles, 0)
+	err := r.store.Load(rolesCollection, &roles)
+	return roles, err
+}
+
+// LoadAccountRoles loads the roles of the a account.
+// The accounts configured as administrators receive the admin role even if it was not saved.
+func (r *repository) LoadAccountRoles(ctx context.Context, a Account) (AccountRoles, error) {
+	roles, err := r.LoadRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := roles.ForAccount(a)
+	if Instance.Conf != nil && a.IsLocal() && stringInSlice(Instance.Conf.Admins)(a.Handle) && !result.Contains(RoleAdmin) {
+		result = append(result, AccountRole{
+			Role:    RoleAdmin,
+			Account: accountRoleID(a),
+			Handle:  a.Handle,
+		})
+	}
+	return result, nil
+}
+
+// AddRole gives the role to the ed account
+func (r *repository) AddRole(ctx context.Context, er, ed Account, role Role, scope string) error {
+	if !ed.IsValid() || !ed.IsLocal() {
+		return errors.BadRequestf("roles can only be given to local accounts")
+	}
+	ar := AccountRole{
+		Role:    role,
+		Scope:   roleScope(scope),
+		Account: accountRoleID(ed),
+		Handle:  ed.Handle,
+		AddedBy: er.Handle,
+		AddedAt: time.Now().UTC(),
+	}
+	if ar.Role != RoleTagModerator {
+		ar.Scope = ""
+	}
+	if !ar.IsValid() {
+		return errors.BadRequestf("invalid role %q", role)
+	}
+	roles := make(AccountRoles, 0)
+	return r.store.Update(rolesCollection, &roles, func() error {
+		for _, ex := range roles {
+			if ex.Equals(ar) {
+				return errors.BadRequestf("%s already has the %s role", ed.Handle, role)
+			}
+		}
+		roles = append(roles, ar)
+		return nil
+	})
+}
+
+// RemoveRole removes the role from the account it was given to
+func (r *repository) RemoveRole(ctx context.Context, role AccountRole) error {
+	roles := make(AccountRoles, 0)
+	return r.store.Update(rolesCollection, &roles, func() error {
+		for i, ex := range roles {
+			if ex.Equals(role) {
+				roles = append(roles[:i], roles[i+1:]...)
+				return nil
+			}
+		}
+		return errors.NotFoundf("role not found")
+	})
+}
+
+// HandleRoles serves /moderation/roles request
+func (h *handler) HandleRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.storage.LoadRoles(context.TODO())
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to load roles")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &rolesModel{
+		Title:      "Roles",
+		Roles:      roles,
+		ValidRoles: ValidRoles,
+		Admins:     h.conf.Admins,
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleAddRole serves /moderation/roles POST request
+func (h *handler) HandleAddRole(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	ctx := context.TODO()
+	handle := strings.TrimPrefix(strings.TrimSpace(r.PostFormValue("handle")), "@")
+	role := Role(r.PostFormValue("role"))
+
+	f := new(Filters)
+	f.Name = CompStrs{EqualsString(handle)}
+	f.Type = ActivityTypesFilter(ValidActorTypes...)
+	ed, err := h.storage.account(ctx, f)
+	if err != nil {
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to find account %s", handle))
+		h.v.Redirect(w, r, "/moderation/roles", http.StatusSeeOther)
+		return
+	}
+	if err = h.storage.AddRole(ctx, *acc, ed, role, r.PostFormValue("scope")); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": handle, "role": role})("unable to add role")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to add role: %s", err))
+	} else {
+		h.infoFn(log.Ctx{"handle": handle, "role": role, "by": acc.Handle})("role added")
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Added %s role to %s", role, ed.Handle))
+	}
+	h.v.Redirect(w, r, "/moderation/roles", http.StatusSeeOther)
+}
+
+// HandleRemoveRole serves /moderation/roles/rm POST request
+func (h *handler) HandleRemoveRole(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	role := AccountRole{
+		Account: r.PostFormValue("account"),
+		Role:    Role(r.PostFormValue("role")),
+		Scope:   r.PostFormValue("scope"),
+	}
+	if err := h.storage.RemoveRole(context.TODO(), role); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "account": role.Account, "role": role.Role})("unable to remove role")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to remove role: %s", err))
+	} else {
+		h.infoFn(log.Ctx{"account": role.Account, "role": role.Role, "by": acc.Handle})("role removed")
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Removed %s role", role.Role))
+	}
+	h.v.Redirect(w, r, "/moderation/roles", http.StatusSeeOther)
+}
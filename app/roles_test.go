@@ -0,0 +1,84 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccount_CanModerate(t *testing.T) {
+	taggedItem := func(tags ...string) *Item {
+		it := &Item{Metadata: &ItemMetadata{}}
+		for _, tag := range tags {
+			it.Metadata.Tags = append(it.Metadata.Tags, Tag{Type: TagTag, Name: tag})
+		}
+		return it
+	}
+	withRoles := func(roles ...AccountRole) *Account {
+		return &Account{Handle: "jane", CreatedAt: time.Now(), Roles: roles}
+	}
+	comment := taggedItem()
+	comment.OP = taggedItem("golang")
+
+	tests := []struct {
+		name string
+		acc  *Account
+		r    Renderable
+		want bool
+	}{
+		{
+			name: "no roles",
+			acc:  withRoles(),
+			r:    taggedItem("golang"),
+			want: false,
+		},
+		{
+			name: "anonymous admin",
+			acc:  &Account{Handle: Anonymous, Hash: AnonymousHash, Roles: AccountRoles{{Role: RoleAdmin}}},
+			r:    taggedItem(),
+			want: false,
+		},
+		{
+			name: "admin",
+			acc:  withRoles(AccountRole{Role: RoleAdmin}),
+			r:    taggedItem(),
+			want: true,
+		},
+		{
+			name: "moderator on account",
+			acc:  withRoles(AccountRole{Role: RoleModerator}),
+			r:    &Account{Handle: "john"},
+			want: true,
+		},
+		{
+			name: "tag moderator on account",
+			acc:  withRoles(AccountRole{Role: RoleTagModerator, Scope: "golang"}),
+			r:    &Account{Handle: "john"},
+			want: false,
+		},
+		{
+			name: "tag moderator on tagged item",
+			acc:  withRoles(AccountRole{Role: RoleTagModerator, Scope: "golang"}),
+			r:    taggedItem("#GoLang"),
+			want: true,
+		},
+		{
+			name: "tag moderator on other tag",
+			acc:  withRoles(AccountRole{Role: RoleTagModerator, Scope: "golang"}),
+			r:    taggedItem("rust"),
+			want: false,
+		},
+		{
+			name: "tag moderator on comment in tagged thread",
+			acc:  withRoles(AccountRole{Role: RoleTagModerator, Scope: "golang"}),
+			r:    comment,
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.acc.CanModerate(tt.r); got != tt.want {
+				t.Errorf("CanModerate() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
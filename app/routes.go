@@ -15,23 +15,33 @@ func (h *handler) ItemRoutes () func(chi.Router) {
 	return func(r chi.Router) {
 		r.Use(h.CSRF, ContentModelMw, h.ItemFiltersMw, LoadObjectFromInboxMw, ThreadedListingMw, SortByScore)
 		r.Get("/", h.HandleShow)
-		r.With(h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/", h.HandleSubmit)
+		r.With(h.ValidateLoggedIn(h.v.RedirectToErrors), h.RateLimit(rateSubmit)).Post("/", h.HandleSubmit)
 
 		r.Group(func(r chi.Router) {
 			r.Use(h.ValidateLoggedIn(h.v.RedirectToErrors))
-			r.Get("/yay", h.HandleVoting)
-			r.Get("/nay", h.HandleVoting)
+			r.With(h.RateLimit(rateVote)).Get("/yay", h.HandleVoting)
+			r.With(h.RateLimit(rateVote)).Get("/nay", h.HandleVoting)
 
 			//r.Get("/bad", h.ShowReport)
 			r.With(ReportContentModelMw).Get("/bad", h.HandleShow)
-			r.Post("/bad", h.ReportItem)
+			r.With(h.RateLimit(rateReport)).Post("/bad", h.ReportItem)
 			r.With(BlockContentModelMw).Get("/block", h.HandleShow)
 			r.Post("/block", h.BlockItem)
 
+			r.With(h.ValidateItemAuthorOrModerator("see the history of")).Get("/history", h.HandleItemHistory)
+
 			r.Group(func(r chi.Router) {
+				r.Get("/save", h.HandleSaveItem)
+				r.Get("/unsave", h.HandleSaveItem)
+				r.Get("/hide", h.HandleHideItem)
+				r.Get("/unhide", h.HandleHideItem)
 				r.With(h.ValidateItemAuthor("edit"), EditContentModelMw).Get("/edit", h.HandleShow)
-				r.With(h.ValidateItemAuthor("edit")).Post("/edit", h.HandleSubmit)
-				r.With(h.ValidateItemAuthor("delete")).Get("/rm", h.HandleDelete)
+				r.With(h.ValidateItemAuthor("edit"), h.RateLimit(rateSubmit)).Post("/edit", h.HandleSubmit)
+				r.With(h.ValidateItemAuthorOrModerator("delete")).Get("/rm", h.HandleDelete)
+				r.With(h.ValidateItemModerator("lock")).Get("/lock", h.HandleLock)
+				r.With(h.ValidateItemModerator("unlock")).Get("/unlock", h.HandleLock)
+				r.With(h.ValidateItemModerator("pin")).Get("/pin", h.HandlePin)
+				r.With(h.ValidateItemModerator("unpin")).Get("/unpin", h.HandlePin)
 			})
 		})
 	}
@@ -44,46 +54,71 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 		workDir, _ := os.Getwd()
 		assetsDir := filepath.Join(workDir, "assets")
 		h.v.assets = assets.AssetFiles{
-			"moderate.css":     []string{"main.css", "listing.css", "article.css", "moderate.css", "user.css"},
-			"content.css":      []string{"main.css", "article.css", "content.css"},
-			"listing.css":      []string{"main.css", "listing.css", "article.css", "moderate.css"},
-			"moderation.css":   []string{"main.css", "listing.css", "article.css", "moderation.css"},
-			"user.css":         []string{"main.css", "listing.css", "article.css", "user.css"},
-			"user-message.css": []string{"main.css", "listing.css", "article.css", "user-message.css"},
-			"new.css":          []string{"main.css", "listing.css", "article.css"},
-			"404.css":          []string{"main.css", "error.css"},
-			"about.css":        []string{"main.css", "about.css"},
-			"error.css":        []string{"main.css", "error.css"},
-			"login.css":        []string{"main.css", "login.css"},
-			"register.css":     []string{"main.css", "login.css"},
-			"inline.css":       []string{"inline.css"},
-			"main.js":          []string{"base.js", "main.js"},
+			"moderate.css":        []string{"main.css", "listing.css", "article.css", "moderate.css", "user.css"},
+			"content.css":         []string{"main.css", "article.css", "content.css"},
+			"history.css":         []string{"main.css", "article.css", "content.css", "history.css"},
+			"listing.css":         []string{"main.css", "listing.css", "article.css", "moderate.css"},
+			"moderation.css":      []string{"main.css", "listing.css", "article.css", "moderation.css"},
+			"reports.css":         []string{"main.css", "listing.css", "article.css", "moderation.css"},
+			"roles.css":           []string{"main.css", "moderation.css"},
+			"transparency.css":    []string{"main.css", "moderation.css"},
+			"user.css":            []string{"main.css", "listing.css", "article.css", "user.css"},
+			"invitees.css":        []string{"main.css", "moderation.css", "user.css"},
+			"invitations.css":     []string{"main.css", "user.css"},
+			"settings.css":        []string{"main.css", "user.css"},
+			"user-message.css":    []string{"main.css", "listing.css", "article.css", "user-message.css"},
+			"new.css":             []string{"main.css", "listing.css", "article.css"},
+			"404.css":             []string{"main.css", "error.css"},
+			"about.css":           []string{"main.css", "about.css"},
+			"error.css":           []string{"main.css", "error.css"},
+			"login.css":           []string{"main.css", "login.css"},
+			"register.css":        []string{"main.css", "login.css"},
+			"forgot-password.css": []string{"main.css", "login.css"},
+			"password-reset.css":  []string{"main.css", "login.css"},
+			"login-2fa.css":       []string{"main.css", "login.css"},
+			"two-factor.css":      []string{"main.css", "user.css"},
+			"delete-account.css":  []string{"main.css", "user.css"},
+			"move-account.css":    []string{"main.css", "user.css"},
+			"access-tokens.css":   []string{"main.css", "user.css"},
+			"notifications.css":   []string{"main.css", "user.css"},
+			"saved.css":           []string{"main.css", "listing.css", "article.css", "user.css"},
+			"hidden.css":          []string{"main.css", "listing.css", "article.css", "user.css"},
+			"inline.css":          []string{"inline.css"},
+			"main.js":             []string{"base.js", "main.js"},
 		}
 
 		r.Group(func(r chi.Router) {
 			//r.Use(middleware.Timeout(60 * time.Millisecond))
 			r.Use(h.SetSecurityHeaders)
+			r.Use(h.LimitRequestBodySize)
 			r.Use(h.LoadSession)
 			r.Use(h.OutOfOrderMw)
 
 			r.With(h.CSRF).Group(func(r chi.Router) {
-				r.With(AddModelMw).Get("/submit", h.HandleShow)
-				r.Post("/submit", h.HandleSubmit)
+				r.With(AddModelMw, LoadLinkDuplicatesMw).Get("/submit", h.HandleShow)
+				r.With(h.RateLimit(rateSubmit)).Post("/submit", h.HandleSubmit)
 				r.With(c.CheckUserCreatingEnabled).Route("/register", func(r chi.Router) {
 					r.Group(func(r chi.Router) {
 						r.With(ModelMw(&registerModel{Title: "Register new account"})).Get("/", h.HandleShow)
 						r.With(ModelMw(&registerModel{Title: "Register account from invite"}), LoadInvitedMw).Get("/{hash}", h.HandleShow)
 					})
-					r.Post("/", h.HandleRegister)
+					r.With(h.RateLimit(rateRegister)).Post("/", h.HandleRegister)
 				})
 				r.With(h.NeedsSessions).Group(func(r chi.Router) {
 					r.With(ModelMw(&loginModel{Title: "Local authentication"})).Get("/login", h.HandleShow)
 					r.Post("/login", h.HandleLogin)
+					r.Get("/login/2fa", h.HandleTwoFactorLoginForm)
+					r.With(h.RateLimit(rateTwoFactor)).Post("/login/2fa", h.HandleTwoFactorLogin)
+					r.With(ModelMw(&forgotPasswordModel{Title: "Forgot password"})).Get("/forgot-password", h.HandleShow)
+					r.With(h.RateLimit(ratePassword)).Post("/forgot-password", h.HandleForgotPassword)
+					r.Get("/reset-password/{token}", h.HandleResetPasswordForm)
+					r.With(h.RateLimit(ratePassword)).Post("/reset-password/{token}", h.HandleResetPassword)
+					r.Get("/confirm-email/{token}", h.HandleConfirmEmail)
 				})
 			})
 
 			r.With(h.LoadAuthorMw).Route("/~{handle}", func(r chi.Router) {
-				r.With(AccountListingModelMw, AccountFiltersMw, LoadOutboxMw).Get("/", h.HandleShow)
+				r.With(h.RedirectMovedMw, h.CSRF, AccountListingModelMw, AccountFiltersMw, LoadOutboxMw).Get("/", h.HandleShow)
 
 				r.Group(func(r chi.Router) {
 					r.Use(h.ValidateLoggedIn(h.v.RedirectToErrors))
@@ -92,14 +127,43 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 
 					r.With(h.CSRF, MessageUserContentModelMw, MessageFiltersMw, LoadOutboxMw).Route("/message", func(r chi.Router) {
 						r.Get("/", h.HandleShow)
-						r.Post("/", h.HandleSubmit)
+						r.With(h.RateLimit(rateSubmit)).Post("/", h.HandleSubmit)
 					})
 
 					r.With(h.CSRF, MessageUserContentModelMw, AccountFiltersMw, LoadOutboxMw).Group(func(r chi.Router) {
 						r.With(BlockAccountModelMw).Get("/block", h.HandleShow)
 						r.Post("/block", h.BlockAccount)
 						r.With(ReportAccountModelMw).Get("/bad", h.HandleShow)
-						r.Post("/bad", h.ReportAccount)
+						r.With(h.RateLimit(rateReport)).Post("/bad", h.ReportAccount)
+					})
+
+					r.With(h.CSRF).Post("/mutes", h.HandleMutes)
+					r.With(h.CSRF).Route("/saved", func(r chi.Router) {
+						r.Get("/", h.HandleSaved)
+						r.Get("/{tag}", h.HandleSaved)
+						r.Post("/", h.HandleSaveTags)
+					})
+					r.Get("/hidden", h.HandleHiddenItems)
+					r.With(h.CSRF).Route("/settings", func(r chi.Router) {
+						r.Get("/", h.HandleSettings)
+						r.Post("/", h.HandleSaveSettings)
+						r.With(h.RateLimit(ratePassword)).Post("/password", h.HandleChangePassword)
+						r.With(h.RateLimit(ratePassword)).Post("/email", h.HandleChangeEmail)
+						r.Get("/2fa", h.HandleTwoFactor)
+						r.With(h.RateLimit(rateTwoFactor)).Post("/2fa", h.HandleSaveTwoFactor)
+						r.Get("/tokens", h.HandleAccessTokens)
+						r.Post("/tokens", h.HandleSaveAccessTokens)
+						r.Get("/export", h.HandleExport)
+						r.Get("/delete", h.HandleDeleteAccountForm)
+						r.With(h.RateLimit(ratePassword)).Post("/delete", h.HandleDeleteAccount)
+						r.Get("/move", h.HandleMoveAccountForm)
+						r.With(h.RateLimit(ratePassword)).Post("/move", h.HandleMoveAccount)
+					})
+
+					r.With(h.ValidateAdmin(h.v.RedirectToErrors), h.CSRF).Post("/2fa/reset", h.HandleResetTwoFactor)
+					r.With(h.ValidateModerator(h.v.RedirectToErrors), h.CSRF).Route("/invitees", func(r chi.Router) {
+						r.Get("/", h.HandleInvitees)
+						r.Post("/", h.HandleInviteesAction)
 					})
 				})
 
@@ -111,27 +175,53 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 			r.Get("/i/{hash}", h.HandleItemRedirect)
 
 			r.With(h.NeedsSessions).Get("/logout", h.HandleLogout)
-			r.With(h.NeedsSessions, h.ValidateLoggedIn(h.v.RedirectToErrors)).Post("/invite", h.HandleSendInvite)
+			r.With(h.NeedsSessions, h.ValidateLoggedIn(h.v.RedirectToErrors), h.CSRF, h.RateLimit(rateInvite)).Post("/invite", h.HandleSendInvite)
+			r.With(h.ValidateLoggedIn(h.v.RedirectToErrors), h.CSRF).Route("/notifications", func(r chi.Router) {
+				r.Get("/", h.HandleNotifications)
+				r.Post("/", h.HandleReadNotifications)
+			})
+			r.With(h.NeedsSessions, h.ValidateLoggedIn(h.v.RedirectToErrors), h.CSRF).Route("/invites", func(r chi.Router) {
+				r.Get("/", h.HandleInvitations)
+				r.Post("/revoke", h.HandleRevokeInvite)
+			})
 
 			r.With(ListingModelMw).Group(func(r chi.Router) {
 				// @todo(marius) :link_generation:
-				r.With(DefaultFilters, LoadServiceInboxMw, SortByScore).Get("/", h.HandleShow)
+				r.With(DefaultFilters, LoadServiceInboxMw, HideMutedMw, SortByScore, PinnedItemsMw).Get("/", h.HandleShow)
 				r.With(DomainFiltersMw, LoadServiceInboxMw, middleware.StripSlashes, SortByDate).Get("/d", h.HandleShow)
 				r.With(DomainFiltersMw, LoadServiceInboxMw, SortByDate).Get("/d/{domain}", h.HandleShow)
-				r.With(TagFiltersMw, LoadServiceInboxMw, ModerationListing, SortByDate).Get("/t/{tag}", h.HandleShow)
-				r.With(SelfFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, SortByScore).Get("/self", h.HandleShow)
-				r.With(FederatedFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, SortByScore).Get("/federated", h.HandleShow)
-				r.With(h.NeedsSessions, FollowedFiltersMw, h.ValidateLoggedIn(h.v.RedirectToErrors), LoadInboxMw, SortByDate).
+				r.With(TagFiltersMw, LoadServiceInboxMw, ModerationListing, SortByDate, PinnedItemsMw).Get("/t/{tag}", h.HandleShow)
+				r.With(SelfFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, HideMutedMw, SortByScore).Get("/self", h.HandleShow)
+				r.With(FederatedFiltersMw(h.storage.fedbox.Service().ID), LoadServiceInboxMw, HideMutedMw, SortByScore).Get("/federated", h.HandleShow)
+				r.With(h.NeedsSessions, FollowedFiltersMw, h.ValidateLoggedIn(h.v.RedirectToErrors), LoadInboxMw, HideMutedMw, SortByDate).
 					Get("/followed", h.HandleShow)
 				r.With(ModelMw(&listingModel{tpl: "moderation", sortFn: ByDate}), ModerationFiltersMw, LoadServiceInboxMw, ModerationListing).
 					Get("/moderation", h.HandleShow)
+				r.With(ModelMw(&listingModel{tpl: "moderation", sortFn: ByDate}), ModerationFiltersMw, LoadModerationExportMw).
+					Group(func(r chi.Router) {
+						r.Get("/moderation/log.{format}", h.HandleModerationExport)
+						r.Get("/moderation/transparency", h.HandleTransparency)
+					})
+				r.With(h.NeedsSessions, h.ValidateModerator(h.v.RedirectToErrors), h.CSRF).Route("/moderation/reports", func(r chi.Router) {
+					r.With(ModelMw(&listingModel{tpl: "reports", sortFn: ByDate}), ReportsFiltersMw, LoadServiceInboxMw, ModerationListing, OpenReportsMw, HeldContentMw).
+						Get("/", h.HandleShow)
+					r.Post("/{hash}", h.HandleResolveReport)
+				})
+				r.With(h.NeedsSessions, h.ValidateModerator(h.v.RedirectToErrors), h.CSRF).Post("/moderation/held/{hash}", h.HandleHeldContent)
 				r.With(ModelMw(&listingModel{tpl: "listing", sortFn: ByDate}), ActorsFiltersMw, LoadServiceInboxMw, ThreadedListingMw).
 					Get("/~", h.HandleShow)
 			})
 
+			r.With(h.NeedsSessions, h.ValidateAdmin(h.v.RedirectToErrors), h.CSRF).Route("/moderation/roles", func(r chi.Router) {
+				r.Get("/", h.HandleRoles)
+				r.Post("/", h.HandleAddRole)
+				r.Post("/rm", h.HandleRemoveRole)
+			})
+
 			r.Get("/about", h.HandleAbout)
 			r.Route("/auth", func(r chi.Router) {
 				r.Use(h.NeedsSessions)
+				r.Get("/{provider}", h.HandleAuth)
 				r.Get("/{provider}/callback", h.HandleCallback)
 			})
 
@@ -145,6 +235,8 @@ func (h *handler) Routes(c *config.Configuration) func(chi.Router) {
 
 		r.Group(func(r chi.Router) {
 			r.Get("/ns", assets.ServeStatic(filepath.Join(assetsDir, "/ns.json")))
+			r.Get("/media/{name}", h.HandleMedia)
+			r.Get("/manifest.webmanifest", h.HandleManifest)
 			r.Get("/favicon.ico", assets.ServeStatic(filepath.Join(assetsDir, "/favicon.ico")))
 			r.Get("/icons.svg", assets.ServeStatic(filepath.Join(assetsDir, "/icons.svg")))
 			r.Get("/robots.txt", assets.ServeStatic(filepath.Join(assetsDir, "/robots.txt")))
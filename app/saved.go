@@ -0,0 +1,377 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const savedCollection = "saved"
+
+const (
+	// MaxSavedItems is the number of items an account can save
+	MaxSavedItems = 1000
+	// MaxSavedTags is the number of tags a saved item can have
+	MaxSavedTags = 10
+
+	maxSavedTagLength = 32
+)
+
+// SavedItem is an item or comment that an account saved for later, with the tags it was given
+type SavedItem struct {
+	Hash    string    `json:"hash"`
+	IRI     string    `json:"iri"`
+	Tags    []string  `json:"tags,omitempty"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// SavedItems are the items an account saved, newest first
+type SavedItems []SavedItem
+
+// accountsSaved are the saved items of the accounts, keyed by the account's ID.
+// TODO(marius): the ActivityPub vocabulary doesn't have a bookmarks collection, so we keep them in the instance's
+//
+//	local storage. If FedBOX will allow custom collections on the actors we can move them there, so they're
+//	available to other clients.
+type accountsSaved map[string]SavedItems
+
+// Contains returns true if the item with the h hash was saved
+func (s SavedItems) Contains(h Hash) bool {
+	return s.index(h) >= 0
+}
+
+func (s SavedItems) index(h Hash) int {
+	for i, it := range s {
+		if it.Hash == h.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Tags returns the tags used on the saved items, in the order they were first used in
+func (s SavedItems) Tags() []string {
+	tags := make([]string, 0)
+	for i := len(s) - 1; i >= 0; i-- {
+		for _, t := range s[i].Tags {
+			if !stringInSlice(tags)(t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// withTag returns the saved items that have the tag, or all of them when tag is empty
+func (s SavedItems) withTag(tag string) SavedItems {
+	if len(tag) == 0 {
+		return s
+	}
+	result := make(SavedItems, 0)
+	for _, it := range s {
+		if stringInSlice(it.Tags)(tag) {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+func (s SavedItems) hashes() []string {
+	hashes := make([]string, len(s))
+	for i, it := range s {
+		hashes[i] = it.Hash
+	}
+	return hashes
+}
+
+// page returns at most max saved items that come after the after hash, or before the before hash,
+// together with the hashes to be used for loading the next and previous pages.
+func (s SavedItems) page(after, before Hash, max int) (SavedItems, Hash, Hash) {
+	start, end, next, prev := pageBounds(s.hashes(), after, before, max)
+	return s[start:end], next, prev
+}
+
+// pageBounds returns the bounds of the page of at most max hashes that come after the after hash, or before the
+// before hash, together with the hashes to be used for loading the next and previous pages.
+// It's used for paginating the lists of items we keep in the instance's local storage, using the same
+// after and before query parameters as the Cursor of the FedBOX collections.
+func pageBounds(hashes []string, after, before Hash, max int) (int, int, Hash, Hash) {
+	index := func(h Hash) int {
+		if h.IsValid() {
+			return stringIndex(hashes, h.String())
+		}
+		return -1
+	}
+	start := 0
+	if i := index(after); i >= 0 {
+		start = i + 1
+	} else if i := index(before); i >= 0 {
+		start = i - max
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + max
+	if end > len(hashes) {
+		end = len(hashes)
+	}
+	if start >= end {
+		return 0, 0, Hash{}, Hash{}
+	}
+	var next, prev Hash
+	if end < len(hashes) {
+		next = HashFromString(hashes[end-1])
+	}
+	if start > 0 {
+		prev = HashFromString(hashes[start])
+	}
+	return start, end, next, prev
+}
+
+func stringIndex(ss []string, s string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSavedTags loads the tags from a comma or space separated list.
+// The tags are lower cased and the ones that are not valid are ignored.
+func parseSavedTags(s string) []string {
+	tags := make([]string, 0)
+	for _, t := range strings.Fields(strings.Replace(s, ",", " ", -1)) {
+		t = strings.ToLower(strings.TrimLeft(t, "#"))
+		if len(t) == 0 || len(t) > maxSavedTagLength || stringInSlice(tags)(t) {
+			continue
+		}
+		tags = append(tags, t)
+		if len(tags) == MaxSavedTags {
+			break
+		}
+	}
+	return tags
+}
+
+// LoadSavedItems loads the items saved by the a account, newest first
+func (r *repository) LoadSavedItems(a Account) (SavedItems, error) {
+	v, err := r.store.LoadCached(savedCollection, func() interface{} {
+		return &accountsSaved{}
+	})
+	if err != nil {
+		return nil, err
+	}
+	saved := (*v.(*accountsSaved))[accountRoleID(a)]
+	if saved == nil {
+		return nil, nil
+	}
+	return append(make(SavedItems, 0, len(saved)), saved...), nil
+}
+
+// AddSavedItem adds the it item to the items saved by the a account. Saving it again keeps it in place.
+func (r *repository) AddSavedItem(a Account, it Item) error {
+	if !it.IsValid() || it.pub == nil {
+		return errors.NotFoundf("invalid item")
+	}
+	all := make(accountsSaved)
+	return r.store.Update(savedCollection, &all, func() error {
+		id := accountRoleID(a)
+		if all[id].Contains(it.Hash) {
+			return nil
+		}
+		if len(all[id]) >= MaxSavedItems {
+			return errors.BadRequestf("you can save at most %d items", MaxSavedItems)
+		}
+		s := SavedItem{Hash: it.Hash.String(), IRI: it.pub.GetLink().String(), SavedAt: time.Now().UTC()}
+		all[id] = append(SavedItems{s}, all[id]...)
+		return nil
+	})
+}
+
+// RemoveSavedItem removes the item with the h hash from the items saved by the a account
+func (r *repository) RemoveSavedItem(a Account, h Hash) error {
+	all := make(accountsSaved)
+	return r.store.Update(savedCollection, &all, func() error {
+		id := accountRoleID(a)
+		i := all[id].index(h)
+		if i < 0 {
+			return errors.NotFoundf("item is not saved")
+		}
+		all[id] = append(all[id][:i], all[id][i+1:]...)
+		return nil
+	})
+}
+
+// TagSavedItem replaces the tags of the item with the h hash saved by the a account
+func (r *repository) TagSavedItem(a Account, h Hash, tags []string) error {
+	all := make(accountsSaved)
+	return r.store.Update(savedCollection, &all, func() error {
+		id := accountRoleID(a)
+		i := all[id].index(h)
+		if i < 0 {
+			return errors.NotFoundf("item is not saved")
+		}
+		all[id][i].Tags = tags
+		return nil
+	})
+}
+
+// RemoveSavedItems removes the items saved by the a account
+func (r *repository) RemoveSavedItems(a Account) error {
+	all := make(accountsSaved)
+	return r.store.Update(savedCollection, &all, func() error {
+		delete(all, accountRoleID(a))
+		return nil
+	})
+}
+
+// loadItemsByIRI loads the items with the iris, keyed by their hash.
+// The items that can't be loaded anymore, that were deleted, or that are held for moderation and the viewer
+// can't see, are skipped.
+func (r *repository) loadItemsByIRI(ctx context.Context, iris ...string) (map[string]Item, error) {
+	result := make(map[string]Item)
+	if len(iris) == 0 {
+		return result, nil
+	}
+	f := &Filters{MaxItems: len(iris)}
+	for _, iri := range iris {
+		f.IRI = append(f.IRI, EqualsString(iri))
+	}
+	items, err := r.objects(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	viewer := ContextAccount(ctx)
+	if viewer != nil {
+		// NOTE(marius): the items the viewer hid are kept, as they are shown on the saved and hidden items pages
+		v := *viewer
+		v.Hidden = nil
+		viewer = &v
+	}
+	items, _ = hideItems(viewer, items, ContextShowHidden(ctx))
+	for _, it := range items {
+		if it.Deleted() {
+			continue
+		}
+		result[it.Hash.String()] = it
+	}
+	return result, nil
+}
+
+// loadSavedItemsPage loads the items of the saved list, in the order they were saved in
+func (r *repository) loadSavedItemsPage(ctx context.Context, list SavedItems) ([]savedEntry, error) {
+	iris := make([]string, len(list))
+	for i, s := range list {
+		iris[i] = s.IRI
+	}
+	items, err := r.loadItemsByIRI(ctx, iris...)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]savedEntry, 0)
+	for _, s := range list {
+		if it, ok := items[s.Hash]; ok {
+			entries = append(entries, savedEntry{SavedItem: s, Item: &it})
+		}
+	}
+	return entries, nil
+}
+
+// HandleSaved serves /~{handle}/saved and /~{handle}/saved/{tag} requests
+func (h *handler) HandleSaved(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	saved, err := h.storage.LoadSavedItems(*acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	tag := chi.URLParam(r, "tag")
+	f := FiltersFromRequest(r)
+	if f == nil {
+		f = &Filters{MaxItems: MaxContentItems}
+	}
+	list, next, prev := saved.withTag(tag).page(HashFromString(f.Next), HashFromString(f.Prev), f.MaxItems)
+
+	m := &savedModel{Title: "Saved items", User: acc, Tag: tag, Tags: saved.Tags(), after: next, before: prev}
+	if len(tag) > 0 {
+		m.Title = fmt.Sprintf("Saved items tagged #%s", tag)
+	}
+	if m.Items, err = h.storage.loadSavedItemsPage(viewerContext(r), list); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to load saved items")
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleSaveTags serves /~{handle}/saved POST request
+// It changes the tags of a saved item, or removes it from the list.
+func (h *handler) HandleSaveTags(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/saved", PermaLink(acc))
+	hash := HashFromString(r.PostFormValue("hash"))
+	switch r.PostFormValue("action") {
+	case "unsave":
+		if err = h.storage.RemoveSavedItem(*acc, hash); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Item removed from your saved items")
+		}
+	case "tags":
+		tags := parseSavedTags(r.PostFormValue("tags"))
+		if err = h.storage.TagSavedItem(*acc, hash, tags); err == nil {
+			h.v.addFlashMessage(Success, w, r, "Saved item tags updated")
+		}
+	default:
+		err = errors.BadRequestf("invalid action %q", r.PostFormValue("action"))
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle, "hash": hash})("unable to update saved item")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to update saved item: %s", err))
+	}
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
+
+// HandleSaveItem serves /~{handle}/{hash}/save and /~{handle}/{hash}/unsave requests
+func (h *handler) HandleSaveItem(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	repo := h.storage
+	ctx := context.TODO()
+	p, err := repo.LoadItem(ctx, objects.IRI(repo.fedbox.Service()).AddPath(chi.URLParam(r, "hash")))
+	if err != nil {
+		h.errFn()("Error: %s", err)
+		h.v.HandleErrors(w, r, errors.NewNotFound(err, "not found"))
+		return
+	}
+	url := ItemPermaLink(&p)
+	if backUrl := r.Header.Get("Referer"); !strings.Contains(backUrl, url) && strings.Contains(backUrl, Instance.BaseURL) {
+		url = fmt.Sprintf("%s#li-%s", backUrl, p.Hash)
+	}
+	op := path.Base(r.URL.Path)
+	if op == "save" {
+		err = repo.AddSavedItem(*acc, p)
+	} else {
+		err = repo.RemoveSavedItem(*acc, p.Hash)
+	}
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "hash": p.Hash})("unable to %s item", op)
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s item: %s", op, err))
+	} else {
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Item %sd", op))
+	}
+	h.v.Redirect(w, r, url, http.StatusFound)
+}
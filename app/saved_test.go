@@ -0,0 +1,92 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func mockSavedItems(count int) SavedItems {
+	saved := make(SavedItems, count)
+	for i := range saved {
+		saved[i].Hash = fmt.Sprintf("6435b2b5-26df-434c-87ca-58ddab49%04d", i)
+	}
+	return saved
+}
+
+func TestSavedItems_page(t *testing.T) {
+	saved := mockSavedItems(5)
+	hash := func(i int) Hash {
+		return HashFromString(saved[i].Hash)
+	}
+	tests := []struct {
+		name      string
+		after     Hash
+		before    Hash
+		wantFirst int
+		wantLen   int
+		wantNext  Hash
+		wantPrev  Hash
+	}{
+		{name: "first page", wantFirst: 0, wantLen: 2, wantNext: hash(1)},
+		{name: "second page", after: hash(1), wantFirst: 2, wantLen: 2, wantNext: hash(3), wantPrev: hash(2)},
+		{name: "last page", after: hash(3), wantFirst: 4, wantLen: 1, wantPrev: hash(4)},
+		{name: "back to second page", before: hash(4), wantFirst: 2, wantLen: 2, wantNext: hash(3), wantPrev: hash(2)},
+		{name: "back to first page", before: hash(2), wantFirst: 0, wantLen: 2, wantNext: hash(1)},
+		{name: "unknown hash", after: HashFromString("6435b2b5-26df-434c-87ca-58ddab49fcc8"), wantFirst: 0, wantLen: 2, wantNext: hash(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next, prev := saved.page(tt.after, tt.before, 2)
+			if len(got) != tt.wantLen {
+				t.Fatalf("page() returned %d items, want %d", len(got), tt.wantLen)
+			}
+			if got[0].Hash != saved[tt.wantFirst].Hash {
+				t.Errorf("page() starts with %s, want %s", got[0].Hash, saved[tt.wantFirst].Hash)
+			}
+			if next != tt.wantNext {
+				t.Errorf("page() next = %s, want %s", next, tt.wantNext)
+			}
+			if prev != tt.wantPrev {
+				t.Errorf("page() prev = %s, want %s", prev, tt.wantPrev)
+			}
+		})
+	}
+}
+
+func TestSavedItems_withTag(t *testing.T) {
+	saved := mockSavedItems(3)
+	saved[0].Tags = []string{"golang", "later"}
+	saved[2].Tags = []string{"golang"}
+
+	if got := saved.withTag(""); len(got) != 3 {
+		t.Errorf("withTag(\"\") returned %d items, want 3", len(got))
+	}
+	if got := saved.withTag("golang"); len(got) != 2 || got[0].Hash != saved[0].Hash || got[1].Hash != saved[2].Hash {
+		t.Errorf("withTag(golang) = %v, want the first and the last items", got)
+	}
+	if got := saved.withTag("missing"); len(got) != 0 {
+		t.Errorf("withTag(missing) returned %d items, want none", len(got))
+	}
+	if got, want := saved.Tags(), []string{"golang", "later"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSavedTags(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "", want: []string{}},
+		{in: "golang, #Later  read", want: []string{"golang", "later", "read"}},
+		{in: "go go GO", want: []string{"go"}},
+		{in: "# toolongtoolongtoolongtoolongtoolong ok", want: []string{"ok"}},
+		{in: "a b c d e f g h i j k l", want: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, tt := range tests {
+		if got := parseSavedTags(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSavedTags(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
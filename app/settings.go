@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const (
+	// MaxProfileLinks is the number of links an account can show on its profile page
+	MaxProfileLinks = 4
+	// MaxDisplayNameLength is the maximum number of characters of an account's display name
+	MaxDisplayNameLength = 64
+	// MaxBioLength is the maximum number of characters of an account's description
+	MaxBioLength = 2000
+)
+
+// loadAPProfileLinks converts the profile links of an account to the attachments of its actor
+func loadAPProfileLinks(links []ProfileLink) pub.Item {
+	if len(links) == 0 {
+		return nil
+	}
+	attachments := make(pub.ItemCollection, 0)
+	for _, l := range links {
+		lnk := pub.LinkNew(pub.ID(l.URL), pub.LinkType)
+		lnk.Href = pub.IRI(l.URL)
+		if len(l.Name) > 0 {
+			lnk.Name = pub.NaturalLanguageValuesNew()
+			lnk.Name.Set(pub.NilLangRef, pub.Content(l.Name))
+		}
+		attachments = append(attachments, lnk)
+	}
+	return attachments
+}
+
+// profileLinksFromAttachments loads the profile links of an account from the attachments of its actor
+func profileLinksFromAttachments(it pub.Item) []ProfileLink {
+	links := make([]ProfileLink, 0)
+	load := func(it pub.Item) {
+		if it == nil || it.GetType() != pub.LinkType {
+			return
+		}
+		pub.OnLink(it, func(l *pub.Link) error {
+			if len(l.Rel) > 0 {
+				return nil
+			}
+			u := l.Href.String()
+			if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+				return nil
+			}
+			links = append(links, ProfileLink{Name: l.Name.First().Value.String(), URL: u})
+			return nil
+		})
+	}
+	if it.IsCollection() {
+		pub.OnCollectionIntf(it, func(col pub.CollectionInterface) error {
+			for _, ob := range col.Collection() {
+				load(ob)
+			}
+			return nil
+		})
+	} else {
+		load(it)
+	}
+	return links
+}
+
+// profileFromRequest loads the display name, the description and the profile links of the a account
+// from the settings form
+func profileFromRequest(r *http.Request, a *Account) error {
+	if a.Metadata == nil {
+		a.Metadata = &AccountMetadata{}
+	}
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+		return errors.BadRequestf("the display name can have at most %d characters", MaxDisplayNameLength)
+	}
+	bio := strings.TrimSpace(r.PostFormValue("bio"))
+	if utf8.RuneCountInString(bio) > MaxBioLength {
+		return errors.BadRequestf("the bio can have at most %d characters", MaxBioLength)
+	}
+
+	names := r.PostForm["link-name"]
+	urls := r.PostForm["link-url"]
+	links := make([]ProfileLink, 0)
+	for i, u := range urls {
+		if u = strings.TrimSpace(u); len(u) == 0 {
+			continue
+		}
+		pu, err := url.Parse(u)
+		if err != nil || (pu.Scheme != "https" && pu.Scheme != "http") || len(pu.Host) == 0 {
+			return errors.BadRequestf("invalid profile link %q, it needs to be a http or https URL", u)
+		}
+		l := ProfileLink{URL: pu.String()}
+		if i < len(names) {
+			l.Name = strings.TrimSpace(names[i])
+		}
+		links = append(links, l)
+	}
+	if len(links) > MaxProfileLinks {
+		return errors.BadRequestf("the profile can have at most %d links", MaxProfileLinks)
+	}
+
+	a.Metadata.Name = name
+	a.Metadata.Blurb = []byte(bio)
+	a.Metadata.Links = links
+	return nil
+}
+
+// loadAvatarFromRequest saves the avatar image uploaded with the settings form
+func (h *handler) loadAvatarFromRequest(r *http.Request) (*ImageMetadata, error) {
+	if !h.conf.UploadsEnabled || h.media == nil {
+		return nil, nil
+	}
+	f, fh, err := r.FormFile("avatar")
+	if err != nil {
+		if err == http.ErrMissingFile || err == http.ErrNotMultipart {
+			return nil, nil
+		}
+		return nil, errors.Annotatef(err, "unable to load uploaded avatar")
+	}
+	defer f.Close()
+	if fh.Size > h.conf.MaxUploadSize {
+		return nil, errors.BadRequestf("uploaded avatar is larger than %s", sizeFmt(h.conf.MaxUploadSize))
+	}
+	data, mimeType, err := readMedia(f, h.conf.MaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+	if !isImage(mimeType) {
+		return nil, errors.BadRequestf("the avatar needs to be an image, received %s", mimeType)
+	}
+	m, err := storeMedia(h.media, data, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE(marius): the thumbnail is large enough for an avatar, and much lighter to load for the remote servers
+	return &m.Thumbnail, nil
+}
+
+// UpdateAccount publishes the changes to the a account's profile as an Update activity of the account itself,
+// addressed to its followers, so their servers refresh their copy of it
+func (r *repository) UpdateAccount(ctx context.Context, a Account) (Account, error) {
+	if !accountValidForC2S(&a) {
+		return a, errors.Unauthorizedf("invalid account %s", a.Handle)
+	}
+	check := r.checks.CheckAccount(ctx, a)
+	if !check.Allowed() {
+		r.infoFn(log.Ctx{"check": check.Check, "reason": check.Reason, "handle": a.Handle})("profile rejected")
+		return a, errors.Forbiddenf("your profile was rejected, it %s", check.Reason)
+	}
+	// NOTE(marius): the aliases are kept in the local storage, and the account from the session doesn't have them
+	if m, err := r.LoadAccountMove(ctx, a); err == nil {
+		a.Move = m
+	}
+	now := time.Now().UTC()
+	p := r.loadAPPerson(a)
+	p.Updated = now
+
+	act := &pub.Activity{
+		Type:    pub.UpdateType,
+		To:      pub.ItemCollection{pub.PublicNS},
+		BCC:     pub.ItemCollection{r.fedbox.Service().ID},
+		Actor:   p.GetLink(),
+		Object:  p,
+		Updated: now,
+	}
+	if len(a.Metadata.FollowersIRI) > 0 {
+		act.CC = pub.ItemCollection{pub.IRI(a.Metadata.FollowersIRI)}
+	}
+	if check.Held() {
+		// NOTE(marius): the held profile doesn't get federated to the followers, and it's not public
+		//   until a moderator approves it
+		act.To = make(pub.ItemCollection, 0)
+		act.CC = make(pub.ItemCollection, 0)
+		p.To = act.To
+		p.BCC = act.BCC
+	}
+	if _, _, err := r.fedbox.ToOutbox(ctx, act); err != nil {
+		r.errFn(log.Ctx{"actor": a.Handle, "err": err})("profile update failed")
+		return a, err
+	}
+	a.UpdatedAt = now
+	if check.Held() {
+		if err := r.holdContent(heldAccount, a.Hash, p.GetLink(), check); err != nil {
+			r.errFn(log.Ctx{"actor": a.Handle, "err": err})("unable to hold account for moderation")
+		}
+	}
+	return a, nil
+}
+
+// validateOwnAccount returns the logged account if it's the one the /~{handle} route belongs to
+func validateOwnAccount(r *http.Request) (*Account, error) {
+	acc := loggedAccount(r)
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 || !acc.IsLogged() || authors[0].Hash != acc.Hash {
+		return nil, errors.Forbiddenf("you can only change your own settings")
+	}
+	return acc, nil
+}
+
+// HandleSettings serves /~{handle}/settings request
+func (h *handler) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m := &settingsModel{Title: "Settings", User: acc, TwoFactor: h.storage.TwoFactorEnabled(*acc)}
+	if m.Email, err = h.storage.LoadAccountEmail(context.TODO(), *acc); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to load account email")
+	}
+	if m.Identities, err = h.storage.LoadAccountIdentities(*acc); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to load account identities")
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleSaveSettings serves /~{handle}/settings POST request
+func (h *handler) HandleSaveSettings(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings", PermaLink(acc))
+
+	a := *acc
+	if acc.HasMetadata() {
+		md := *acc.Metadata
+		a.Metadata = &md
+	}
+	if err = profileFromRequest(r, &a); err != nil {
+		h.v.addFlashMessage(Error, w, r, err.Error())
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if r.PostFormValue("avatar-rm") != "" {
+		a.Metadata.Icon = ImageMetadata{}
+	}
+	icon, err := h.loadAvatarFromRequest(r)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to save uploaded avatar")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save avatar: %s", err))
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	if icon != nil {
+		a.Metadata.Icon = *icon
+	}
+
+	if a, err = h.storage.UpdateAccount(context.TODO(), a); err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to update account")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to save settings: %s", err))
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	*acc = a
+	if err = h.v.saveAccountToSession(w, r, *acc); err != nil {
+		h.errFn(log.Ctx{"err": err.Error()})("unable to save account to session")
+	}
+	h.v.addFlashMessage(Success, w, r, "Settings saved")
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
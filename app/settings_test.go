@@ -0,0 +1,112 @@
+package app
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProfileFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		wantErr bool
+		links   int
+	}{
+		{
+			name:   "profile",
+			values: url.Values{"name": {" Jane Doe "}, "bio": {"I *like* cats"}, "link-name": {"Blog", ""}, "link-url": {"https://jane.example", ""}},
+			links:  1,
+		},
+		{
+			name:    "invalid link",
+			values:  url.Values{"link-name": {"Blog"}, "link-url": {"javascript:alert(1)"}},
+			wantErr: true,
+		},
+		{
+			name:    "too many links",
+			values:  url.Values{"link-url": {"https://a.example", "https://b.example", "https://c.example", "https://d.example", "https://e.example"}},
+			wantErr: true,
+		},
+		{
+			name:    "long name",
+			values:  url.Values{"name": {strings.Repeat("a", MaxDisplayNameLength+1)}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/~jane/settings", strings.NewReader(tt.values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			a := Account{Handle: "jane"}
+			err := profileFromRequest(r, &a)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("profileFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(a.Metadata.Links) != tt.links {
+				t.Errorf("profileFromRequest() links = %v, want %d", a.Metadata.Links, tt.links)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("POST", "/~jane/settings", strings.NewReader(tests[0].values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	a := Account{Handle: "jane"}
+	if err := profileFromRequest(r, &a); err != nil {
+		t.Fatalf("profileFromRequest() error: %s", err)
+	}
+	if a.Metadata.Name != "Jane Doe" {
+		t.Errorf("name = %q, want %q", a.Metadata.Name, "Jane Doe")
+	}
+	if string(a.Metadata.Blurb) != "I *like* cats" {
+		t.Errorf("bio = %q, want %q", a.Metadata.Blurb, "I *like* cats")
+	}
+	if l := a.Metadata.Links[0]; l.Name != "Blog" || l.URL != "https://jane.example" {
+		t.Errorf("link = %+v, want Blog https://jane.example", l)
+	}
+}
+
+func TestAccount_Bio(t *testing.T) {
+	tests := []struct {
+		name    string
+		blurb   string
+		want    string
+		notWant []string
+	}{
+		{
+			name:    "script",
+			blurb:   "hello <script>alert(1)</script>",
+			want:    "hello",
+			notWant: []string{"<script", "alert(1)"},
+		},
+		{
+			name:    "img onerror",
+			blurb:   "hello <img src=x onerror=alert(1)>",
+			want:    "hello",
+			notWant: []string{"<img", "onerror"},
+		},
+		{
+			name:  "markdown",
+			blurb: "hello **world**",
+			want:  "<strong>world</strong>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Account{Metadata: &AccountMetadata{Blurb: []byte(tt.blurb)}}
+			got := string(a.Bio())
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("Bio() = %q, want it to contain %q", got, tt.want)
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("Bio() = %q, contains %q", got, s)
+				}
+			}
+		})
+	}
+}
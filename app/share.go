@@ -0,0 +1,80 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+type shareTargetParams struct {
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type shareTarget struct {
+	Action  string            `json:"action"`
+	Method  string            `json:"method"`
+	EncType string            `json:"enctype,omitempty"`
+	Params  shareTargetParams `json:"params"`
+}
+
+type manifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+// webManifest is the web application manifest we use for registering as a Web Share Target
+// See https://w3c.github.io/web-share-target/
+type webManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name,omitempty"`
+	Description     string         `json:"description,omitempty"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	ThemeColor      string         `json:"theme_color,omitempty"`
+	BackgroundColor string         `json:"background_color,omitempty"`
+	Icons           []manifestIcon `json:"icons,omitempty"`
+	ShareTarget     shareTarget    `json:"share_target"`
+}
+
+// HandleManifest serves /manifest.webmanifest request
+func (h handler) HandleManifest(w http.ResponseWriter, r *http.Request) {
+	m := webManifest{
+		Name:        h.conf.Name,
+		ShortName:   h.conf.Name,
+		Description: "Link aggregator inspired by reddit and hacker news using ActivityPub federation.",
+		StartURL:    "/",
+		Scope:       "/",
+		Display:     "standalone",
+		ThemeColor:  "rebeccapurple",
+		Icons: []manifestIcon{
+			{Src: "/favicon.ico", Sizes: "48x48", Type: "image/x-icon"},
+		},
+		ShareTarget: shareTarget{
+			Action: "/submit",
+			Method: http.MethodGet,
+			Params: shareTargetParams{
+				Title: "title",
+				Text:  "text",
+				URL:   "url",
+			},
+		},
+	}
+	dat, _ := json.Marshal(m)
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// bookmarklet returns the javascript link that opens the submission form prefilled with the current tab's
+// URL, title and selected text
+func bookmarklet(baseURL string) template.URL {
+	js := "javascript:(function(){var d=document,s=window.getSelection?''+window.getSelection():'';" +
+		"window.open('%s/submit?url='+encodeURIComponent(d.location.href)+'&title='+encodeURIComponent(d.title)+'&text='+encodeURIComponent(s));})();"
+	return template.URL(fmt.Sprintf(js, baseURL))
+}
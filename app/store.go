@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+// localStore persists, as JSON files, the instance data for which the ActivityPub vocabulary
+// has no representation, and which therefore can't be saved to FedBOX.
+type localStore struct {
+	path string
+	m    sync.RWMutex
+	u    sync.Mutex
+
+	cm sync.Mutex
+	// cache are the collections loaded with LoadCached
+	cache map[string]cachedCollection
+	// version changes with every save, so we don't cache a collection loaded before it was saved
+	version uint64
+}
+
+// cachedCollection is a decoded collection, with the modification time and the size of its file
+type cachedCollection struct {
+	modTime time.Time
+	size    int64
+	v       interface{}
+}
+
+// newLocalStore returns a store that keeps its files in the path directory
+func newLocalStore(path string) *localStore {
+	return &localStore{path: path}
+}
+
+func (s *localStore) file(name string) string {
+	return filepath.Join(s.path, name+".json")
+}
+
+// Load reads the name collection into v.
+// A collection that has not been saved yet is not considered an error, and leaves v unchanged.
+func (s *localStore) Load(name string, v interface{}) error {
+	if s == nil {
+		return errors.Newf("local storage is not initialized")
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	raw, err := ioutil.ReadFile(s.file(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Annotatef(err, "unable to load %s", name)
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	if err = json.Unmarshal(raw, v); err != nil {
+		return errors.Annotatef(err, "unable to decode %s", name)
+	}
+	return nil
+}
+
+// Save writes v as the name collection
+func (s *localStore) Save(name string, v interface{}) error {
+	if s == nil {
+		return errors.Newf("local storage is not initialized")
+	}
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Annotatef(err, "unable to encode %s", name)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err = os.MkdirAll(s.path, 0700); err != nil {
+		return errors.Annotatef(err, "unable to create storage path %s", s.path)
+	}
+	// NOTE(marius): we write to a temporary file first, so a failed write doesn't corrupt the existing data
+	tmp := s.file(name) + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return errors.Annotatef(err, "unable to save %s", name)
+	}
+	if err = os.Rename(tmp, s.file(name)); err != nil {
+		return errors.Annotatef(err, "unable to save %s", name)
+	}
+	s.cm.Lock()
+	delete(s.cache, name)
+	s.version++
+	s.cm.Unlock()
+	return nil
+}
+
+// LoadCached returns the name collection decoded into the value returned by newFn. The collection is kept in memory
+// and it's loaded again only when its file changes, so it's meant for the ones we read on every request.
+// The value is shared by all the callers, so they must not modify it.
+func (s *localStore) LoadCached(name string, newFn func() interface{}) (interface{}, error) {
+	if s == nil {
+		return nil, errors.Newf("local storage is not initialized")
+	}
+	s.cm.Lock()
+	c, ok := s.cache[name]
+	version := s.version
+	s.cm.Unlock()
+
+	var modTime time.Time
+	var size int64
+	fi, err := os.Stat(s.file(name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Annotatef(err, "unable to load %s", name)
+	}
+	if fi != nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	if ok && c.modTime.Equal(modTime) && c.size == size {
+		return c.v, nil
+	}
+
+	v := newFn()
+	if err = s.Load(name, v); err != nil {
+		return nil, err
+	}
+	s.cm.Lock()
+	if s.version == version {
+		if s.cache == nil {
+			s.cache = make(map[string]cachedCollection)
+		}
+		s.cache[name] = cachedCollection{modTime: modTime, size: size, v: v}
+	}
+	s.cm.Unlock()
+	return v, nil
+}
+
+// Update loads the name collection into v, applies fn to it and saves it back if fn didn't return an error
+func (s *localStore) Update(name string, v interface{}, fn func() error) error {
+	if s == nil {
+		return errors.Newf("local storage is not initialized")
+	}
+	// NOTE(marius): the updates are serialized, so concurrent requests don't overwrite each other's changes
+	s.u.Lock()
+	defer s.u.Unlock()
+
+	if err := s.Load(name, v); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.Save(name, v)
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLocalStore_LoadCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-store")
+	if err != nil {
+		t.Fatalf("unable to create storage directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s := newLocalStore(dir)
+
+	newFn := func() interface{} {
+		return &map[string]int{}
+	}
+	load := func() map[string]int {
+		v, err := s.LoadCached("counts", newFn)
+		if err != nil {
+			t.Fatalf("LoadCached() error: %s", err)
+		}
+		return *v.(*map[string]int)
+	}
+
+	if got := load(); len(got) != 0 {
+		t.Errorf("LoadCached() = %v, want an empty collection before it's saved", got)
+	}
+	if err = s.Save("counts", map[string]int{"jane": 1}); err != nil {
+		t.Fatalf("Save() error: %s", err)
+	}
+	if got := load(); got["jane"] != 1 {
+		t.Errorf("LoadCached() = %v, want the saved collection", got)
+	}
+	// NOTE(marius): the files can also be changed by someone else than the store
+	if err = ioutil.WriteFile(s.file("counts"), []byte(`{"jane":1,"john":22}`), 0600); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+	if got := load(); got["john"] != 22 {
+		t.Errorf("LoadCached() = %v, want the collection from the changed file", got)
+	}
+}
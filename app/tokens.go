@@ -0,0 +1,396 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+)
+
+const accessTokensCollection = "access-tokens"
+
+const (
+	// ScopeRead allows loading the pages the account can see
+	ScopeRead = "read"
+	// ScopeWrite allows submitting, editing and deleting content, following and blocking accounts
+	ScopeWrite = "write"
+	// ScopeVote allows voting on content
+	ScopeVote = "vote"
+	// ScopeModerate allows the moderation actions, for the accounts that have a moderation role
+	ScopeModerate = "moderate"
+)
+
+// ValidTokenScopes are the scopes an access token can be created with
+var ValidTokenScopes = []string{ScopeRead, ScopeWrite, ScopeVote, ScopeModerate}
+
+const (
+	accessTokenPrefix = "littr_"
+	// accessTokenUsedInterval is how often we save the last time a token was used
+	accessTokenUsedInterval = time.Hour
+	// MaxAccessTokens is the maximum number of access tokens an account can have
+	MaxAccessTokens    = 20
+	maxTokenNameLength = 64
+)
+
+// AccessToken is a personal access token, that scripts can use for authenticating as the account that created it,
+// by sending it in the Authorization header. We keep only the hash of the token.
+type AccessToken struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Account    string    `json:"account"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// accessTokens are the access tokens, keyed by the hash of the token
+type accessTokens map[string]AccessToken
+
+// HasScope returns true if the token was created with the s scope
+func (t AccessToken) HasScope(s string) bool {
+	for _, sc := range t.Scopes {
+		if sc == s {
+			return true
+		}
+	}
+	return false
+}
+
+func accessTokenKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAccessToken generates a new access token, and the key we save it under
+func newAccessToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.Annotatef(err, "unable to generate access token")
+	}
+	raw := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return raw, accessTokenKey(raw), nil
+}
+
+// parseTokenScopes returns the valid scopes from vals, in the order of ValidTokenScopes
+func parseTokenScopes(vals []string) ([]string, error) {
+	scopes := make([]string, 0)
+	for _, s := range ValidTokenScopes {
+		for _, v := range vals {
+			if strings.TrimSpace(strings.ToLower(v)) == s {
+				scopes = append(scopes, s)
+				break
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.BadRequestf("at least one scope is needed")
+	}
+	return scopes, nil
+}
+
+// bearerToken returns the token from the Authorization header of the request
+func bearerToken(r *http.Request) string {
+	auth := strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	if len(auth) != 2 || !strings.EqualFold(auth[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(auth[1])
+}
+
+// requestScope returns the scope an access token needs for loading the request's page, or for the request's
+// action when it's not a GET one. The handlers which need more check the scope themselves with requireScope.
+// It returns an empty string for the requests that can't be authenticated with access tokens, like the ones
+// for the account settings.
+func requestScope(r *http.Request) string {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	first, last := parts[0], parts[len(parts)-1]
+	switch first {
+	case "login", "logout", "register", "auth", "forgot-password", "reset-password", "confirm-email":
+		return ""
+	}
+	if strings.HasPrefix(first, "~") && len(parts) > 1 {
+		switch parts[1] {
+		case "settings":
+			return ""
+		case "follow":
+			return ScopeWrite
+		}
+	}
+	switch last {
+	case "yay", "nay":
+		return ScopeVote
+	case "save", "unsave", "hide", "unhide":
+		return ScopeWrite
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ScopeWrite
+	}
+	return ScopeRead
+}
+
+// requireScope returns true if the request is not authenticated with an access token, or if the token has the s scope.
+// Otherwise it renders the error and returns false.
+func (h *handler) requireScope(w http.ResponseWriter, r *http.Request, s string) bool {
+	t := ContextAccessToken(r.Context())
+	if t == nil || t.HasScope(s) {
+		return true
+	}
+	h.infoFn(log.Ctx{"token": t.ID, "scope": s, "path": r.URL.Path})("access token scope not allowed")
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+	h.v.HandleErrors(w, r, errors.Forbiddenf("the access token is not allowed to make this request"))
+	return false
+}
+
+// CreateAccessToken creates a new access token for the a account, and returns it.
+// NOTE(marius): we save only the hash of the token, so it can't be shown to the user again.
+func (r *repository) CreateAccessToken(a Account, name string, scopes []string) (string, AccessToken, error) {
+	t := AccessToken{
+		Name:      strings.TrimSpace(name),
+		Account:   accountRoleID(a),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if len(t.Name) == 0 || len(t.Name) > maxTokenNameLength {
+		return "", t, errors.BadRequestf("the name needs to have between 1 and %d characters", maxTokenNameLength)
+	}
+	raw, key, err := newAccessToken()
+	if err != nil {
+		return "", t, err
+	}
+	t.ID = key[:16]
+
+	all := make(accessTokens)
+	err = r.store.Update(accessTokensCollection, &all, func() error {
+		count := 0
+		for _, at := range all {
+			if at.Account == t.Account {
+				count++
+			}
+		}
+		if count >= MaxAccessTokens {
+			return errors.BadRequestf("you can have at most %d access tokens", MaxAccessTokens)
+		}
+		all[key] = t
+		return nil
+	})
+	return raw, t, err
+}
+
+// LoadAccountAccessTokens loads the access tokens of the a account, newest first
+func (r *repository) LoadAccountAccessTokens(a Account) ([]AccessToken, error) {
+	all := make(accessTokens)
+	if err := r.store.Load(accessTokensCollection, &all); err != nil {
+		return nil, err
+	}
+	id := accountRoleID(a)
+	tokens := make([]AccessToken, 0)
+	for _, t := range all {
+		if t.Account == id {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// RevokeAccessToken removes the access token with the id of the a account
+func (r *repository) RevokeAccessToken(a Account, id string) error {
+	all := make(accessTokens)
+	return r.store.Update(accessTokensCollection, &all, func() error {
+		for k, t := range all {
+			if t.ID == id && t.Account == accountRoleID(a) {
+				delete(all, k)
+				return nil
+			}
+		}
+		return errors.NotFoundf("access token %s", id)
+	})
+}
+
+// RevokeAccountAccessTokens removes all the access tokens of the a account
+func (r *repository) RevokeAccountAccessTokens(a Account) error {
+	all := make(accessTokens)
+	return r.store.Update(accessTokensCollection, &all, func() error {
+		for k, t := range all {
+			if t.Account == accountRoleID(a) {
+				delete(all, k)
+			}
+		}
+		return nil
+	})
+}
+
+// LoadAccessToken loads the raw access token, and marks it as used
+func (r *repository) LoadAccessToken(raw string) (AccessToken, error) {
+	if !strings.HasPrefix(raw, accessTokenPrefix) {
+		return AccessToken{}, errors.NotValidf("invalid access token")
+	}
+	key := accessTokenKey(raw)
+	all := make(accessTokens)
+	if err := r.store.Load(accessTokensCollection, &all); err != nil {
+		return AccessToken{}, err
+	}
+	t, ok := all[key]
+	if !ok {
+		return t, errors.NotFoundf("access token")
+	}
+	now := time.Now().UTC()
+	if now.Sub(t.LastUsedAt) > accessTokenUsedInterval {
+		t.LastUsedAt = now
+		used := make(accessTokens)
+		err := r.store.Update(accessTokensCollection, &used, func() error {
+			if _, ok := used[key]; ok {
+				used[key] = t
+			}
+			return nil
+		})
+		if err != nil {
+			r.errFn(log.Ctx{"err": err.Error(), "token": t.ID})("unable to save access token use")
+		}
+	}
+	return t, nil
+}
+
+// maxTokenAccounts is the number of accounts authenticated with access tokens we keep in memory
+const maxTokenAccounts = 1000
+
+// tokenAccounts are the accounts authenticated with access tokens, keyed by the hash of the token.
+// They take the place of the session for the requests that have no session cookie, so we don't need to
+// load the account and request a FedBOX token for each of them.
+var tokenAccounts = struct {
+	sync.RWMutex
+	m map[string]Account
+}{m: make(map[string]Account)}
+
+// tokenAccountValid returns true if the FedBOX token of the a account loaded for an access token didn't expire
+func tokenAccountValid(a Account) bool {
+	return a.HasMetadata() && a.Metadata.OAuth.Token.Valid()
+}
+
+func saveTokenAccount(raw string, a Account) {
+	tokenAccounts.Lock()
+	defer tokenAccounts.Unlock()
+
+	if len(tokenAccounts.m) >= maxTokenAccounts {
+		for k, ta := range tokenAccounts.m {
+			if !tokenAccountValid(ta) {
+				delete(tokenAccounts.m, k)
+			}
+		}
+		// NOTE(marius): when all of them are still valid we drop some at random, they get reloaded when needed
+		for k := range tokenAccounts.m {
+			if len(tokenAccounts.m) < maxTokenAccounts {
+				break
+			}
+			delete(tokenAccounts.m, k)
+		}
+	}
+	tokenAccounts.m[accessTokenKey(raw)] = a
+}
+
+// loadTokenAccount loads the account of the raw access token
+func (h *handler) loadTokenAccount(r *http.Request, raw string) (Account, AccessToken, error) {
+	t, err := h.storage.LoadAccessToken(raw)
+	if err != nil {
+		return AnonymousAccount, t, err
+	}
+	tokenAccounts.RLock()
+	a, ok := tokenAccounts.m[accessTokenKey(raw)]
+	tokenAccounts.RUnlock()
+	if ok && accountRoleID(a) == t.Account && tokenAccountValid(a) {
+		return a, t, nil
+	}
+	if ok {
+		tokenAccounts.Lock()
+		delete(tokenAccounts.m, accessTokenKey(raw))
+		tokenAccounts.Unlock()
+	}
+
+	acc, err := h.storage.LoadAccount(r.Context(), pub.IRI(t.Account))
+	if err != nil {
+		return AnonymousAccount, t, err
+	}
+	if !acc.IsValid() || !acc.HasMetadata() || acc.Deleted() {
+		return AnonymousAccount, t, errors.NotFoundf("account %s", t.Account)
+	}
+	tok, err := h.accountToken(r, *acc)
+	if err != nil {
+		return AnonymousAccount, t, errors.Annotatef(err, "unable to authorize account")
+	}
+	acc.Metadata.OAuth.Provider = "fedbox"
+	acc.Metadata.OAuth.Token = tok
+	return *acc, t, nil
+}
+
+func (h *handler) renderAccessTokens(w http.ResponseWriter, r *http.Request, acc *Account, token string) {
+	m := &accessTokensModel{Title: "Access tokens", User: acc, Token: token, Scopes: ValidTokenScopes}
+	var err error
+	if m.Tokens, err = h.storage.LoadAccountAccessTokens(*acc); err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleAccessTokens serves /~{handle}/settings/tokens request
+func (h *handler) HandleAccessTokens(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.renderAccessTokens(w, r, acc, "")
+}
+
+// HandleSaveAccessTokens serves /~{handle}/settings/tokens POST request
+func (h *handler) HandleSaveAccessTokens(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings/tokens", PermaLink(acc))
+	r.ParseForm()
+
+	action := r.PostFormValue("action")
+	switch action {
+	case "create":
+		var scopes []string
+		var token string
+		var t AccessToken
+		if scopes, err = parseTokenScopes(r.PostForm["scopes"]); err == nil {
+			token, t, err = h.storage.CreateAccessToken(*acc, r.PostFormValue("name"), scopes)
+		}
+		if err != nil {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to create access token: %s", err))
+			break
+		}
+		h.infoFn(log.Ctx{"handle": acc.Handle, "token": t.ID, "scopes": t.Scopes})("access token created")
+		// NOTE(marius): the token is shown only once, so we render it instead of redirecting
+		h.renderAccessTokens(w, r, acc, token)
+		return
+	case "revoke":
+		id := r.PostFormValue("id")
+		if err = h.storage.RevokeAccessToken(*acc, id); err != nil {
+			h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to revoke access token: %s", err))
+			break
+		}
+		h.infoFn(log.Ctx{"handle": acc.Handle, "token": id})("access token revoked")
+		h.v.addFlashMessage(Success, w, r, "Access token revoked")
+	default:
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Invalid action %q", action))
+	}
+	h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+}
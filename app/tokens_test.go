@@ -0,0 +1,124 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequestScope(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/", want: ScopeRead},
+		{method: http.MethodGet, path: "/~jane", want: ScopeRead},
+		{method: http.MethodGet, path: "/~jane/6c9a2b1f", want: ScopeRead},
+		{method: http.MethodGet, path: "/moderation/log.json", want: ScopeRead},
+		{method: http.MethodPost, path: "/submit", want: ScopeWrite},
+		{method: http.MethodPost, path: "/~jane/6c9a2b1f", want: ScopeWrite},
+		{method: http.MethodGet, path: "/~jane/6c9a2b1f/rm", want: ScopeRead},
+		{method: http.MethodGet, path: "/~jane/6c9a2b1f/save", want: ScopeWrite},
+		{method: http.MethodGet, path: "/~jane/saved", want: ScopeRead},
+		{method: http.MethodGet, path: "/2020/01/02/6c9a2b1f/hide", want: ScopeWrite},
+		{method: http.MethodGet, path: "/~jane/hidden", want: ScopeRead},
+		{method: http.MethodGet, path: "/~jane/follow", want: ScopeWrite},
+		{method: http.MethodGet, path: "/~jane/follow/accept", want: ScopeWrite},
+		{method: http.MethodGet, path: "/~jane/6c9a2b1f/yay", want: ScopeVote},
+		{method: http.MethodGet, path: "/2020/01/02/6c9a2b1f/nay", want: ScopeVote},
+		{method: http.MethodGet, path: "/~jane/6c9a2b1f/lock", want: ScopeRead},
+		{method: http.MethodGet, path: "/moderation/reports", want: ScopeRead},
+		{method: http.MethodPost, path: "/moderation/held/6c9a2b1f", want: ScopeWrite},
+		{method: http.MethodGet, path: "/confirm-email/6c9a2b1f", want: ""},
+		{method: http.MethodGet, path: "/~jane/settings", want: ""},
+		{method: http.MethodPost, path: "/~jane/settings/tokens", want: ""},
+		{method: http.MethodGet, path: "/logout", want: ""},
+		{method: http.MethodGet, path: "/auth/github", want: ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := requestScope(r); got != tt.want {
+			t.Errorf("requestScope(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorScope(t *testing.T) {
+	jane := &Account{Handle: "jane", Hash: HashFromString("dc6f5f5b-b6a1-4e47-b8f4-7dc8a5a1f8c1")}
+	john := &Account{Handle: "john", Hash: HashFromString("6d3d6e2c-7d2b-4a8f-9f7e-0f6b3b1d2c4a")}
+	it := &Item{SubmittedBy: jane}
+	if got := authorScope(jane, it); got != ScopeWrite {
+		t.Errorf("authorScope(author) = %q, want %q", got, ScopeWrite)
+	}
+	if got := authorScope(john, it); got != ScopeModerate {
+		t.Errorf("authorScope(moderator) = %q, want %q", got, ScopeModerate)
+	}
+}
+
+func TestSaveTokenAccount(t *testing.T) {
+	defer func() {
+		tokenAccounts.Lock()
+		tokenAccounts.m = make(map[string]Account)
+		tokenAccounts.Unlock()
+	}()
+	for i := 0; i < maxTokenAccounts+10; i++ {
+		saveTokenAccount(fmt.Sprintf("%s%d", accessTokenPrefix, i), Account{Handle: "jane", Metadata: &AccountMetadata{}})
+	}
+	tokenAccounts.RLock()
+	cnt := len(tokenAccounts.m)
+	tokenAccounts.RUnlock()
+	if cnt > maxTokenAccounts {
+		t.Errorf("saveTokenAccount() kept %d accounts, want at most %d", cnt, maxTokenAccounts)
+	}
+}
+
+func TestParseTokenScopes(t *testing.T) {
+	got, err := parseTokenScopes([]string{"vote", "Read", "invalid", "read"})
+	if err != nil {
+		t.Fatalf("parseTokenScopes() error: %s", err)
+	}
+	if want := []string{ScopeRead, ScopeVote}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTokenScopes() = %v, want %v", got, want)
+	}
+	if _, err := parseTokenScopes([]string{"admin"}); err == nil {
+		t.Errorf("parseTokenScopes() expected error for no valid scopes")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"Bearer littr_abc":       "littr_abc",
+		"bearer  littr_abc ":     "littr_abc",
+		"Basic amFuZTpzZWNyZXQ=": "",
+		"Bearer":                 "",
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", header)
+		if got := bearerToken(r); got != want {
+			t.Errorf("bearerToken(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestNewAccessToken(t *testing.T) {
+	raw, key, err := newAccessToken()
+	if err != nil {
+		t.Fatalf("newAccessToken() error: %s", err)
+	}
+	if !strings.HasPrefix(raw, accessTokenPrefix) {
+		t.Errorf("newAccessToken() = %s, missing %s prefix", raw, accessTokenPrefix)
+	}
+	if key != accessTokenKey(raw) || strings.Contains(key, raw) {
+		t.Errorf("newAccessToken() key %s doesn't match the token", key)
+	}
+	other, _, _ := newAccessToken()
+	if other == raw {
+		t.Errorf("newAccessToken() generated the same token twice")
+	}
+}
@@ -0,0 +1,429 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pub "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-chi/chi"
+)
+
+const (
+	// MaxModerationExportItems is the maximum number of moderation activities loaded for the exports of the moderation log
+	// and for the transparency report
+	MaxModerationExportItems = 10000
+	// moderationExportPageSize is the number of moderation activities we load from FedBOX with each request
+	moderationExportPageSize = 500
+	// moderationExportCacheTime is how long we keep in memory the moderation activities aggregated for an export,
+	// as loading them takes many requests to FedBOX
+	moderationExportCacheTime = time.Hour
+	// maxModerationExports is the number of aggregated moderation exports we keep in memory
+	maxModerationExports = 100
+)
+
+const dateParamFmt = "2006-01-02"
+
+// moderationLogEntry is the anonymized representation of a moderation activity, used in the exports of the moderation log.
+// NOTE(marius): like in the moderation log page, we don't show the accounts that made the requests.
+type moderationLogEntry struct {
+	ID        string    `json:"id"`
+	Date      time.Time `json:"date"`
+	Action    string    `json:"action"`
+	Object    string    `json:"object"`
+	URL       string    `json:"url,omitempty"`
+	InReplyTo string    `json:"inReplyTo,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+var moderationLogCSVHeader = []string{"id", "date", "action", "object", "url", "in_reply_to", "reason"}
+
+func (e moderationLogEntry) csv() []string {
+	return []string{
+		csvCell(e.ID),
+		e.Date.UTC().Format(time.RFC3339),
+		csvCell(e.Action),
+		csvCell(e.Object),
+		csvCell(e.URL),
+		csvCell(e.InReplyTo),
+		csvCell(e.Reason),
+	}
+}
+
+// csvCell escapes the values which spreadsheet applications would interpret as formulas
+func csvCell(s string) string {
+	if len(s) > 0 && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func moderationLogEntryFromOp(op *ModerationOp) moderationLogEntry {
+	e := moderationLogEntry{
+		ID:     op.Hash.String(),
+		Date:   op.SubmittedAt.UTC(),
+		Action: string(renderActivityLabel(op)),
+		Reason: op.Data,
+	}
+	if op.Object != nil {
+		e.Object = string(renderActivityLabel(op.Object))
+		e.URL = fmt.Sprintf("%s%s", Instance.BaseURL, PermaLink(op.Object))
+	}
+	if op.Metadata != nil && len(op.Metadata.InReplyTo) > 0 {
+		e.InReplyTo = HashFromIRI(op.Metadata.InReplyTo[0]).String()
+	}
+	return e
+}
+
+// moderationLogEntries flattens the moderation groups of the rl list into the requests and their followups,
+// sorted from the newest to the oldest
+func moderationLogEntries(rl RenderableList) []moderationLogEntry {
+	entries := make([]moderationLogEntry, 0)
+	seen := make(map[Hash]bool)
+	appendOp := func(op *ModerationOp) {
+		if op == nil || !op.IsValid() || seen[op.Hash] {
+			return
+		}
+		seen[op.Hash] = true
+		entries = append(entries, moderationLogEntryFromOp(op))
+	}
+	for _, r := range rl {
+		switch m := r.(type) {
+		case *ModerationGroup:
+			for _, op := range m.Requests {
+				appendOp(op)
+			}
+			for _, op := range m.Followup {
+				appendOp(op)
+			}
+		case *ModerationOp:
+			appendOp(m)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.After(entries[j].Date)
+	})
+	return entries
+}
+
+// moderationLogFilter selects the entries of the moderation log exports
+type moderationLogFilter struct {
+	Actions []string
+	Since   time.Time
+	Until   time.Time
+}
+
+func moderationLogFilterFromRequest(r *http.Request) (moderationLogFilter, error) {
+	f := moderationLogFilter{}
+	q := r.URL.Query()
+	for _, a := range q["action"] {
+		for _, aa := range strings.Split(a, ",") {
+			if aa = strings.ToLower(strings.TrimSpace(aa)); len(aa) > 0 {
+				f.Actions = append(f.Actions, aa)
+			}
+		}
+	}
+	var err error
+	if since := q.Get("since"); len(since) > 0 {
+		if f.Since, err = time.Parse(dateParamFmt, since); err != nil {
+			return f, errors.BadRequestf("invalid since date %q, expected format is YYYY-MM-DD", since)
+		}
+	}
+	if until := q.Get("until"); len(until) > 0 {
+		if f.Until, err = time.Parse(dateParamFmt, until); err != nil {
+			return f, errors.BadRequestf("invalid until date %q, expected format is YYYY-MM-DD", until)
+		}
+		// NOTE(marius): the until date is inclusive
+		f.Until = f.Until.Add(24 * time.Hour)
+	}
+	return f, nil
+}
+
+// moderationActionTypes are the types of the activities which show up in the moderation log under each action
+var moderationActionTypes = map[string]pub.ActivityVocabularyTypes{
+	"report":     {pub.FlagType},
+	"escalate":   {pub.FlagType},
+	"block":      {pub.BlockType},
+	"defederate": {pub.BlockType},
+	"ignore":     {pub.IgnoreType},
+	"dismiss":    {pub.RejectType},
+	"restrict":   {pub.RejectType},
+	"update":     {pub.UpdateType},
+	"delete":     {pub.DeleteType},
+}
+
+// activityTypes returns the types of activities FedBOX needs to load for the actions of the f filter.
+// It returns nil if some of the actions don't correspond to an activity type, and we need to load all of them.
+func (f moderationLogFilter) activityTypes() CompStrs {
+	if len(f.Actions) == 0 {
+		return nil
+	}
+	types := make(pub.ActivityVocabularyTypes, 0)
+	for _, a := range f.Actions {
+		tt, ok := moderationActionTypes[a]
+		if !ok {
+			return nil
+		}
+		for _, t := range tt {
+			if !types.Contains(t) {
+				types = append(types, t)
+			}
+		}
+	}
+	return ActivityTypesFilter(types...)
+}
+
+func (f moderationLogFilter) match(e moderationLogEntry) bool {
+	if len(f.Actions) > 0 && !stringInSlice(f.Actions)(e.Action) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Date.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Date.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func (f moderationLogFilter) apply(entries []moderationLogEntry) []moderationLogEntry {
+	result := make([]moderationLogEntry, 0)
+	for _, e := range entries {
+		if f.match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// LoadModerationExportMw loads the moderation activities for the exports of the moderation log and for the
+// transparency report. The types of the activities are filtered by FedBOX, and we go through the pages of the
+// service's inbox until we reach the since date of the request. It needs to run after ModerationFiltersMw.
+// The aggregated activities are kept in memory for moderationExportCacheTime.
+func LoadModerationExportMw(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mf, err := moderationLogFilterFromRequest(r)
+		if err != nil {
+			// NOTE(marius): the handler reports the invalid filters
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := moderationExportKey(r)
+		export, ok := loadModerationExport(key)
+		if !ok {
+			repo := ContextRepository(r.Context())
+			if export, err = repo.loadModerationExport(viewerContext(r), mf, ContextActivityFilters(r.Context())...); err != nil {
+				ctxtErr(next, w, r, err)
+				return
+			}
+			saveModerationExport(key, export)
+		}
+		cursor := &Cursor{items: make(RenderableList), total: export.total}
+		cursor.items.Merge(export.items)
+		ctx := context.WithValue(r.Context(), CursorCtxtKey, cursor)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loadModerationExport loads the moderation activities of the service's inbox which match the mf filters,
+// page by page, and aggregates them with their followups
+func (r *repository) loadModerationExport(ctx context.Context, mf moderationLogFilter, ff ...*Filters) (moderationExport, error) {
+	export := moderationExport{items: make(RenderableList)}
+	types := mf.activityTypes()
+	for _, f := range ff {
+		f.MaxItems = moderationExportPageSize
+		if len(types) > 0 {
+			f.Type = types
+		}
+	}
+	for len(ff) > 0 && len(export.items) < MaxModerationExportItems {
+		page, err := r.LoadActorInbox(ctx, r.fedbox.Service(), ff...)
+		if err != nil {
+			return export, errors.Annotatef(err, "unable to load the %s's inbox", r.fedbox.Service().Type)
+		}
+		if len(page.items) == 0 {
+			break
+		}
+		export.items.Merge(page.items)
+		if !mf.Since.IsZero() && oldestDate(page.items).Before(mf.Since) {
+			// NOTE(marius): the inbox is sorted from the newest to the oldest activity, so the next pages
+			//   would be older than the since date
+			break
+		}
+		// NOTE(marius): LoadActorInbox moves the filters to their next page
+		remaining := make([]*Filters, 0, len(ff))
+		for _, f := range ff {
+			if len(f.Next) > 0 {
+				remaining = append(remaining, f)
+			}
+		}
+		ff = remaining
+	}
+	export.total = uint(len(export.items))
+	followups, _ := r.loadModerationFollowups(ctx, export.items)
+	export.items = aggregateModeration(export.items, followups)
+	export.at = time.Now()
+	return export, nil
+}
+
+// moderationExport holds the aggregated moderation activities loaded for an export of the moderation log
+type moderationExport struct {
+	items RenderableList
+	total uint
+	at    time.Time
+}
+
+// moderationExports are the aggregated moderation exports, keyed by the viewer and the filters of the request
+var moderationExports = struct {
+	sync.RWMutex
+	m map[string]moderationExport
+}{m: make(map[string]moderationExport)}
+
+func moderationExportKey(r *http.Request) string {
+	return fmt.Sprintf("%s %s?%s", loggedAccount(r).Hash, r.URL.Path, r.URL.Query().Encode())
+}
+
+func loadModerationExport(key string) (moderationExport, bool) {
+	moderationExports.RLock()
+	defer moderationExports.RUnlock()
+	e, ok := moderationExports.m[key]
+	if !ok || time.Since(e.at) > moderationExportCacheTime {
+		return moderationExport{}, false
+	}
+	return e, true
+}
+
+func saveModerationExport(key string, e moderationExport) {
+	moderationExports.Lock()
+	defer moderationExports.Unlock()
+
+	if len(moderationExports.m) >= maxModerationExports {
+		for k, ee := range moderationExports.m {
+			if time.Since(ee.at) > moderationExportCacheTime {
+				delete(moderationExports.m, k)
+			}
+		}
+		// NOTE(marius): when all of them are still fresh we drop some at random, they get reloaded when needed
+		for k := range moderationExports.m {
+			if len(moderationExports.m) < maxModerationExports {
+				break
+			}
+			delete(moderationExports.m, k)
+		}
+	}
+	moderationExports.m[key] = e
+}
+
+// oldestDate returns the date of the oldest element of the rl list
+func oldestDate(rl RenderableList) time.Time {
+	oldest := time.Time{}
+	for _, r := range rl {
+		if d := r.Date(); oldest.IsZero() || d.Before(oldest) {
+			oldest = d
+		}
+	}
+	return oldest
+}
+
+// HandleModerationExport serves /moderation/log.json and /moderation/log.csv requests
+func (h *handler) HandleModerationExport(w http.ResponseWriter, r *http.Request) {
+	f, err := moderationLogFilterFromRequest(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	entries := make([]moderationLogEntry, 0)
+	if c := ContextCursor(r.Context()); c != nil {
+		entries = f.apply(moderationLogEntries(c.items))
+	}
+
+	var dat []byte
+	switch format := chi.URLParam(r, "format"); format {
+	case "json":
+		if dat, err = json.Marshal(entries); err != nil {
+			h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to encode moderation log"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		buf := bytes.Buffer{}
+		cw := csv.NewWriter(&buf)
+		cw.Write(moderationLogCSVHeader)
+		for _, e := range entries {
+			cw.Write(e.csv())
+		}
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			h.v.HandleErrors(w, r, errors.Annotatef(err, "unable to encode moderation log"))
+			return
+		}
+		dat = buf.Bytes()
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="moderation-log.csv"`)
+	default:
+		h.v.HandleErrors(w, r, errors.NotFoundf("invalid moderation log format %q", format))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(dat)
+}
+
+// transparencyMonth sums up the moderation activities of a month
+type transparencyMonth struct {
+	Month         time.Time
+	Reports       int
+	Blocks        int
+	Deletions     int
+	Defederations int
+}
+
+// Total returns the number of moderation activities in the month
+func (t transparencyMonth) Total() int {
+	return t.Reports + t.Blocks + t.Deletions + t.Defederations
+}
+
+// transparencyReport groups the entries of the moderation log by month, from the newest to the oldest
+func transparencyReport(entries []moderationLogEntry) []transparencyMonth {
+	months := make([]transparencyMonth, 0)
+	index := make(map[time.Time]int)
+	for _, e := range entries {
+		month := time.Date(e.Date.Year(), e.Date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		i, ok := index[month]
+		if !ok {
+			months = append(months, transparencyMonth{Month: month})
+			i = len(months) - 1
+			index[month] = i
+		}
+		switch e.Action {
+		case "report":
+			months[i].Reports++
+		case "block":
+			months[i].Blocks++
+		case "delete":
+			months[i].Deletions++
+		case "defederate":
+			months[i].Defederations++
+		}
+	}
+	sort.SliceStable(months, func(i, j int) bool {
+		return months[i].Month.After(months[j].Month)
+	})
+	return months
+}
+
+// HandleTransparency serves /moderation/transparency request
+func (h *handler) HandleTransparency(w http.ResponseWriter, r *http.Request) {
+	m := &transparencyModel{Title: "Transparency report", Limit: MaxModerationExportItems}
+	if c := ContextCursor(r.Context()); c != nil {
+		m.Months = transparencyReport(moderationLogEntries(c.items))
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestModerationLogFilter(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.RFC3339, s)
+		return d
+	}
+	entries := []moderationLogEntry{
+		{ID: "1", Action: "block", Date: day("2020-03-31T23:00:00Z")},
+		{ID: "2", Action: "report", Date: day("2020-03-02T10:00:00Z")},
+		{ID: "3", Action: "delete", Date: day("2020-02-29T10:00:00Z")},
+		{ID: "4", Action: "defederate", Date: day("2020-02-01T00:00:00Z")},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "", want: []string{"1", "2", "3", "4"}},
+		{query: "action=block", want: []string{"1"}},
+		{query: "action=BLOCK,delete", want: []string{"1", "3"}},
+		{query: "action=report&action=defederate", want: []string{"2", "4"}},
+		{query: "since=2020-03-01", want: []string{"1", "2"}},
+		{query: "until=2020-03-02", want: []string{"2", "3", "4"}},
+		{query: "since=2020-02-29&until=2020-03-31&action=block,delete", want: []string{"1", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			f, err := moderationLogFilterFromRequest(httptest.NewRequest("GET", "/moderation/log.json?"+tt.query, nil))
+			if err != nil {
+				t.Fatalf("moderationLogFilterFromRequest() error: %s", err)
+			}
+			got := f.apply(entries)
+			if len(got) != len(tt.want) {
+				t.Fatalf("apply() returned %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, e := range got {
+				if e.ID != tt.want[i] {
+					t.Errorf("apply()[%d] = %s, want %s", i, e.ID, tt.want[i])
+				}
+			}
+		})
+	}
+	if _, err := moderationLogFilterFromRequest(httptest.NewRequest("GET", "/moderation/log.json?since=yesterday", nil)); err == nil {
+		t.Errorf("moderationLogFilterFromRequest() expected error for invalid date")
+	}
+}
+
+func TestTransparencyReport(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	}
+	entries := []moderationLogEntry{
+		{Action: "report", Date: date(2020, time.January, 3)},
+		{Action: "block", Date: date(2020, time.March, 1)},
+		{Action: "report", Date: date(2020, time.January, 20)},
+		{Action: "delete", Date: date(2020, time.January, 21)},
+		{Action: "defederate", Date: date(2020, time.March, 30)},
+		{Action: "dismiss", Date: date(2020, time.March, 30)},
+	}
+	months := transparencyReport(entries)
+	if len(months) != 2 {
+		t.Fatalf("transparencyReport() returned %d months, want 2", len(months))
+	}
+	mar, jan := months[0], months[1]
+	if mar.Month.Month() != time.March || mar.Blocks != 1 || mar.Defederations != 1 || mar.Total() != 2 {
+		t.Errorf("March = %+v, want 1 block and 1 defederation", mar)
+	}
+	if jan.Month.Month() != time.January || jan.Reports != 2 || jan.Deletions != 1 || jan.Total() != 3 {
+		t.Errorf("January = %+v, want 2 reports and 1 deletion", jan)
+	}
+}
+
+func TestModerationLogFilter_activityTypes(t *testing.T) {
+	tests := []struct {
+		actions []string
+		want    []string
+	}{
+		{actions: nil, want: nil},
+		{actions: []string{"report", "escalate"}, want: []string{"Flag"}},
+		{actions: []string{"block", "defederate", "delete"}, want: []string{"Block", "Delete"}},
+		{actions: []string{"block", "unknown"}, want: nil},
+	}
+	for _, tt := range tests {
+		got := moderationLogFilter{Actions: tt.actions}.activityTypes()
+		if len(got) != len(tt.want) {
+			t.Errorf("activityTypes(%v) = %v, want %v", tt.actions, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Str != tt.want[i] {
+				t.Errorf("activityTypes(%v) = %v, want %v", tt.actions, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestModerationLogEntry_csv(t *testing.T) {
+	e := moderationLogEntry{
+		ID:     "1",
+		Date:   time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC),
+		Action: "delete",
+		Object: "@jane",
+		URL:    "https://example.com/~jane",
+		Reason: "=HYPERLINK(\"https://example.com\")",
+	}
+	want := []string{"1", "2020-03-02T10:00:00Z", "delete", "'@jane", "https://example.com/~jane", "", "'=HYPERLINK(\"https://example.com\")"}
+	got := e.csv()
+	if len(got) != len(want) {
+		t.Fatalf("csv() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("csv() cell %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	for _, s := range []string{"+1", "-1", "\tcmd"} {
+		if c := csvCell(s); c != "'"+s {
+			t.Errorf("csvCell(%q) = %q, want %q", s, c, "'"+s)
+		}
+	}
+}
+
+func TestModerationExports(t *testing.T) {
+	fresh := moderationExport{items: make(RenderableList), total: 1, at: time.Now()}
+	saveModerationExport("fresh", fresh)
+	saveModerationExport("stale", moderationExport{at: time.Now().Add(-2 * moderationExportCacheTime)})
+
+	if e, ok := loadModerationExport("fresh"); !ok || e.total != fresh.total {
+		t.Errorf("loadModerationExport(fresh) = %v, %t, want the saved export", e, ok)
+	}
+	if _, ok := loadModerationExport("stale"); ok {
+		t.Errorf("loadModerationExport(stale) returned an expired export")
+	}
+	for i := 0; i < maxModerationExports*2; i++ {
+		saveModerationExport(fmt.Sprintf("export-%d", i), fresh)
+	}
+	if l := len(moderationExports.m); l > maxModerationExports {
+		t.Errorf("kept %d exports, want at most %d", l, maxModerationExports)
+	}
+}
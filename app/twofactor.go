@@ -0,0 +1,450 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+	"github.com/mariusor/go-littr/internal/log"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const twoFactorCollection = "two-factor"
+
+const (
+	// RecoveryCodesCount is the number of one-time recovery codes generated when enabling two-factor authentication
+	RecoveryCodesCount = 10
+	// TwoFactorLoginTimeout is the time a user has for entering the second factor after logging in with the password
+	TwoFactorLoginTimeout = 5 * time.Minute
+)
+
+// SessionPendingUserKey holds the account that logged in with its password, but not yet with its second factor
+const SessionPendingUserKey = "__pending_acct"
+
+// SessionPendingAtKey holds the unix time of the password login for the pending account
+const SessionPendingAtKey = "__pending_at"
+
+var totpOpts = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// twoFactor is the second factor configuration of an account.
+// The key is saved when the enrollment is started, and the authentication is enabled once the account confirmed it
+// with a valid code.
+type twoFactor struct {
+	Key           string    `json:"key"`
+	Enabled       bool      `json:"enabled"`
+	EnabledAt     time.Time `json:"enabledAt,omitempty"`
+	RecoveryCodes []string  `json:"recoveryCodes,omitempty"`
+	LastCounter   int64     `json:"lastCounter,omitempty"`
+}
+
+// twoFactors are the second factor configurations, keyed by the account's ID.
+// NOTE(marius): we keep only the hashes of the recovery codes, but the TOTP secrets need to be readable for
+// validating the codes, so the file must be kept private.
+type twoFactors map[string]twoFactor
+
+func (t twoFactor) secret() string {
+	k, err := otp.NewKeyFromURL(t.Key)
+	if err != nil {
+		return ""
+	}
+	return k.Secret()
+}
+
+// validateTOTP checks the code against the TOTP secret at the now time, allowing for one period of clock skew.
+// It returns the counter of the matching period, which needs to be larger than the last counter used, so the
+// same code can't be used twice.
+func validateTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	code = strings.Join(strings.Fields(code), "")
+	if len(secret) == 0 || len(code) != totpOpts.Digits.Length() {
+		return last, false
+	}
+	period := int64(totpOpts.Period)
+	for _, skew := range []int64{0, -1, 1} {
+		counter := now.Unix()/period + skew
+		if counter <= last {
+			continue
+		}
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(counter*period, 0), totpOpts)
+		if err != nil {
+			return last, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return last, false
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), ""))
+	return strings.Replace(code, "-", "", -1)
+}
+
+func recoveryCodeKey(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes generates n one-time recovery codes, and the hashes we save for them
+func newRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, 0, n)
+	hashes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.Annotatef(err, "unable to generate recovery codes")
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		c = fmt.Sprintf("%s-%s", c[:4], c[4:])
+		codes = append(codes, c)
+		hashes = append(hashes, recoveryCodeKey(c))
+	}
+	return codes, hashes, nil
+}
+
+// useRecoveryCode removes the code from the recovery codes of t, returning false if it's not one of them
+func (t *twoFactor) useRecoveryCode(code string) bool {
+	key := recoveryCodeKey(code)
+	for i, h := range t.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(key)) == 1 {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i], t.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the code as a TOTP code, or as one of the recovery codes, and marks it as used
+func (t *twoFactor) verify(code string, now time.Time) bool {
+	if counter, ok := validateTOTP(t.secret(), code, now, t.LastCounter); ok {
+		t.LastCounter = counter
+		return true
+	}
+	return t.Enabled && t.useRecoveryCode(code)
+}
+
+func (r *repository) loadTwoFactor(a Account) (twoFactor, error) {
+	all := make(twoFactors)
+	if err := r.store.Load(twoFactorCollection, &all); err != nil {
+		return twoFactor{}, err
+	}
+	return all[accountRoleID(a)], nil
+}
+
+// TwoFactorEnabled returns true if the a account logs in with a second factor
+func (r *repository) TwoFactorEnabled(a Account) bool {
+	t, err := r.loadTwoFactor(a)
+	if err != nil {
+		r.errFn(log.Ctx{"err": err.Error(), "handle": a.Handle})("unable to load two-factor authentication")
+		return false
+	}
+	return t.Enabled
+}
+
+// StartTwoFactor returns the TOTP key the a account needs to add to its authenticator app.
+// The key is kept until the enrollment is confirmed, so reloading the page shows the same one.
+func (r *repository) StartTwoFactor(a Account, issuer string) (*otp.Key, error) {
+	all := make(twoFactors)
+	var key *otp.Key
+	err := r.store.Update(twoFactorCollection, &all, func() error {
+		t := all[accountRoleID(a)]
+		if t.Enabled {
+			return errors.BadRequestf("two-factor authentication is already enabled")
+		}
+		if len(t.Key) > 0 {
+			k, err := otp.NewKeyFromURL(t.Key)
+			if err == nil {
+				key = k
+				return nil
+			}
+		}
+		k, err := totp.Generate(totp.GenerateOpts{
+			Issuer:      issuer,
+			AccountName: a.Handle,
+			Period:      totpOpts.Period,
+			Digits:      totpOpts.Digits,
+			Algorithm:   totpOpts.Algorithm,
+		})
+		if err != nil {
+			return errors.Annotatef(err, "unable to generate two-factor authentication key")
+		}
+		key = k
+		all[accountRoleID(a)] = twoFactor{Key: k.String()}
+		return nil
+	})
+	return key, err
+}
+
+// EnableTwoFactor enables two-factor authentication for the a account if the code is valid for its pending key,
+// and returns its recovery codes
+func (r *repository) EnableTwoFactor(a Account, code string) ([]string, error) {
+	all := make(twoFactors)
+	var codes []string
+	err := r.store.Update(twoFactorCollection, &all, func() error {
+		t, ok := all[accountRoleID(a)]
+		if !ok || len(t.Key) == 0 {
+			return errors.BadRequestf("two-factor authentication was not started")
+		}
+		if t.Enabled {
+			return errors.BadRequestf("two-factor authentication is already enabled")
+		}
+		if !t.verify(code, time.Now()) {
+			return errors.BadRequestf("invalid authentication code")
+		}
+		var err error
+		if codes, t.RecoveryCodes, err = newRecoveryCodes(RecoveryCodesCount); err != nil {
+			return err
+		}
+		t.Enabled = true
+		t.EnabledAt = time.Now().UTC()
+		all[accountRoleID(a)] = t
+		return nil
+	})
+	return codes, err
+}
+
+// VerifyTwoFactor checks the code, or the recovery code, of the a account
+func (r *repository) VerifyTwoFactor(a Account, code string) error {
+	all := make(twoFactors)
+	return r.store.Update(twoFactorCollection, &all, func() error {
+		t, ok := all[accountRoleID(a)]
+		if !ok || !t.Enabled {
+			return errors.BadRequestf("two-factor authentication is not enabled")
+		}
+		if !t.verify(code, time.Now()) {
+			return errors.Unauthorizedf("invalid authentication code")
+		}
+		all[accountRoleID(a)] = t
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the a account, if the code is valid
+func (r *repository) RegenerateRecoveryCodes(a Account, code string) ([]string, error) {
+	all := make(twoFactors)
+	var codes []string
+	err := r.store.Update(twoFactorCollection, &all, func() error {
+		t, ok := all[accountRoleID(a)]
+		if !ok || !t.Enabled {
+			return errors.BadRequestf("two-factor authentication is not enabled")
+		}
+		if !t.verify(code, time.Now()) {
+			return errors.BadRequestf("invalid authentication code")
+		}
+		var err error
+		if codes, t.RecoveryCodes, err = newRecoveryCodes(RecoveryCodesCount); err != nil {
+			return err
+		}
+		all[accountRoleID(a)] = t
+		return nil
+	})
+	return codes, err
+}
+
+// DisableTwoFactor removes the second factor of the a account
+func (r *repository) DisableTwoFactor(a Account) error {
+	all := make(twoFactors)
+	return r.store.Update(twoFactorCollection, &all, func() error {
+		if t, ok := all[accountRoleID(a)]; !ok || !t.Enabled {
+			return errors.NotFoundf("two-factor authentication is not enabled for %s", a.Handle)
+		}
+		delete(all, accountRoleID(a))
+		return nil
+	})
+}
+
+// qrCodeDataURI renders the key as a PNG QR code, to be used as the source of an image
+func qrCodeDataURI(key *otp.Key) (template.URL, error) {
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to generate QR code")
+	}
+	buf := bytes.Buffer{}
+	if err = png.Encode(&buf, img); err != nil {
+		return "", errors.Annotatef(err, "unable to encode QR code")
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// requireTwoFactor redirects the moderators that didn't enable two-factor authentication to its settings page,
+// when the instance requires it. It returns false if the request was redirected.
+func (h *handler) requireTwoFactor(w http.ResponseWriter, r *http.Request) bool {
+	acc := loggedAccount(r)
+	if !h.conf.RequireModerator2FA || !acc.HasModerationRole() || h.storage.TwoFactorEnabled(*acc) {
+		return true
+	}
+	h.v.addFlashMessage(Warning, w, r, "Moderators need to enable two-factor authentication before taking moderation actions")
+	h.v.Redirect(w, r, fmt.Sprintf("%s/settings/2fa", PermaLink(acc)), http.StatusSeeOther)
+	return false
+}
+
+func (h *handler) renderTwoFactor(w http.ResponseWriter, r *http.Request, acc *Account, codes []string) {
+	m := &twoFactorModel{Title: "Two-factor authentication", User: acc, RecoveryCodes: codes}
+	t, err := h.storage.loadTwoFactor(*acc)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	m.Enabled = t.Enabled
+	m.Remaining = len(t.RecoveryCodes)
+	if !m.Enabled {
+		key, err := h.storage.StartTwoFactor(*acc, h.conf.Name)
+		if err != nil {
+			h.v.HandleErrors(w, r, err)
+			return
+		}
+		m.Secret = key.Secret()
+		if m.QRCode, err = qrCodeDataURI(key); err != nil {
+			h.errFn(log.Ctx{"err": err.Error()})("unable to render two-factor QR code")
+		}
+	}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleTwoFactor serves /~{handle}/settings/2fa request
+func (h *handler) HandleTwoFactor(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	h.renderTwoFactor(w, r, acc, nil)
+}
+
+// HandleSaveTwoFactor serves /~{handle}/settings/2fa POST request
+func (h *handler) HandleSaveTwoFactor(w http.ResponseWriter, r *http.Request) {
+	acc, err := validateOwnAccount(r)
+	if err != nil {
+		h.v.HandleErrors(w, r, err)
+		return
+	}
+	backURL := fmt.Sprintf("%s/settings/2fa", PermaLink(acc))
+	code := r.PostFormValue("code")
+
+	var codes []string
+	action := r.PostFormValue("action")
+	switch action {
+	case "enable":
+		codes, err = h.storage.EnableTwoFactor(*acc, code)
+	case "recovery":
+		codes, err = h.storage.RegenerateRecoveryCodes(*acc, code)
+	case "disable":
+		if err = h.storage.VerifyTwoFactor(*acc, code); err == nil {
+			err = h.storage.DisableTwoFactor(*acc)
+		}
+	default:
+		err = errors.BadRequestf("invalid action %q", action)
+	}
+	if err != nil {
+		h.infoFn(log.Ctx{"err": err.Error(), "action": action, "handle": acc.Handle})("two-factor authentication change failed")
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to %s two-factor authentication: %s", action, err))
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	h.infoFn(log.Ctx{"action": action, "handle": acc.Handle})("two-factor authentication changed")
+	if action == "disable" {
+		h.v.addFlashMessage(Success, w, r, "Two-factor authentication disabled")
+		h.v.Redirect(w, r, backURL, http.StatusSeeOther)
+		return
+	}
+	// NOTE(marius): the recovery codes are shown only once, so we render them instead of redirecting
+	h.renderTwoFactor(w, r, acc, codes)
+}
+
+// HandleResetTwoFactor serves /~{handle}/2fa/reset POST request
+// It allows the administrators to disable the second factor of the accounts that lost access to it.
+func (h *handler) HandleResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	acc := loggedAccount(r)
+	authors := ContextAuthors(r.Context())
+	if len(authors) == 0 {
+		h.v.HandleErrors(w, r, errors.NotFoundf("account not found"))
+		return
+	}
+	a := authors[0]
+	if err := h.storage.DisableTwoFactor(a); err != nil {
+		h.v.addFlashMessage(Error, w, r, fmt.Sprintf("Unable to disable two-factor authentication: %s", err))
+	} else {
+		h.infoFn(log.Ctx{"handle": a.Handle, "by": acc.Handle})("two-factor authentication disabled by admin")
+		h.v.addFlashMessage(Success, w, r, fmt.Sprintf("Two-factor authentication disabled for %s", a.Handle))
+	}
+	h.v.Redirect(w, r, PermaLink(&a), http.StatusSeeOther)
+}
+
+// pendingLogin returns the account waiting for its second factor, if it logged in with its password recently enough
+func (v *view) pendingLogin(w http.ResponseWriter, r *http.Request) (Account, bool) {
+	if !v.s.enabled || w == nil || r == nil {
+		return AnonymousAccount, false
+	}
+	s, err := v.s.get(w, r)
+	if err != nil || s == nil {
+		return AnonymousAccount, false
+	}
+	acc, ok := s.Values[SessionPendingUserKey].(Account)
+	if !ok {
+		return AnonymousAccount, false
+	}
+	at, _ := s.Values[SessionPendingAtKey].(int64)
+	if time.Since(time.Unix(at, 0)) > TwoFactorLoginTimeout {
+		delete(s.Values, SessionPendingUserKey)
+		delete(s.Values, SessionPendingAtKey)
+		return AnonymousAccount, false
+	}
+	return acc, true
+}
+
+// HandleTwoFactorLoginForm serves /login/2fa request
+func (h *handler) HandleTwoFactorLoginForm(w http.ResponseWriter, r *http.Request) {
+	acc, ok := h.v.pendingLogin(w, r)
+	if !ok {
+		h.v.addFlashMessage(Error, w, r, "Login expired, please try again")
+		h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	m := &twoFactorLoginModel{Title: "Two-factor authentication", Handle: acc.Handle}
+	h.v.RenderTemplate(r, w, m.Template(), m)
+}
+
+// HandleTwoFactorLogin serves /login/2fa POST request
+func (h *handler) HandleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	acc, ok := h.v.pendingLogin(w, r)
+	if !ok {
+		h.v.addFlashMessage(Error, w, r, "Login expired, please try again")
+		h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := h.storage.VerifyTwoFactor(acc, r.PostFormValue("code")); err != nil {
+		h.infoFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("two-factor login failed")
+		h.v.addFlashMessage(Error, w, r, "Login failed: invalid authentication code")
+		h.v.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return
+	}
+	s, err := h.v.s.get(w, r)
+	if err != nil {
+		h.errFn(log.Ctx{"err": err.Error(), "handle": acc.Handle})("unable to save session")
+		h.v.addFlashMessage(Error, w, r, "Login failed: unable to save session")
+		h.v.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	delete(s.Values, SessionPendingUserKey)
+	delete(s.Values, SessionPendingAtKey)
+	s.Values[SessionUserKey] = acc
+	s.Values[SessionLoginKey] = time.Now().Unix()
+	h.v.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "littr", AccountName: "jane"})
+	if err != nil {
+		t.Fatalf("totp.Generate() error: %s", err)
+	}
+	secret := key.Secret()
+	now := time.Now()
+	code, err := totp.GenerateCodeCustom(secret, now, totpOpts)
+	if err != nil {
+		t.Fatalf("totp.GenerateCodeCustom() error: %s", err)
+	}
+
+	counter, ok := validateTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatalf("validateTOTP(%q) = false, want true", code)
+	}
+	if _, ok := validateTOTP(secret, code, now, counter); ok {
+		t.Errorf("validateTOTP(%q) = true for a code that was already used", code)
+	}
+	spaced := code[:3] + " " + code[3:]
+	if _, ok := validateTOTP(secret, spaced, now, 0); !ok {
+		t.Errorf("validateTOTP(%q) = false, want true", spaced)
+	}
+	if _, ok := validateTOTP(secret, code, now.Add(30*time.Second), 0); !ok {
+		t.Errorf("validateTOTP(%q) = false for the next period, want true", code)
+	}
+	if _, ok := validateTOTP(secret, code, now.Add(5*time.Minute), 0); ok {
+		t.Errorf("validateTOTP(%q) = true for an old code", code)
+	}
+	if _, ok := validateTOTP("", code, now, 0); ok {
+		t.Errorf("validateTOTP(%q) = true for an empty secret", code)
+	}
+}
+
+func TestTwoFactor_verify(t *testing.T) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "littr", AccountName: "jane"})
+	if err != nil {
+		t.Fatalf("totp.Generate() error: %s", err)
+	}
+	codes, hashes, err := newRecoveryCodes(RecoveryCodesCount)
+	if err != nil {
+		t.Fatalf("newRecoveryCodes() error: %s", err)
+	}
+	if len(codes) != RecoveryCodesCount || len(hashes) != RecoveryCodesCount {
+		t.Fatalf("newRecoveryCodes() returned %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodesCount)
+	}
+	for i, c := range codes {
+		if hashes[i] == c || strings.Contains(hashes[i], normalizeRecoveryCode(c)) {
+			t.Errorf("recovery code hash %q contains the code %q", hashes[i], c)
+		}
+	}
+
+	tf := twoFactor{Key: key.String(), RecoveryCodes: hashes}
+	if tf.verify(codes[0], time.Now()) {
+		t.Errorf("verify() = true for a recovery code before two-factor authentication was enabled")
+	}
+	tf.Enabled = true
+	if !tf.verify(strings.ToUpper(codes[0]), time.Now()) {
+		t.Errorf("verify(%q) = false, want true", codes[0])
+	}
+	if tf.verify(codes[0], time.Now()) {
+		t.Errorf("verify(%q) = true for a recovery code that was already used", codes[0])
+	}
+	if len(tf.RecoveryCodes) != RecoveryCodesCount-1 {
+		t.Errorf("recovery codes left = %d, want %d", len(tf.RecoveryCodes), RecoveryCodesCount-1)
+	}
+	code, _ := totp.GenerateCodeCustom(key.Secret(), time.Now(), totpOpts)
+	if !tf.verify(code, time.Now()) {
+		t.Errorf("verify(%q) = false, want true", code)
+	}
+	if tf.verify(code, time.Now()) {
+		t.Errorf("verify(%q) = true for a code that was already used", code)
+	}
+}
@@ -93,7 +93,15 @@ func renderActivityLabel(r Renderable) template.HTML {
 	case ActorType:
 		return "account"
 	case ModerationType:
-		if i, ok := r.(Moderatable); ok {
+		if op, ok := r.(*ModerationOp); ok && op.IsDismiss() {
+			lbl = "dismiss"
+		} else if ok && op.IsInviteRestriction() {
+			lbl = "restrict"
+		} else if ok && op.IsEscalation() {
+			lbl = "escalate"
+		} else if ok && op.IsDefederation() {
+			lbl = "defederate"
+		} else if i, ok := r.(Moderatable); ok {
 			if i.IsBlock() {
 				lbl = "block"
 			} else if i.IsIgnore() {
@@ -165,6 +173,7 @@ func (v *view) RenderTemplate(r *http.Request, w http.ResponseWriter, name strin
 			"IsVote":                func(t Renderable) bool { return t.Type() == AppreciationType },
 			"IsAccount":             func(t Renderable) bool { return t.Type() == ActorType },
 			"IsModeration":          func(t Renderable) bool { return t.Type() == ModerationType },
+			"IsHeld":                func(t Renderable) bool { return t.Type() == HeldType },
 			"SessionEnabled":        func() bool { return v.s.enabled },
 			"LoadFlashMessages":     v.loadFlashMessages(w, r),
 			"Mod10":                 mod10,
@@ -229,6 +238,9 @@ func (v *view) RenderTemplate(r *http.Request, w http.ResponseWriter, name strin
 			"AccountIsBlocked":      func(a *Account) bool { return AccountIsBlocked(accountFromRequest(), a) },
 			"AccountIsReported":     func(a *Account) bool { return AccountIsReported(accountFromRequest(), a) },
 			"ItemReported":          func(i *Item) bool { return ItemIsReported(accountFromRequest(), i) },
+			"ItemSaved":             func(i *Item) bool { return accountFromRequest().Saved.Contains(i.Hash) },
+			"ItemHidden":            func(i *Item) bool { return hidesItem(accountFromRequest(), i) },
+			"ShowHistoryLink":       func(i *Item) bool { return showHistoryLink(accountFromRequest(), i) },
 			"RenderLabel":           renderActivityLabel,
 			csrf.TemplateTag:        func() template.HTML { return csrf.TemplateField(r) },
 			"ToTitle":               ToTitle,
@@ -649,6 +661,9 @@ func avatar(mime, data string) template.HTML {
 		}
 		return template.HTML(data)
 	}
+	if strings.HasPrefix(data, "https://") || strings.HasPrefix(data, "http://") {
+		return template.HTML(fmt.Sprintf("<img src='%s' width='48' height='48' class='icon avatar' alt='avatar' />", template.HTMLEscapeString(data)))
+	}
 	return template.HTML(fmt.Sprintf("<image src='data:%s;base64,%s' width='48' height='48' class='icon avatar' />", mime, data))
 }
 
@@ -891,6 +906,14 @@ func showAccountBlockLink(by, current *Account) bool {
 	return true
 }
 
+// showHistoryLink returns true if the by account can see the revisions of the it item
+func showHistoryLink(by *Account, it *Item) bool {
+	if !by.IsLogged() {
+		return false
+	}
+	return isItemAuthor(by, it) || by.CanModerate(it)
+}
+
 func showFollowLink(by, current *Account) bool {
 	if !Instance.Conf.UserFollowingEnabled {
 		return false
@@ -22,6 +22,7 @@ require (
 	github.com/microcosm-cc/bluemonday v1.0.4
 	github.com/openshift/osin v1.0.1
 	github.com/pborman/uuid v1.2.1 // indirect
+	github.com/pquerna/otp v1.3.0
 	github.com/sabhiram/go-gitignore v0.0.0-20180611051255-d3107576ba94
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spacemonkeygo/httpsig v0.0.0-20181218213338-2605ae379e47
@@ -33,7 +34,7 @@ require (
 	github.com/writeas/go-webfinger v0.0.0-20190106002315-85cf805c86d2 // indirect
 	gitlab.com/golang-commonmark/linkify v0.0.0-20200225224916-64bca66f6ad3 // indirect
 	gitlab.com/golang-commonmark/markdown v0.0.0-20191127184510-91b5b3c99c19
-	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sync v0.0.0-20190423024810-112230192c58
 	golang.org/x/sys v0.0.0-20200327173247-9dae0f8f5775 // indirect
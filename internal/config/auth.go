@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AuthProvider is an OAuth2 or OpenID Connect identity provider the users can log in with.
+// When the Issuer is set, the endpoints are loaded through OpenID Connect discovery.
+type AuthProvider struct {
+	Name           string
+	Label          string
+	Issuer         string
+	ClientID       string
+	ClientSecret   string
+	AuthURL        string
+	TokenURL       string
+	UserInfoURL    string
+	Scopes         []string
+	CreateAccounts bool
+	LinkByEmail    bool
+}
+
+// IsOIDC returns true if the provider supports OpenID Connect
+func (p AuthProvider) IsOIDC() bool {
+	return len(p.Issuer) > 0
+}
+
+// IsValid returns true if the provider has enough information to be used for logging in
+func (p AuthProvider) IsValid() bool {
+	return len(p.Name) > 0 && len(p.ClientID) > 0 && (p.IsOIDC() || (len(p.AuthURL) > 0 && len(p.TokenURL) > 0))
+}
+
+const (
+	KeyAuthProviders = "AUTH_PROVIDERS"
+
+	keyAuthIssuer         = "ISSUER"
+	keyAuthLabel          = "LABEL"
+	keyAuthClientID       = "CLIENT_ID"
+	keyAuthClientSecret   = "CLIENT_SECRET"
+	keyAuthAuthURL        = "AUTH_URL"
+	keyAuthTokenURL       = "TOKEN_URL"
+	keyAuthUserInfoURL    = "USERINFO_URL"
+	keyAuthScopes         = "SCOPES"
+	keyAuthCreateAccounts = "CREATE_ACCOUNTS"
+	keyAuthLinkByEmail    = "LINK_BY_EMAIL"
+)
+
+// authProviderPresets are the endpoints of the well known providers, and the environment variables we used to load
+// their credentials from, before the providers were configurable
+var authProviderPresets = map[string]struct {
+	AuthProvider
+	keyEnv    string
+	secretEnv string
+}{
+	"github": {
+		AuthProvider: AuthProvider{
+			Label:       "GitHub",
+			AuthURL:     "https://github.com/login/oauth/authorize",
+			TokenURL:    "https://github.com/login/oauth/access_token",
+			UserInfoURL: "https://api.github.com/user",
+			Scopes:      []string{"read:user", "user:email"},
+		},
+		keyEnv:    "GITHUB_KEY",
+		secretEnv: "GITHUB_SECRET",
+	},
+	"gitlab": {
+		AuthProvider: AuthProvider{Label: "GitLab", Issuer: "https://gitlab.com"},
+		keyEnv:       "GITLAB_KEY",
+		secretEnv:    "GITLAB_SECRET",
+	},
+	"google": {
+		AuthProvider: AuthProvider{Label: "Google", Issuer: "https://accounts.google.com"},
+		keyEnv:       "GOOGLE_KEY",
+		secretEnv:    "GOOGLE_SECRET",
+	},
+	"facebook": {
+		AuthProvider: AuthProvider{
+			Label:       "Facebook",
+			AuthURL:     "https://www.facebook.com/dialog/oauth",
+			TokenURL:    "https://graph.facebook.com/oauth/access_token",
+			UserInfoURL: "https://graph.facebook.com/me?fields=id,name,email",
+			Scopes:      []string{"email"},
+		},
+		keyEnv:    "FACEBOOK_KEY",
+		secretEnv: "FACEBOOK_SECRET",
+	},
+}
+
+func authProviderKey(name, k string) string {
+	return fmt.Sprintf("AUTH_%s_%s", strings.ToUpper(strings.Replace(name, "-", "_", -1)), k)
+}
+
+// loadAuthProvider loads the name provider from the AUTH_<NAME>_* environment variables
+func loadAuthProvider(name string) AuthProvider {
+	name = strings.ToLower(name)
+	p := AuthProvider{Name: name, Label: name}
+	preset, hasPreset := authProviderPresets[name]
+	if hasPreset {
+		p = preset.AuthProvider
+		p.Name = name
+		p.ClientID = loadKeyFromEnv(preset.keyEnv, "")
+		p.ClientSecret = loadKeyFromEnv(preset.secretEnv, "")
+	}
+	load := func(k string, v *string) {
+		if val := loadKeyFromEnv(authProviderKey(name, k), ""); len(val) > 0 {
+			*v = val
+		}
+	}
+	load(keyAuthLabel, &p.Label)
+	load(keyAuthIssuer, &p.Issuer)
+	load(keyAuthClientID, &p.ClientID)
+	load(keyAuthClientSecret, &p.ClientSecret)
+	load(keyAuthAuthURL, &p.AuthURL)
+	load(keyAuthTokenURL, &p.TokenURL)
+	load(keyAuthUserInfoURL, &p.UserInfoURL)
+	if scopes := strings.Fields(strings.Replace(loadKeyFromEnv(authProviderKey(name, keyAuthScopes), ""), ",", " ", -1)); len(scopes) > 0 {
+		p.Scopes = scopes
+	}
+	p.Issuer = strings.TrimRight(p.Issuer, "/")
+	p.CreateAccounts, _ = strconv.ParseBool(loadKeyFromEnv(authProviderKey(name, keyAuthCreateAccounts), ""))
+	p.LinkByEmail, _ = strconv.ParseBool(loadKeyFromEnv(authProviderKey(name, keyAuthLinkByEmail), ""))
+	return p
+}
+
+// loadAuthProviders loads the valid providers from the comma separated list of names
+func loadAuthProviders(names string) []AuthProvider {
+	providers := make([]AuthProvider, 0)
+	for _, name := range splitList(names, ",") {
+		if name == "fedbox" || name == "local" {
+			continue
+		}
+		if p := loadAuthProvider(name); p.IsValid() {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// AuthProvider returns the login provider with the name
+func (c Configuration) AuthProvider(name string) (AuthProvider, bool) {
+	for _, p := range c.AuthProviders {
+		if p.Name == strings.ToLower(name) {
+			return p, true
+		}
+	}
+	return AuthProvider{}, false
+}
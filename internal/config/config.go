@@ -6,6 +6,7 @@ import (
 	"github.com/mariusor/go-littr/internal/log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -32,12 +33,43 @@ type Configuration struct {
 	UserFollowingEnabled       bool
 	ModerationEnabled          bool
 	MaintenanceMode            bool
+	UploadsEnabled             bool
+	StoragePath                string
+	MaxUploadSize              int64
+	Admins                     []string
+	InviteQuota                int
+	InviteExpiry               time.Duration
+	MailSink                   string
+	MailFrom                   string
+	MailPath                   string
+	SMTPAddr                   string
+	SMTPUser                   string
+	SMTPPassword               string
+	RateLimits                 string
+	TrustedProxies             []string
+	NewAccountAge              time.Duration
+	ContentChecks              string
+	BannedWords                []string
+	BannedPatterns             []string
+	BlockedDomains             []string
+	MaxLinks                   int
+	RepeatWindow               time.Duration
+	RequireModerator2FA        bool
+	AuthProviders              []AuthProvider
 }
 
 const (
-	DefaultListenPort = 3000
-	DefaultListenHost = ""
-	Prefix            = "LITTR"
+	DefaultListenPort    = 3000
+	DefaultListenHost    = ""
+	DefaultStoragePath   = "storage"
+	DefaultMaxUploadSize = 10 << 20
+	DefaultInviteQuota   = 10
+	DefaultInviteExpiry  = 7 * 24 * time.Hour
+	DefaultMailSink      = ""
+	DefaultNewAccountAge = 7 * 24 * time.Hour
+	DefaultMaxLinks      = 10
+	DefaultRepeatWindow  = 24 * time.Hour
+	Prefix               = "LITTR"
 )
 
 const (
@@ -60,6 +92,28 @@ const (
 	KeyDisableUserFollowing       = "DISABLE_USER_FOLLOWING"
 	KeyDisableModeration          = "DISABLE_MODERATION"
 	KeyAdminContact               = "ADMIN_CONTACT"
+	KeyDisableUploads             = "DISABLE_UPLOADS"
+	KeyStoragePath                = "STORAGE_PATH"
+	KeyMaxUploadSize              = "MAX_UPLOAD_SIZE"
+	KeyAdmins                     = "ADMINS"
+	KeyInviteQuota                = "INVITE_QUOTA"
+	KeyInviteExpiry               = "INVITE_EXPIRY"
+	KeyMailSink                   = "MAIL_SINK"
+	KeyMailFrom                   = "MAIL_FROM"
+	KeyMailPath                   = "MAIL_PATH"
+	KeySMTPAddr                   = "SMTP_ADDR"
+	KeySMTPUser                   = "SMTP_USER"
+	KeySMTPPassword               = "SMTP_PASSWORD"
+	KeyRateLimits                 = "RATE_LIMITS"
+	KeyTrustedProxies             = "TRUSTED_PROXIES"
+	KeyNewAccountAge              = "NEW_ACCOUNT_AGE"
+	KeyContentChecks              = "CONTENT_CHECKS"
+	KeyBannedWords                = "BANNED_WORDS"
+	KeyBannedPatterns             = "BANNED_PATTERNS"
+	KeyBlockedDomains             = "BLOCKED_DOMAINS"
+	KeyMaxLinks                   = "MAX_LINKS"
+	KeyRepeatWindow               = "REPEAT_WINDOW"
+	KeyRequireModerator2FA        = "REQUIRE_MODERATOR_2FA"
 )
 
 func prefKey(k string) string {
@@ -79,6 +133,17 @@ func loadKeyFromEnv(name, def string) string {
 	return def
 }
 
+// splitList returns the non empty values of the sep separated list
+func splitList(s, sep string) []string {
+	var list []string
+	for _, v := range strings.Split(s, sep) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func Load(e EnvType, wait time.Duration) *Configuration {
 	c := &Default
 	configs := []string{
@@ -158,6 +223,50 @@ func Load(e EnvType, wait time.Duration) *Configuration {
 
 	c.APIURL = loadKeyFromEnv(KeyAPIUrl, "")
 
+	uploadsDisabled, _ := strconv.ParseBool(loadKeyFromEnv(KeyDisableUploads, "")) // DISABLE_UPLOADS
+	c.UploadsEnabled = !uploadsDisabled
+	c.StoragePath = loadKeyFromEnv(KeyStoragePath, DefaultStoragePath) // STORAGE_PATH
+	if size, _ := strconv.ParseInt(loadKeyFromEnv(KeyMaxUploadSize, ""), 10, 64); size > 0 {
+		c.MaxUploadSize = size
+	} else {
+		c.MaxUploadSize = DefaultMaxUploadSize
+	}
+	c.Admins = splitList(loadKeyFromEnv(KeyAdmins, ""), ",") // ADMINS
+	c.InviteQuota = DefaultInviteQuota
+	if quota, err := strconv.ParseInt(loadKeyFromEnv(KeyInviteQuota, ""), 10, 32); err == nil && quota >= 0 { // INVITE_QUOTA
+		c.InviteQuota = int(quota)
+	}
+	c.InviteExpiry = DefaultInviteExpiry
+	if exp, err := time.ParseDuration(loadKeyFromEnv(KeyInviteExpiry, "")); err == nil && exp >= 0 { // INVITE_EXPIRY
+		c.InviteExpiry = exp
+	}
+	c.MailSink = strings.ToLower(loadKeyFromEnv(KeyMailSink, DefaultMailSink))      // MAIL_SINK
+	c.MailFrom = loadKeyFromEnv(KeyMailFrom, fmt.Sprintf("noreply@%s", c.HostName)) // MAIL_FROM
+	c.MailPath = loadKeyFromEnv(KeyMailPath, filepath.Join(c.StoragePath, "mail"))  // MAIL_PATH
+	c.SMTPAddr = loadKeyFromEnv(KeySMTPAddr, "")                                    // SMTP_ADDR
+	c.SMTPUser = loadKeyFromEnv(KeySMTPUser, "")                                    // SMTP_USER
+	c.SMTPPassword = loadKeyFromEnv(KeySMTPPassword, "")                            // SMTP_PASSWORD
+	c.RateLimits = loadKeyFromEnv(KeyRateLimits, "")                                // RATE_LIMITS
+	c.TrustedProxies = splitList(loadKeyFromEnv(KeyTrustedProxies, ""), ",")        // TRUSTED_PROXIES
+	c.NewAccountAge = DefaultNewAccountAge
+	if age, err := time.ParseDuration(loadKeyFromEnv(KeyNewAccountAge, "")); err == nil && age >= 0 { // NEW_ACCOUNT_AGE
+		c.NewAccountAge = age
+	}
+	c.ContentChecks = loadKeyFromEnv(KeyContentChecks, "")                   // CONTENT_CHECKS
+	c.BannedWords = splitList(loadKeyFromEnv(KeyBannedWords, ""), ",")       // BANNED_WORDS
+	c.BannedPatterns = strings.Fields(loadKeyFromEnv(KeyBannedPatterns, "")) // BANNED_PATTERNS
+	c.BlockedDomains = splitList(loadKeyFromEnv(KeyBlockedDomains, ""), ",") // BLOCKED_DOMAINS
+	c.MaxLinks = DefaultMaxLinks
+	if max, err := strconv.ParseInt(loadKeyFromEnv(KeyMaxLinks, ""), 10, 32); err == nil && max >= 0 { // MAX_LINKS
+		c.MaxLinks = int(max)
+	}
+	c.RepeatWindow = DefaultRepeatWindow
+	if win, err := time.ParseDuration(loadKeyFromEnv(KeyRepeatWindow, "")); err == nil && win >= 0 { // REPEAT_WINDOW
+		c.RepeatWindow = win
+	}
+	c.RequireModerator2FA, _ = strconv.ParseBool(loadKeyFromEnv(KeyRequireModerator2FA, "")) // REQUIRE_MODERATOR_2FA
+	c.AuthProviders = loadAuthProviders(loadKeyFromEnv(KeyAuthProviders, ""))                // AUTH_PROVIDERS
+
 	return c
 }
 
@@ -0,0 +1,175 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ap/errors"
+)
+
+const (
+	// SinkSMTP delivers the messages to an SMTP server
+	SinkSMTP = "smtp"
+	// SinkFile saves the messages as .eml files in a local directory, for development
+	SinkFile = "file"
+	// SinkLog writes the messages to the application log, for development
+	SinkLog = "log"
+)
+
+// Message is an email to be delivered
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email messages
+type Sender interface {
+	Send(Message) error
+}
+
+// Config holds the settings for delivering email messages
+type Config struct {
+	Sink     string
+	Addr     string
+	User     string
+	Password string
+	From     string
+	Path     string
+}
+
+// LogFn is the function the log sink uses to output the messages
+type LogFn func(string, ...interface{})
+
+// New returns the Sender corresponding to the sink in the c configuration
+func New(c Config, l LogFn) (Sender, error) {
+	switch strings.ToLower(c.Sink) {
+	case SinkSMTP:
+		if len(c.Addr) == 0 {
+			return nil, errors.Newf("missing SMTP server address")
+		}
+		host, _, err := net.SplitHostPort(c.Addr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid SMTP server address %s", c.Addr)
+		}
+		s := smtpSender{addr: c.Addr, from: c.From}
+		if len(c.User) > 0 {
+			s.auth = smtp.PlainAuth("", c.User, c.Password, host)
+		}
+		return s, nil
+	case SinkFile:
+		if len(c.Path) == 0 {
+			return nil, errors.Newf("missing path for the mail files")
+		}
+		if err := os.MkdirAll(c.Path, 0700); err != nil {
+			return nil, errors.Annotatef(err, "unable to create mail path %s", c.Path)
+		}
+		return fileSender{path: c.Path, from: c.From}, nil
+	case SinkLog:
+		if l == nil {
+			l = func(string, ...interface{}) {}
+		}
+		return logSender{l: l, from: c.From}, nil
+	case "":
+		return nil, errors.Newf("no mail sink configured, the emails can not be delivered")
+	}
+	return nil, errors.Newf("invalid mail sink %q", c.Sink)
+}
+
+// Disabled returns a Sender that refuses all the messages with the err error.
+// It's meant to be used when the mail delivery could not be configured, so sending fails instead of dropping the messages.
+func Disabled(err error) Sender {
+	return disabledSender{err: err}
+}
+
+type disabledSender struct {
+	err error
+}
+
+// Send returns the error for which the mail delivery is disabled
+func (s disabledSender) Send(m Message) error {
+	return errors.Annotatef(s.err, "unable to send mail to %s", m.To)
+}
+
+// Format returns the m message, with its headers, as it would be sent over the wire
+func Format(from string, m Message) []byte {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func validMessage(m Message) error {
+	if len(m.To) == 0 || strings.ContainsAny(m.To, "\r\n") {
+		return errors.Newf("invalid recipient %q", m.To)
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.Newf("invalid subject %q", m.Subject)
+	}
+	return nil
+}
+
+type smtpSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// Send delivers the m message to the SMTP server
+func (s smtpSender) Send(m Message) error {
+	if err := validMessage(m); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, Format(s.from, m)); err != nil {
+		return errors.Annotatef(err, "unable to send mail to %s", m.To)
+	}
+	return nil
+}
+
+type fileSender struct {
+	path string
+	from string
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// Send saves the m message as an .eml file
+func (s fileSender) Send(m Message) error {
+	if err := validMessage(m); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(m.To, "_"))
+	if err := ioutil.WriteFile(filepath.Join(s.path, name), Format(s.from, m), 0600); err != nil {
+		return errors.Annotatef(err, "unable to save mail to %s", m.To)
+	}
+	return nil
+}
+
+type logSender struct {
+	l    LogFn
+	from string
+}
+
+// Send writes the m message to the log
+func (s logSender) Send(m Message) error {
+	if err := validMessage(m); err != nil {
+		return err
+	}
+	s.l("Mail from %s to %s: %s\n%s", s.from, m.To, m.Subject, m.Body)
+	return nil
+}
@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	m := Message{To: "jane@example.com", Subject: "Invitation", Body: "Hello,\nwelcome"}
+	raw := string(Format("littr@example.com", m))
+
+	for _, h := range []string{"From: littr@example.com\r\n", "To: jane@example.com\r\n", "Subject: Invitation\r\n", "Content-Type: text/plain; charset=utf-8\r\n"} {
+		if !strings.Contains(raw, h) {
+			t.Errorf("header %q not found in %q", h, raw)
+		}
+	}
+	if !strings.HasSuffix(raw, "\r\n\r\nHello,\r\nwelcome") {
+		t.Errorf("invalid body in %q", raw)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Config
+		wantErr bool
+	}{
+		{name: "log", c: Config{Sink: SinkLog}},
+		{name: "default", c: Config{}, wantErr: true},
+		{name: "smtp", c: Config{Sink: SinkSMTP, Addr: "localhost:25", User: "jane"}},
+		{name: "smtp-missing-addr", c: Config{Sink: SinkSMTP}, wantErr: true},
+		{name: "smtp-invalid-addr", c: Config{Sink: SinkSMTP, Addr: "localhost"}, wantErr: true},
+		{name: "file-missing-path", c: Config{Sink: SinkFile}, wantErr: true},
+		{name: "invalid", c: Config{Sink: "carrier-pigeon"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.c, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && s == nil {
+				t.Errorf("New() returned a nil sender")
+			}
+		})
+	}
+}
+
+func TestFileSender_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "littr-mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(Config{Sink: SinkFile, Path: dir, From: "littr@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send(Message{To: "jane@example.com", Subject: "Invitation", Body: "Hello"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err = s.Send(Message{To: "jane@example.com\r\nBcc: john@example.com", Subject: "Invitation"}); err == nil {
+		t.Errorf("Send() expected error for invalid recipient")
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 mail file, found %d", len(files))
+	}
+	raw, _ := ioutil.ReadFile(files[0])
+	if !bytes.Contains(raw, []byte("To: jane@example.com\r\n")) {
+		t.Errorf("invalid mail file contents %q", raw)
+	}
+}
+
+func TestLogSender_Send(t *testing.T) {
+	var out string
+	s, _ := New(Config{Sink: SinkLog}, func(f string, args ...interface{}) { out = f })
+	if err := s.Send(Message{To: "jane@example.com", Subject: "Invitation", Body: "Hello"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(out) == 0 {
+		t.Errorf("expected the message to be logged")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	_, err := New(Config{}, nil)
+	s := Disabled(err)
+	if err := s.Send(Message{To: "jane@example.com", Subject: "Invitation", Body: "Hello"}); err == nil {
+		t.Errorf("Send() expected error when the mail delivery is disabled")
+	}
+}